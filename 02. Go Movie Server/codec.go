@@ -0,0 +1,652 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// codec reads and writes one media type. The first of types is the one
+// responses are labelled with; the others are accepted as its aliases.
+type codec struct {
+	types  []string
+	encode func(w io.Writer, v interface{}) error
+	decode func(r io.Reader, v interface{}) error
+}
+
+// errCantDecode is what a codec's decode returns for a value it can't
+// hold, such as a merge patch in CSV.
+var errCantDecode = errors.New("can't decode that into this type")
+
+var (
+	jsonCodec    = &codec{types: []string{"application/json"}, encode: encodeJSON, decode: decodeJSONReader}
+	xmlCodec     = &codec{types: []string{"application/xml", "text/xml"}, encode: encodeXML, decode: decodeXML}
+	csvCodec     = &codec{types: []string{"text/csv"}, encode: encodeCSV, decode: decodeCSV}
+	msgpackCodec = &codec{types: []string{"application/msgpack", "application/x-msgpack", "application/vnd.msgpack"}, encode: encodeMsgpack, decode: decodeMsgpack}
+
+	// codecs in the order the server prefers them, for clients that
+	// like several as much
+	codecs = []*codec{jsonCodec, xmlCodec, csvCodec, msgpackCodec}
+)
+
+func (c *codec) contentType() string {
+	if strings.HasPrefix(c.types[0], "text/") || c == xmlCodec {
+		return c.types[0] + "; charset=utf-8"
+	}
+	return c.types[0]
+}
+
+// mediaTypes lists what the server speaks, for error messages.
+func mediaTypes() string {
+	var names []string
+	for _, c := range codecs {
+		names = append(names, c.types[0])
+	}
+	return strings.Join(names, ", ")
+}
+
+// negotiate answers the movie routes in the format the Accept header
+// asks for, or 406 Not Acceptable if it asks for none the server speaks.
+// Without an Accept header the answer is JSON.
+func negotiate(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept")
+		c := acceptable(r.Header.Get("Accept"))
+		if c == nil {
+			writeError(w, http.StatusNotAcceptable, "can't answer in "+r.Header.Get("Accept")+"; try one of "+mediaTypes())
+			return
+		}
+		h(&codecWriter{ResponseWriter: w, codec: c}, r)
+	}
+}
+
+// codecWriter carries the negotiated codec to respond.
+type codecWriter struct {
+	http.ResponseWriter
+	codec *codec
+}
+
+func (cw *codecWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// respond writes v in the format negotiated for the request, or JSON on
+// routes that don't negotiate.
+func respond(w http.ResponseWriter, status int, v interface{}) {
+	c := jsonCodec
+	if cw, ok := w.(*codecWriter); ok {
+		c = cw.codec
+	}
+	w.Header().Set("Content-Type", c.contentType())
+	w.WriteHeader(status)
+	if err := c.encode(w, v); err != nil {
+		log.Printf("writing a %T as %s: %v", v, c.types[0], err)
+	}
+}
+
+// acceptable picks the codec an Accept header likes best: each media type
+// gets the q-value of the most specific range matching it, and ties go to
+// the server's preference. It returns nil if the header rules out all.
+func acceptable(header string) *codec {
+	if strings.TrimSpace(header) == "" {
+		return jsonCodec
+	}
+	type mediaRange struct {
+		typ, sub string
+		q        float64
+	}
+	var ranges []mediaRange
+	for _, part := range strings.Split(header, ",") {
+		mediaType, params, err := mime.ParseMediaType(part)
+		if err != nil {
+			continue
+		}
+		typ, sub, _ := strings.Cut(mediaType, "/")
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		ranges = append(ranges, mediaRange{typ, sub, q})
+	}
+
+	var best *codec
+	bestQ := 0.0
+	for _, c := range codecs {
+		for _, t := range c.types {
+			typ, sub, _ := strings.Cut(t, "/")
+			q, specificity := 0.0, -1
+			for _, r := range ranges {
+				s := -1
+				switch {
+				case r.typ == typ && r.sub == sub:
+					s = 2
+				case r.typ == typ && r.sub == "*":
+					s = 1
+				case r.typ == "*" && r.sub == "*":
+					s = 0
+				}
+				if s > specificity {
+					q, specificity = r.q, s
+				}
+			}
+			if q > bestQ {
+				best, bestQ = c, q
+			}
+		}
+	}
+	return best
+}
+
+// decodeBody reads a request body into v in the format its Content-Type
+// names, JSON if it names none. Formats the server doesn't read, or can't
+// read into v, are answered with 415 Unsupported Media Type.
+func decodeBody(r *http.Request, v interface{}) error {
+	header := r.Header.Get("Content-Type")
+	if header == "" {
+		return decodeJSON(r, v)
+	}
+	mediaType, _, err := mime.ParseMediaType(header)
+	if err != nil {
+		return &statusError{Status: http.StatusUnsupportedMediaType, Msg: "malformed Content-Type " + header}
+	}
+	if mediaType == "application/merge-patch+json" {
+		mediaType = "application/json"
+	}
+	for _, c := range codecs {
+		if !contains(c.types, mediaType) {
+			continue
+		}
+		err := c.decode(r.Body, v)
+		if err == errCantDecode {
+			break
+		}
+		return err
+	}
+	return &statusError{Status: http.StatusUnsupportedMediaType, Msg: "can't read a request body in " + mediaType + " here"}
+}
+
+func encodeJSON(w io.Writer, v interface{}) error {
+	return json.NewEncoder(w).Encode(v)
+}
+
+// movieList is a page of movies, cut down to fields if any are given.
+// Each format writes it its own way.
+type movieList struct {
+	Movies []Movie
+	Fields []string
+}
+
+func (l movieList) MarshalJSON() ([]byte, error) {
+	if len(l.Fields) == 0 {
+		return json.Marshal(l.Movies)
+	}
+	out := make([]map[string]json.RawMessage, 0, len(l.Movies))
+	for _, m := range l.Movies {
+		data, err := json.Marshal(m)
+		if err != nil {
+			return nil, err
+		}
+		var all map[string]json.RawMessage
+		if err := json.Unmarshal(data, &all); err != nil {
+			return nil, err
+		}
+		picked := map[string]json.RawMessage{"id": all["id"]}
+		for _, f := range l.Fields {
+			if v, ok := all[f]; ok {
+				picked[f] = v
+			}
+		}
+		out = append(out, picked)
+	}
+	return json.Marshal(out)
+}
+
+// project returns the movies with all but their ID and the fields asked
+// for left empty, for the formats that leave out empty fields.
+func (l movieList) project() []Movie {
+	if len(l.Fields) == 0 {
+		return l.Movies
+	}
+	out := make([]Movie, 0, len(l.Movies))
+	for _, m := range l.Movies {
+		p := Movie{ID: m.ID}
+		for _, f := range l.Fields {
+			switch f {
+			case "title":
+				p.Title = m.Title
+			case "year":
+				p.Year = m.Year
+			case "runtime":
+				p.Runtime = m.Runtime
+			case "genres":
+				p.Genres = m.Genres
+			case "directors":
+				p.Directors = m.Directors
+			case "cast":
+				p.Cast = m.Cast
+			case "rating":
+				p.Rating = m.Rating
+			case "synopsis":
+				p.Synopsis = m.Synopsis
+			case "imdb_id":
+				p.IMDbID = m.IMDbID
+			case "tmdb_id":
+				p.TMDBID = m.TMDBID
+			}
+		}
+		out = append(out, p)
+	}
+	return out
+}
+
+func (l movieList) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	start.Name.Local = "movies"
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+	for _, m := range l.project() {
+		if err := e.EncodeElement(m, xml.StartElement{Name: xml.Name{Local: "movie"}}); err != nil {
+			return err
+		}
+	}
+	return e.EncodeToken(start.End())
+}
+
+func (l movieList) EncodeMsgpack(enc *msgpack.Encoder) error {
+	return enc.Encode(l.project())
+}
+
+// xmlMovie is a Movie as XML: its lists have an element each, with an
+// element per item, and are left out when empty.
+type xmlMovie struct {
+	ID      string `xml:"id"`
+	Title   string `xml:"title,omitempty"`
+	Year    int    `xml:"year,omitempty"`
+	Runtime int    `xml:"runtime,omitempty"`
+	Genres  *struct {
+		List []string `xml:"genre"`
+	} `xml:"genres"`
+	Directors *struct {
+		List []Director `xml:"director"`
+	} `xml:"directors"`
+	Cast *struct {
+		List []CastMember `xml:"member"`
+	} `xml:"cast"`
+	Rating   string `xml:"rating,omitempty"`
+	Synopsis string `xml:"synopsis,omitempty"`
+	IMDbID   string `xml:"imdb_id,omitempty"`
+	TMDBID   int    `xml:"tmdb_id,omitempty"`
+}
+
+func (m Movie) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	x := xmlMovie{
+		ID: m.ID, Title: m.Title, Year: m.Year, Runtime: m.Runtime, Rating: m.Rating,
+		Synopsis: m.Synopsis, IMDbID: m.IMDbID, TMDBID: m.TMDBID,
+	}
+	if len(m.Genres) > 0 {
+		x.Genres = &struct {
+			List []string `xml:"genre"`
+		}{m.Genres}
+	}
+	if len(m.Directors) > 0 {
+		x.Directors = &struct {
+			List []Director `xml:"director"`
+		}{m.Directors}
+	}
+	if len(m.Cast) > 0 {
+		x.Cast = &struct {
+			List []CastMember `xml:"member"`
+		}{m.Cast}
+	}
+	return e.EncodeElement(x, start)
+}
+
+func (m *Movie) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	var x xmlMovie
+	if err := d.DecodeElement(&x, &start); err != nil {
+		return err
+	}
+	*m = Movie{
+		ID: x.ID, Title: x.Title, Year: x.Year, Runtime: x.Runtime, Rating: x.Rating,
+		Synopsis: x.Synopsis, IMDbID: x.IMDbID, TMDBID: x.TMDBID,
+	}
+	if x.Genres != nil {
+		m.Genres = x.Genres.List
+	}
+	if x.Directors != nil {
+		m.Directors = x.Directors.List
+	}
+	if x.Cast != nil {
+		m.Cast = x.Cast.List
+	}
+	return nil
+}
+
+// encodeXML writes the responses of the movie routes as XML, each under a
+// root element of its own.
+func encodeXML(w io.Writer, v interface{}) error {
+	io.WriteString(w, xml.Header)
+	enc := xml.NewEncoder(w)
+	element := func(name string) xml.StartElement {
+		return xml.StartElement{Name: xml.Name{Local: name}}
+	}
+	switch v := v.(type) {
+	case Movie:
+		return enc.EncodeElement(v, element("movie"))
+	case movieList:
+		return enc.Encode(v)
+	case []recommendation:
+		return enc.EncodeElement(struct {
+			List []recommendation `xml:"recommendation"`
+		}{v}, element("recommendations"))
+	case Version:
+		return enc.EncodeElement(v, element("version"))
+	case []versionSummary:
+		return enc.EncodeElement(struct {
+			List []versionSummary `xml:"version"`
+		}{v}, element("versions"))
+	case versionDiff:
+		type xmlChange struct {
+			Field string `xml:"field,attr"`
+			From  string `xml:"from"`
+			To    string `xml:"to"`
+		}
+		diff := struct {
+			From    int         `xml:"from,attr"`
+			To      int         `xml:"to,attr"`
+			Changes []xmlChange `xml:"change"`
+		}{From: v.From, To: v.To}
+		for _, c := range v.Changes {
+			diff.Changes = append(diff.Changes, xmlChange{c.Field, changeText(c.From), changeText(c.To)})
+		}
+		return enc.EncodeElement(diff, element("diff"))
+	case apiError:
+		return enc.EncodeElement(v, element("error"))
+	}
+	return fmt.Errorf("xml: can't write a %T", v)
+}
+
+// changeText writes a value of a Change for XML and CSV: strings as they
+// are, anything else as JSON, and nothing for a missing value.
+func changeText(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	}
+	data, _ := json.Marshal(v)
+	return string(data)
+}
+
+// timeText writes the time of a version for CSV, nothing if it is unknown.
+func timeText(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339Nano)
+}
+
+func decodeXML(r io.Reader, v interface{}) error {
+	m, ok := v.(*Movie)
+	if !ok {
+		return errCantDecode
+	}
+	if err := xml.NewDecoder(r).Decode(m); err != nil {
+		if err == io.EOF {
+			return &requestError{Msg: "request body is empty"}
+		}
+		return &requestError{Msg: "malformed XML: " + err.Error()}
+	}
+	return nil
+}
+
+func encodeMsgpack(w io.Writer, v interface{}) error {
+	enc := msgpack.NewEncoder(w)
+	enc.SetCustomStructTag("json")
+	enc.SetOmitEmpty(true)
+	return enc.Encode(v)
+}
+
+func decodeMsgpack(r io.Reader, v interface{}) error {
+	dec := msgpack.NewDecoder(r)
+	dec.SetCustomStructTag("json")
+	if err := dec.Decode(v); err != nil {
+		if err == io.EOF {
+			return &requestError{Msg: "request body is empty"}
+		}
+		return &requestError{Msg: "malformed MessagePack: " + err.Error()}
+	}
+	return nil
+}
+
+// csvColumn is a column of movies in CSV. Lists are joined with "; ",
+// and directors and cast members are flattened into a column for each of
+// their fields, so the first director is the first first name with the
+// first last name.
+type csvColumn struct {
+	name  string
+	field string // the movie field it comes from, for fields=
+	get   func(m *Movie) string
+	set   func(m *Movie, cell string) error
+}
+
+const csvListSep = "; "
+
+var csvColumns = []csvColumn{
+	{"id", "id", func(m *Movie) string { return m.ID }, func(m *Movie, s string) error { m.ID = s; return nil }},
+	{"title", "title", func(m *Movie) string { return m.Title }, func(m *Movie, s string) error { m.Title = s; return nil }},
+	{"year", "year", func(m *Movie) string { return itoa(m.Year) }, func(m *Movie, s string) error { return atoi(&m.Year, "year", s) }},
+	{"runtime", "runtime", func(m *Movie) string { return itoa(m.Runtime) }, func(m *Movie, s string) error { return atoi(&m.Runtime, "runtime", s) }},
+	{"genres", "genres", func(m *Movie) string { return strings.Join(m.Genres, csvListSep) }, func(m *Movie, s string) error { m.Genres = splitCell(s); return nil }},
+	{"director.firstname", "directors",
+		func(m *Movie) string {
+			return joinEach(len(m.Directors), func(i int) string { return m.Directors[i].Firstname })
+		},
+		func(m *Movie, s string) error {
+			for i, v := range splitCell(s) {
+				m.Directors = grow(m.Directors, i)
+				m.Directors[i].Firstname = v
+			}
+			return nil
+		}},
+	{"director.lastname", "directors",
+		func(m *Movie) string {
+			return joinEach(len(m.Directors), func(i int) string { return m.Directors[i].Lastname })
+		},
+		func(m *Movie, s string) error {
+			for i, v := range splitCell(s) {
+				m.Directors = grow(m.Directors, i)
+				m.Directors[i].Lastname = v
+			}
+			return nil
+		}},
+	{"cast.name", "cast",
+		func(m *Movie) string { return joinEach(len(m.Cast), func(i int) string { return m.Cast[i].Name }) },
+		func(m *Movie, s string) error {
+			for i, v := range splitCell(s) {
+				m.Cast = grow(m.Cast, i)
+				m.Cast[i].Name = v
+			}
+			return nil
+		}},
+	{"cast.role", "cast",
+		func(m *Movie) string { return joinEach(len(m.Cast), func(i int) string { return m.Cast[i].Role }) },
+		func(m *Movie, s string) error {
+			for i, v := range splitCell(s) {
+				m.Cast = grow(m.Cast, i)
+				m.Cast[i].Role = v
+			}
+			return nil
+		}},
+	{"rating", "rating", func(m *Movie) string { return m.Rating }, func(m *Movie, s string) error { m.Rating = s; return nil }},
+	{"synopsis", "synopsis", func(m *Movie) string { return m.Synopsis }, func(m *Movie, s string) error { m.Synopsis = s; return nil }},
+	{"imdb_id", "imdb_id", func(m *Movie) string { return m.IMDbID }, func(m *Movie, s string) error { m.IMDbID = s; return nil }},
+	{"tmdb_id", "tmdb_id", func(m *Movie) string { return itoa(m.TMDBID) }, func(m *Movie, s string) error { return atoi(&m.TMDBID, "tmdb_id", s) }},
+}
+
+// columns returns the CSV columns for fields, or all of them.
+func columns(fields []string) []csvColumn {
+	if len(fields) == 0 {
+		return csvColumns
+	}
+	var cols []csvColumn
+	for _, c := range csvColumns {
+		if c.field == "id" || contains(fields, c.field) {
+			cols = append(cols, c)
+		}
+	}
+	return cols
+}
+
+// encodeCSV writes the responses of the movie routes as CSV with a
+// header row: a row per movie, and for an error a row with it.
+func encodeCSV(w io.Writer, v interface{}) error {
+	cw := csv.NewWriter(w)
+	writeMovies := func(list []Movie, cols []csvColumn, extra []string, extraCells func(i int) []string) {
+		header := make([]string, 0, len(cols)+len(extra))
+		for _, c := range cols {
+			header = append(header, c.name)
+		}
+		cw.Write(append(header, extra...))
+		for i := range list {
+			row := make([]string, 0, len(header))
+			for _, c := range cols {
+				row = append(row, c.get(&list[i]))
+			}
+			if extraCells != nil {
+				row = append(row, extraCells(i)...)
+			}
+			cw.Write(row)
+		}
+	}
+
+	switch v := v.(type) {
+	case Movie:
+		writeMovies([]Movie{v}, csvColumns, nil, nil)
+	case movieList:
+		writeMovies(v.Movies, columns(v.Fields), nil, nil)
+	case []recommendation:
+		list := make([]Movie, len(v))
+		for i, rec := range v {
+			list[i] = rec.Movie
+		}
+		writeMovies(list, csvColumns, []string{"score", "because"}, func(i int) []string {
+			return []string{strconv.FormatFloat(v[i].Score, 'f', -1, 64), v[i].Because}
+		})
+	case Version:
+		writeMovies([]Movie{v.Movie}, csvColumns, []string{"version", "time", "op", "reverted_to"}, func(int) []string {
+			return []string{strconv.Itoa(v.N), timeText(v.Time), v.Op, itoa(v.RevertedTo)}
+		})
+	case []versionSummary:
+		cw.Write([]string{"version", "time", "op", "reverted_to", "changed"})
+		for _, s := range v {
+			cw.Write([]string{strconv.Itoa(s.N), timeText(s.Time), s.Op, itoa(s.RevertedTo), strings.Join(s.Changed, "; ")})
+		}
+	case versionDiff:
+		cw.Write([]string{"field", "from", "to"})
+		for _, c := range v.Changes {
+			cw.Write([]string{c.Field, changeText(c.From), changeText(c.To)})
+		}
+	case apiError:
+		cw.Write([]string{"error", "field"})
+		cw.Write([]string{v.Error, v.Field})
+	default:
+		return fmt.Errorf("csv: can't write a %T", v)
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// decodeCSV reads a movie from a header row naming some of the columns
+// encodeCSV writes and a row of values.
+func decodeCSV(r io.Reader, v interface{}) error {
+	m, ok := v.(*Movie)
+	if !ok {
+		return errCantDecode
+	}
+	rows, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return &requestError{Msg: "malformed CSV: " + err.Error()}
+	}
+	if len(rows) != 2 {
+		return &requestError{Msg: "request body must be a header row and a row for the movie"}
+	}
+
+	byName := map[string]csvColumn{}
+	for _, c := range csvColumns {
+		byName[c.name] = c
+	}
+	for i, name := range rows[0] {
+		c, ok := byName[strings.TrimSpace(name)]
+		if !ok {
+			return &requestError{Field: name, Msg: "is not a movie column"}
+		}
+		if err := c.set(m, strings.TrimSpace(rows[1][i])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func itoa(n int) string {
+	if n == 0 {
+		return ""
+	}
+	return strconv.Itoa(n)
+}
+
+func atoi(n *int, field, cell string) error {
+	if cell == "" {
+		*n = 0
+		return nil
+	}
+	v, err := strconv.Atoi(cell)
+	if err != nil {
+		return &requestError{Field: field, Msg: "expected a number, got " + strconv.Quote(cell)}
+	}
+	*n = v
+	return nil
+}
+
+// splitCell splits a list cell; an empty cell is an empty list.
+func splitCell(cell string) []string {
+	if strings.TrimSpace(cell) == "" {
+		return nil
+	}
+	list := strings.Split(cell, ";")
+	for i := range list {
+		list[i] = strings.TrimSpace(list[i])
+	}
+	return list
+}
+
+func joinEach(n int, get func(i int) string) string {
+	list := make([]string, n)
+	for i := range list {
+		list[i] = get(i)
+	}
+	return strings.Join(list, csvListSep)
+}
+
+// grow makes list long enough to have an element i.
+func grow[T any](list []T, i int) []T {
+	for len(list) <= i {
+		var zero T
+		list = append(list, zero)
+	}
+	return list
+}
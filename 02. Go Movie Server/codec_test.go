@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAcceptable(t *testing.T) {
+	for _, c := range []struct {
+		accept string
+		want   *codec
+	}{
+		{"", jsonCodec},
+		{"*/*", jsonCodec},
+		{"text/csv", csvCodec},
+		{"text/xml", xmlCodec},
+		{"application/*;q=0.5, text/csv;q=0.4", jsonCodec},
+		{"application/json;q=0.1, application/x-msgpack", msgpackCodec},
+		{"*/*;q=0.1, application/json;q=0", xmlCodec},
+		{"image/png", nil},
+	} {
+		if got := acceptable(c.accept); got != c.want {
+			t.Errorf("Accept %q: got %v, want %v", c.accept, got, c.want)
+		}
+	}
+}
+
+// TestCodecRoundTrip writes a movie in each format and reads it back.
+func TestCodecRoundTrip(t *testing.T) {
+	m := Movie{
+		ID: "7", Title: "Heat, Again", Year: 1995, Runtime: 170, Genres: []string{"Crime", "Drama"},
+		Directors: []Director{{Firstname: "Michael", Lastname: "Mann"}, {Lastname: "Lee"}},
+		Cast:      []CastMember{{Name: "Al Pacino", Role: "Vincent Hanna"}},
+		Rating:    "R", IMDbID: "tt0113277", TMDBID: 949,
+	}
+	for _, c := range codecs {
+		var buf bytes.Buffer
+		if err := c.encode(&buf, m); err != nil {
+			t.Fatalf("%s: %v", c.types[0], err)
+		}
+		var got Movie
+		if err := c.decode(&buf, &got); err != nil {
+			t.Fatalf("%s: %v", c.types[0], err)
+		}
+		if !reflect.DeepEqual(got, m) {
+			t.Errorf("%s: got  %+v\nwant %+v", c.types[0], got, m)
+		}
+	}
+}
+
+func TestNegotiatedRoutes(t *testing.T) {
+	s := &server{store: NewMemoryStore(), ids: &sequence{}}
+	h := s.routes()
+	do := func(method, target, contentType, accept, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		if contentType != "" {
+			r.Header.Set("Content-Type", contentType)
+		}
+		r.Header.Set("Accept", accept)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	w := do("POST", "/movies", "text/csv", "application/xml", "title,director.lastname\nHeat,Mann\n")
+	if w.Code != http.StatusCreated || !strings.Contains(w.Body.String(), "<lastname>Mann</lastname>") {
+		t.Errorf("CSV in, XML out: got %d %s", w.Code, w.Body)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/xml; charset=utf-8" {
+		t.Errorf("Content-Type %q", ct)
+	}
+
+	w = do("GET", "/movies?fields=title", "", "text/csv", "")
+	if want := "id,title\n1,Heat\n"; w.Body.String() != want {
+		t.Errorf("CSV list: got %q, want %q", w.Body, want)
+	}
+
+	for _, c := range []struct {
+		method, contentType, accept string
+		code                        int
+	}{
+		{"GET", "", "image/png", http.StatusNotAcceptable},
+		{"PUT", "application/x-www-form-urlencoded", "", http.StatusUnsupportedMediaType},
+		{"PATCH", "text/csv", "", http.StatusUnsupportedMediaType},
+		{"PATCH", "application/merge-patch+json", "", http.StatusOK},
+	} {
+		body := `{"title": "Heat"}`
+		if c.contentType == "text/csv" {
+			body = "title\nHeat\n"
+		}
+		if w := do(c.method, "/movies/1", c.contentType, c.accept, body); w.Code != c.code {
+			t.Errorf("%s %s as %s: got %d, want %d: %s", c.method, c.contentType, c.accept, w.Code, c.code, w.Body)
+		}
+	}
+}
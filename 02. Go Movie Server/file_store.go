@@ -0,0 +1,591 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// SyncPolicy says when the write-ahead log is flushed to disk.
+type SyncPolicy string
+
+const (
+	// SyncAlways fsyncs after every mutation, before it is acknowledged.
+	SyncAlways SyncPolicy = "always"
+	// SyncInterval fsyncs in the background every FileStoreOptions.SyncEvery,
+	// so a crash can lose the mutations of the last interval.
+	SyncInterval SyncPolicy = "interval"
+	// SyncNever leaves flushing to the operating system.
+	SyncNever SyncPolicy = "never"
+)
+
+const (
+	snapshotFile = "snapshot.json"
+	walFile      = "wal.log"
+
+	// walHeaderSize is the length and CRC-32 that precede each record.
+	walHeaderSize = 8
+	// maxRecordSize guards recovery against a corrupt length field.
+	maxRecordSize = 16 << 20
+)
+
+type FileStoreOptions struct {
+	Sync      SyncPolicy
+	SyncEvery time.Duration
+	// CompactEvery is the number of logged mutations after which the log
+	// is folded into a new snapshot. Zero disables compaction.
+	CompactEvery int
+}
+
+// walRecord is one logged mutation. Seq increases by one per record, so
+// that records already folded into the snapshot are skipped on replay. Ops
+// on users are prefixed with "user.". Time is when the mutation was made,
+// so that replaying it dates the movie's version the same; records from
+// before it was logged have none.
+type walRecord struct {
+	Seq     uint64    `json:"seq"`
+	Op      string    `json:"op"`
+	Time    time.Time `json:"time"`
+	ID      string    `json:"id,omitempty"`
+	Version int       `json:"version,omitempty"` // for "revert"
+	Movie   *Movie    `json:"movie,omitempty"`
+	User    *User     `json:"user,omitempty"`
+}
+
+type snapshot struct {
+	Seq     uint64               `json:"seq"`
+	Movies  []Movie              `json:"movies"`
+	History map[string][]Version `json:"history,omitempty"`
+	Users   []User               `json:"users,omitempty"`
+}
+
+// fileStore is a MovieStore and UserStore that keeps its data in a memoryStore and makes
+// it durable in dir: every mutation is appended to a write-ahead log, which
+// is compacted into a snapshot every CompactEvery records. On open, the
+// snapshot is loaded and the log replayed over it; a torn record at the end
+// of the log, left by a crash in the middle of a write, is truncated away.
+type fileStore struct {
+	*memoryStore
+
+	dir  string
+	opts FileStoreOptions
+
+	// mu serialises mutations, so that the log has them in the same order
+	// as they are applied to memory.
+	mu      sync.Mutex
+	wal     logFile
+	size    int64
+	seq     uint64
+	logged  int
+	dirty   bool
+	closed  chan struct{}
+	once    sync.Once
+	stopped sync.WaitGroup
+}
+
+// logFile is the part of an *os.File the write-ahead log is written
+// through; tests stand in for it to make a sync fail.
+type logFile interface {
+	io.Writer
+	io.Seeker
+	Truncate(size int64) error
+	Sync() error
+	Close() error
+}
+
+func OpenFileStore(dir string, opts FileStoreOptions) (*fileStore, error) {
+	switch opts.Sync {
+	case SyncAlways, SyncNever:
+	case SyncInterval:
+		if opts.SyncEvery <= 0 {
+			return nil, errors.New("file store: interval sync needs a positive interval")
+		}
+	default:
+		return nil, fmt.Errorf("file store: unknown sync policy %q", opts.Sync)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	s := &fileStore{
+		memoryStore: NewMemoryStore(),
+		dir:         dir,
+		opts:        opts,
+		closed:      make(chan struct{}),
+	}
+	if err := s.loadSnapshot(); err != nil {
+		return nil, err
+	}
+	if err := s.replay(); err != nil {
+		return nil, err
+	}
+
+	if opts.Sync == SyncInterval {
+		s.stopped.Add(1)
+		go s.syncLoop()
+	}
+	return s, nil
+}
+
+func (s *fileStore) loadSnapshot() error {
+	data, err := os.ReadFile(filepath.Join(s.dir, snapshotFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var snap snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return fmt.Errorf("file store: reading snapshot: %w", err)
+	}
+	for _, m := range snap.Movies {
+		// snapshots from before history was kept have none, so the movies
+		// start out with an undated version
+		if err := s.memoryStore.create(m, time.Time{}, nil); err != nil {
+			return fmt.Errorf("file store: snapshot movie %q: %w", m.ID, err)
+		}
+	}
+	s.memoryStore.restoreVersions(snap.History)
+	for _, u := range snap.Users {
+		if err := s.memoryStore.CreateUser(u); err != nil {
+			return fmt.Errorf("file store: snapshot user %q: %w", u.ID, err)
+		}
+	}
+	s.seq = snap.Seq
+	return nil
+}
+
+// replay applies the log records newer than the snapshot and leaves the log
+// open for appending, truncated after the last complete record. Only a torn
+// record at the end is cut off: a bad record with more of the log after it
+// means the log is corrupt, and truncating it would drop the good records
+// that follow, so opening fails instead.
+func (s *fileStore) replay() error {
+	f, err := os.OpenFile(filepath.Join(s.dir, walFile), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	var good int64
+	r := bufio.NewReader(f)
+	for {
+		rec, n, err := readRecord(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			torn, terr := tornTail(f, good, n, info.Size())
+			if terr != nil || !torn {
+				f.Close()
+				return fmt.Errorf("file store: log corrupt at offset %d, with records after it: %v", good, err)
+			}
+			log.Printf("file store: truncating torn record at offset %d: %v", good, err)
+			break
+		}
+		if rec.Seq > s.seq {
+			if err := s.apply(rec); err != nil {
+				f.Close()
+				return fmt.Errorf("file store: replaying record %d: %w", rec.Seq, err)
+			}
+			s.seq = rec.Seq
+			s.logged++
+		}
+		good += n
+	}
+
+	if err := f.Truncate(good); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Seek(good, io.SeekStart); err != nil {
+		f.Close()
+		return err
+	}
+	s.wal = f
+	s.size = good
+	return nil
+}
+
+// readRecord reads one record and returns it with its size on disk. A clean
+// end of the log is io.EOF; anything short or corrupt is another error,
+// returned with the size the record claims, or just its header's if that
+// is out of range.
+func readRecord(r io.Reader) (walRecord, int64, error) {
+	var rec walRecord
+
+	header := make([]byte, walHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return rec, walHeaderSize, errors.New("torn record header")
+		}
+		return rec, 0, err
+	}
+	size := binary.BigEndian.Uint32(header)
+	sum := binary.BigEndian.Uint32(header[4:])
+	if size > maxRecordSize {
+		return rec, walHeaderSize, fmt.Errorf("record length %d out of range", size)
+	}
+
+	n := int64(walHeaderSize + size)
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return rec, n, errors.New("torn record payload")
+	}
+	if crc32.ChecksumIEEE(payload) != sum {
+		return rec, n, errors.New("record checksum mismatch")
+	}
+	if err := json.Unmarshal(payload, &rec); err != nil {
+		return rec, n, err
+	}
+	return rec, n, nil
+}
+
+// tornTail reports whether a bad record of n bytes at offset off in a log
+// of the given size can be the remains of a write a crash cut short: the
+// last one in the log, or one with nothing but zeros from its start on, as
+// a file system may leave where the write didn't land.
+func tornTail(f *os.File, off, n, size int64) (bool, error) {
+	if off+n >= size {
+		return true, nil
+	}
+	buf := make([]byte, 32<<10)
+	rest := io.NewSectionReader(f, off, size-off)
+	for {
+		k, err := rest.Read(buf)
+		for _, b := range buf[:k] {
+			if b != 0 {
+				return false, nil
+			}
+		}
+		if err == io.EOF {
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+}
+
+func (s *fileStore) apply(rec walRecord) error {
+	switch rec.Op {
+	case "create":
+		return s.memoryStore.create(*rec.Movie, rec.Time, nil)
+	case "update":
+		return s.memoryStore.update(*rec.Movie, rec.Time, nil)
+	case "revert":
+		_, err := s.memoryStore.revert(rec.ID, rec.Version, rec.Time, nil)
+		return err
+	case "delete":
+		return s.memoryStore.Delete(rec.ID)
+	case "user.create":
+		return s.memoryStore.CreateUser(*rec.User)
+	case "user.update":
+		return s.memoryStore.updateUser(*rec.User)
+	case "user.delete":
+		return s.memoryStore.DeleteUser(rec.ID)
+	}
+	return fmt.Errorf("unknown operation %q", rec.Op)
+}
+
+// append writes rec to the log as a single write, so that a crash leaves at
+// most one torn record at the end. The caller holds s.mu.
+func (s *fileStore) append(rec walRecord) error {
+	rec.Seq = s.seq + 1
+	payload, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	buf := make([]byte, walHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(buf, uint32(len(payload)))
+	binary.BigEndian.PutUint32(buf[4:], crc32.ChecksumIEEE(payload))
+	copy(buf[walHeaderSize:], payload)
+	if _, err := s.wal.Write(buf); err != nil {
+		s.unwrite()
+		return err
+	}
+
+	if s.opts.Sync == SyncAlways {
+		if err := s.wal.Sync(); err != nil {
+			s.unwrite()
+			return err
+		}
+	} else {
+		s.dirty = true
+	}
+	s.size += int64(len(buf))
+	s.seq = rec.Seq
+	s.logged++
+	return nil
+}
+
+// unwrite cuts off whatever part of a failed record made it into the log,
+// so that it isn't replayed and later records don't end up behind it.
+func (s *fileStore) unwrite() {
+	s.wal.Truncate(s.size)
+	s.wal.Seek(s.size, io.SeekStart)
+}
+
+// mutate logs rec and applies it to memory. check runs first, under the
+// same lock, so that only mutations that will succeed are logged.
+func (s *fileStore) mutate(rec walRecord, check func() error) error {
+	return s.mutateWith(func() (walRecord, error) {
+		return rec, check()
+	})
+}
+
+// mutateWith is mutate for records that depend on the current state: next
+// makes the record under the lock, or fails to leave everything alone.
+func (s *fileStore) mutateWith(next func() (walRecord, error)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.wal == nil {
+		return errors.New("file store is closed")
+	}
+	rec, err := next()
+	if err != nil {
+		return err
+	}
+	rec.Time = time.Now().UTC()
+	if err := s.append(rec); err != nil {
+		return err
+	}
+	if err := s.apply(rec); err != nil {
+		return err
+	}
+
+	if s.opts.CompactEvery > 0 && s.logged >= s.opts.CompactEvery {
+		if err := s.compact(); err != nil {
+			// The log still has everything, so the mutation stands.
+			log.Printf("file store: compaction failed: %v", err)
+		}
+	}
+	return nil
+}
+
+func (s *fileStore) Create(m Movie) error {
+	m = m.clone()
+	return s.mutate(walRecord{Op: "create", Movie: &m}, func() error {
+		if _, err := s.memoryStore.Get(m.ID); err == nil {
+			return ErrExists
+		}
+		return s.memoryStore.checkIMDb(m)
+	})
+}
+
+func (s *fileStore) Update(m Movie) error {
+	m = m.clone()
+	return s.mutate(walRecord{Op: "update", Movie: &m}, func() error {
+		if _, err := s.memoryStore.Get(m.ID); err != nil {
+			return err
+		}
+		return s.memoryStore.checkIMDb(m)
+	})
+}
+
+func (s *fileStore) Modify(id string, fn func(Movie) (Movie, error)) (Movie, error) {
+	var m Movie
+	err := s.mutateWith(func() (walRecord, error) {
+		old, err := s.memoryStore.Get(id)
+		if err != nil {
+			return walRecord{}, err
+		}
+		if m, err = fn(old); err != nil {
+			return walRecord{}, err
+		}
+		m.ID = id
+		m = m.clone()
+		return walRecord{Op: "update", Movie: &m}, s.memoryStore.checkIMDb(m)
+	})
+	if err != nil {
+		return Movie{}, err
+	}
+	return m.clone(), nil
+}
+
+func (s *fileStore) Revert(id string, n int) (Movie, error) {
+	var m Movie
+	err := s.mutateWith(func() (walRecord, error) {
+		versions, err := s.memoryStore.Versions(id)
+		if err != nil {
+			return walRecord{}, err
+		}
+		v, ok := find(versions, n)
+		if !ok {
+			return walRecord{}, ErrNoVersion
+		}
+		m = v.Movie
+		return walRecord{Op: "revert", ID: id, Version: n}, s.memoryStore.checkIMDb(m)
+	})
+	if err != nil {
+		return Movie{}, err
+	}
+	return m, nil
+}
+
+func (s *fileStore) Delete(id string) error {
+	return s.mutate(walRecord{Op: "delete", ID: id}, func() error {
+		_, err := s.memoryStore.Get(id)
+		return err
+	})
+}
+
+func (s *fileStore) CreateUser(u User) error {
+	u = u.clone()
+	return s.mutate(walRecord{Op: "user.create", User: &u}, func() error {
+		if _, err := s.memoryStore.GetUser(u.ID); err == nil {
+			return ErrUserExists
+		}
+		return nil
+	})
+}
+
+func (s *fileStore) ModifyUser(id string, fn func(User) (User, error)) (User, error) {
+	var u User
+	err := s.mutateWith(func() (walRecord, error) {
+		old, err := s.memoryStore.GetUser(id)
+		if err != nil {
+			return walRecord{}, err
+		}
+		if u, err = fn(old); err != nil {
+			return walRecord{}, err
+		}
+		u.ID = id
+		u = u.clone()
+		return walRecord{Op: "user.update", User: &u}, nil
+	})
+	if err != nil {
+		return User{}, err
+	}
+	return u.clone(), nil
+}
+
+func (s *fileStore) DeleteUser(id string) error {
+	return s.mutate(walRecord{Op: "user.delete", ID: id}, func() error {
+		_, err := s.memoryStore.GetUser(id)
+		return err
+	})
+}
+
+// compact writes the current state to a new snapshot and empties the log.
+// The snapshot replaces the old one atomically; if the process dies before
+// the log is truncated, replay skips the records the snapshot already has.
+// The caller holds s.mu.
+func (s *fileStore) compact() error {
+	data, err := json.Marshal(snapshot{
+		Seq:     s.seq,
+		Movies:  s.memoryStore.List(),
+		History: s.memoryStore.allVersions(),
+		Users:   s.memoryStore.ListUsers(),
+	})
+	if err != nil {
+		return err
+	}
+
+	tmp := filepath.Join(s.dir, snapshotFile+".tmp")
+	if err := writeFileSync(tmp, data); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(s.dir, snapshotFile)); err != nil {
+		return err
+	}
+	if err := syncDir(s.dir); err != nil {
+		return err
+	}
+
+	if err := s.wal.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := s.wal.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	s.size = 0
+	s.logged = 0
+	s.dirty = false
+	return nil
+}
+
+func writeFileSync(name string, data []byte) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// syncDir makes a rename in dir durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+func (s *fileStore) syncLoop() {
+	defer s.stopped.Done()
+
+	t := time.NewTicker(s.opts.SyncEvery)
+	defer t.Stop()
+	for {
+		select {
+		case <-s.closed:
+			return
+		case <-t.C:
+			s.mu.Lock()
+			if s.dirty && s.wal != nil {
+				if err := s.wal.Sync(); err != nil {
+					log.Printf("file store: syncing log: %v", err)
+				} else {
+					s.dirty = false
+				}
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+// Close flushes and closes the log. The store can't be mutated afterwards.
+func (s *fileStore) Close() error {
+	s.once.Do(func() { close(s.closed) })
+	s.stopped.Wait()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.wal == nil {
+		return nil
+	}
+	err := s.wal.Sync()
+	if cerr := s.wal.Close(); err == nil {
+		err = cerr
+	}
+	s.wal = nil
+	return err
+}
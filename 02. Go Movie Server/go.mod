@@ -2,4 +2,11 @@ module movieserver
 
 go 1.23.2
 
-require github.com/gorilla/mux v1.8.1
+require (
+	github.com/andybalholm/brotli v1.1.1
+	github.com/gorilla/mux v1.8.1
+	github.com/vmihailenco/msgpack/v5 v5.4.1
+	golang.org/x/image v0.18.0
+)
+
+require github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
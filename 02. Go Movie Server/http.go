@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// apiError is the JSON body of every error response. Field names the
+// offending request field, when there is one.
+type apiError struct {
+	Error string `json:"error" xml:"message"`
+	Field string `json:"field,omitempty" xml:"field,omitempty"`
+}
+
+// requestError is a client mistake in a request body, answered with 400.
+type requestError struct {
+	Field string
+	Msg   string
+}
+
+func (e *requestError) Error() string {
+	if e.Field == "" {
+		return e.Msg
+	}
+	return e.Field + ": " + e.Msg
+}
+
+// statusError is a failure other than a bad request body that calls for a
+// particular status, such as 409 for a movie already on a watchlist.
+type statusError struct {
+	Status int
+	Msg    string
+	Field  string
+}
+
+func (e *statusError) Error() string {
+	return e.Msg
+}
+
+// writeError sends msg as an error body with the given status, in the
+// format negotiated for the request.
+func writeError(w http.ResponseWriter, status int, msg string) {
+	respond(w, status, apiError{Error: msg})
+}
+
+// writeRequestError answers a failed decodeBody or validation with 400,
+// or with the status of a statusError such as 415 for a body in a format
+// the server doesn't read.
+func writeRequestError(w http.ResponseWriter, err error) {
+	var re *requestError
+	var se *statusError
+	switch {
+	case errors.As(err, &re):
+		respond(w, http.StatusBadRequest, apiError{Error: re.Msg, Field: re.Field})
+	case errors.As(err, &se):
+		respond(w, se.Status, apiError{Error: se.Msg, Field: se.Field})
+	default:
+		writeError(w, http.StatusBadRequest, err.Error())
+	}
+}
+
+// writeStoreError maps a store error to its status; id is the movie or
+// user it is about. requestErrors and statusErrors, as returned through
+// Modify, are answered as they say; an ErrIMDbTaken points at the movie
+// that has the IMDb ID.
+func writeStoreError(w http.ResponseWriter, err error, id string) {
+	var re *requestError
+	var se *statusError
+	if errors.As(err, &re) || errors.As(err, &se) {
+		writeRequestError(w, err)
+		return
+	}
+	var taken *ErrIMDbTaken
+	if errors.As(err, &taken) {
+		w.Header().Set("Location", "/movies/"+url.PathEscape(taken.ID))
+		respond(w, http.StatusConflict, apiError{Error: err.Error(), Field: "imdb_id"})
+		return
+	}
+
+	switch err {
+	case ErrNotFound:
+		writeError(w, http.StatusNotFound, "movie "+id+" not found")
+	case ErrExists:
+		writeError(w, http.StatusConflict, "movie "+id+" already exists")
+	case ErrNoVersion:
+		writeError(w, http.StatusNotFound, "movie "+id+" has no such version")
+	case ErrUserNotFound:
+		writeError(w, http.StatusNotFound, "user "+id+" not found")
+	case ErrUserExists:
+		writeError(w, http.StatusConflict, "user "+id+" already exists")
+	default:
+		writeError(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// decodeJSON reads a single JSON value from the request body into v. Its
+// errors are requestErrors saying where the body went wrong.
+func decodeJSON(r *http.Request, v interface{}) error {
+	return decodeJSONReader(r.Body, v)
+}
+
+func decodeJSONReader(r io.Reader, v interface{}) error {
+	dec := json.NewDecoder(r)
+	if err := dec.Decode(v); err != nil {
+		return jsonError(err)
+	}
+	if dec.More() {
+		return &requestError{Msg: "request body must hold a single JSON value"}
+	}
+	return nil
+}
+
+// jsonError turns an error from decoding a request body into a
+// requestError.
+func jsonError(err error) error {
+	var syntax *json.SyntaxError
+	var typ *json.UnmarshalTypeError
+	switch {
+	case err == io.EOF:
+		return &requestError{Msg: "request body is empty"}
+	case err == io.ErrUnexpectedEOF:
+		return &requestError{Msg: "request body is truncated"}
+	case errors.As(err, &syntax):
+		return &requestError{Msg: fmt.Sprintf("malformed JSON at offset %d: %v", syntax.Offset, err)}
+	case errors.As(err, &typ):
+		return &requestError{
+			Field: typ.Field,
+			Msg:   fmt.Sprintf("expected %s, got JSON %s", jsonType(typ.Type.Kind().String()), typ.Value),
+		}
+	}
+	return &requestError{Msg: err.Error()}
+}
+
+// jsonType names a Go kind the way a JSON client would know it.
+func jsonType(kind string) string {
+	switch {
+	case kind == "string":
+		return "a string"
+	case kind == "bool":
+		return "a boolean"
+	case kind == "slice" || kind == "array":
+		return "an array"
+	case kind == "struct" || kind == "map" || kind == "ptr":
+		return "an object"
+	case strings.HasPrefix(kind, "int"), strings.HasPrefix(kind, "uint"), strings.HasPrefix(kind, "float"):
+		return "a number"
+	}
+	return kind
+}
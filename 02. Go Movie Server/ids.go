@@ -0,0 +1,139 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// IDGenerator makes IDs for new movies and users. IDs from one generator
+// never repeat, but the server still checks them against the store, since
+// movies and users can come with IDs of their own.
+type IDGenerator interface {
+	NewID() string
+}
+
+// NewIDGenerator returns the generator for scheme: "sequence", "uuidv7" or
+// "ulid". A sequence continues after the largest numeric ID in existing.
+func NewIDGenerator(scheme string, existing []string) (IDGenerator, error) {
+	switch scheme {
+	case "sequence":
+		var last uint64
+		for _, id := range existing {
+			if n, err := strconv.ParseUint(id, 10, 64); err == nil && n > last {
+				last = n
+			}
+		}
+		return &sequence{last: last}, nil
+	case "uuidv7":
+		return &uuidV7{}, nil
+	case "ulid":
+		return &ulid{}, nil
+	}
+	return nil, fmt.Errorf("unknown ID scheme %q", scheme)
+}
+
+// sequence hands out 1, 2, 3, ...
+type sequence struct {
+	mu   sync.Mutex
+	last uint64
+}
+
+func (s *sequence) NewID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.last++
+	return strconv.FormatUint(s.last, 10)
+}
+
+// timeRandom is the shared core of the UUIDv7 and ULID generators: a 48-bit
+// Unix millisecond timestamp followed by 80 random bits. Within the same
+// millisecond the random part is incremented instead of redrawn, so IDs
+// from one generator sort in creation order and never repeat.
+type timeRandom struct {
+	mu     sync.Mutex
+	ms     uint64
+	random [10]byte
+}
+
+func (g *timeRandom) next() [16]byte {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	ms := uint64(time.Now().UnixMilli())
+	if ms > g.ms {
+		g.ms = ms
+		if _, err := rand.Read(g.random[:]); err != nil {
+			panic(err)
+		}
+	} else {
+		// The clock stood still or went back: keep the last timestamp,
+		// moving it on only if the random part runs over.
+		if increment(g.random[:]) {
+			g.ms++
+		}
+	}
+
+	var id [16]byte
+	binary.BigEndian.PutUint64(id[:8], g.ms<<16)
+	copy(id[6:], g.random[:])
+	return id
+}
+
+// increment adds one to the big-endian number b and reports whether it
+// wrapped around to zero.
+func increment(b []byte) bool {
+	for i := len(b) - 1; i >= 0; i-- {
+		b[i]++
+		if b[i] != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// uuidV7 makes RFC 9562 version 7 UUIDs.
+type uuidV7 struct{ timeRandom }
+
+func (g *uuidV7) NewID() string {
+	id := g.next()
+	id[6] = 0x70 | id[6]&0x0f // version 7
+	id[8] = 0x80 | id[8]&0x3f // variant 10
+
+	var s [36]byte
+	hex.Encode(s[0:8], id[0:4])
+	s[8] = '-'
+	hex.Encode(s[9:13], id[4:6])
+	s[13] = '-'
+	hex.Encode(s[14:18], id[6:8])
+	s[18] = '-'
+	hex.Encode(s[19:23], id[8:10])
+	s[23] = '-'
+	hex.Encode(s[24:], id[10:])
+	return string(s[:])
+}
+
+// ulid makes ULIDs: the same 128 bits in Crockford's base32.
+type ulid struct{ timeRandom }
+
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+func (g *ulid) NewID() string {
+	id := g.next()
+
+	// 26 characters of 5 bits hold 130 bits; the first carries only 3.
+	var s [26]byte
+	hi := binary.BigEndian.Uint64(id[:8])
+	lo := binary.BigEndian.Uint64(id[8:])
+	for i := 25; i >= 0; i-- {
+		s[i] = crockford[lo&31]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(s[:])
+}
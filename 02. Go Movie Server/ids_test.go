@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestIDGenerators(t *testing.T) {
+	for _, c := range []struct {
+		scheme   string
+		existing []string
+		first    string // "" if the first ID isn't known in advance
+		pattern  string
+		ordered  bool // whether IDs sort as strings in the order they were made
+	}{
+		{"sequence", nil, "1", `^[0-9]+$`, false},
+		{"sequence", []string{"3", "heat", "12"}, "13", `^[0-9]+$`, false},
+		{"uuidv7", nil, "", `^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`, true},
+		{"ulid", nil, "", `^[0-7][0-9A-HJKMNP-TV-Z]{25}$`, true},
+	} {
+		g, err := NewIDGenerator(c.scheme, c.existing)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pattern := regexp.MustCompile(c.pattern)
+		seen := map[string]bool{}
+		var last string
+		// many more than fit in a millisecond, so that the random part is
+		// incremented too
+		for i := 0; i < 10000; i++ {
+			id := g.NewID()
+			if i == 0 && c.first != "" && id != c.first {
+				t.Errorf("%s after %q: first ID %s, want %s", c.scheme, c.existing, id, c.first)
+			}
+			if !pattern.MatchString(id) {
+				t.Fatalf("%s: ID %q doesn't match %s", c.scheme, id, c.pattern)
+			}
+			if seen[id] {
+				t.Fatalf("%s: ID %s repeats", c.scheme, id)
+			}
+			if c.ordered && id <= last {
+				t.Fatalf("%s: ID %s sorts before %s, made before it", c.scheme, id, last)
+			}
+			seen[id] = true
+			last = id
+		}
+	}
+
+	if _, err := NewIDGenerator("random", nil); err == nil {
+		t.Error("an unknown scheme gave no error")
+	}
+}
+
+func TestIncrement(t *testing.T) {
+	for _, c := range []struct {
+		in, want []byte
+		wrapped  bool
+	}{
+		{[]byte{0, 0}, []byte{0, 1}, false},
+		{[]byte{0, 0xff}, []byte{1, 0}, false},
+		{[]byte{0xff, 0xff}, []byte{0, 0}, true},
+	} {
+		b := append([]byte(nil), c.in...)
+		if wrapped := increment(b); string(b) != string(c.want) || wrapped != c.wrapped {
+			t.Errorf("increment(%x) = %x, %v; want %x, %v", c.in, b, wrapped, c.want, c.wrapped)
+		}
+	}
+}
+
+// Clients can choose IDs, and generated ones must not take theirs.
+func TestCreateWithID(t *testing.T) {
+	s := &server{store: NewMemoryStore(), ids: &sequence{}}
+	h := s.routes()
+
+	for _, c := range []struct {
+		body string
+		code int
+		id   string
+	}{
+		{`{"id": "heat", "title": "Heat", "directors": [{"lastname": "Mann"}]}`, http.StatusCreated, "heat"},
+		{`{"id": "heat", "title": "Heat", "directors": [{"lastname": "Mann"}]}`, http.StatusConflict, ""},
+		{`{"id": "2", "title": "Thief", "directors": [{"lastname": "Mann"}]}`, http.StatusCreated, "2"},
+		{`{"title": "Collateral", "directors": [{"lastname": "Mann"}]}`, http.StatusCreated, "1"},
+		{`{"title": "Ali", "directors": [{"lastname": "Mann"}]}`, http.StatusCreated, "3"},
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("POST", "/movies", strings.NewReader(c.body)))
+		var m Movie
+		json.Unmarshal(w.Body.Bytes(), &m)
+		if w.Code != c.code || (c.id != "" && m.ID != c.id) {
+			t.Errorf("POST %s: got %d with %s, want %d with ID %q", c.body, w.Code, w.Body, c.code, c.id)
+		}
+	}
+	if n := len(s.store.List()); n != 4 {
+		t.Errorf("%d movies stored, want 4", n)
+	}
+}
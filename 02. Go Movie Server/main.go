@@ -1,127 +1,567 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"github.com/gorilla/mux"
 	"log"
-	"math/rand"
 	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"syscall"
+	"time"
 )
 
-type Movie struct {
-	ID       string    `json:"id"`
-	ISBN     string    `json:"isbn"`
-	Title    string    `json:"title"`
-	Director *Director `json:"director"`
+// server holds the handlers' dependencies, so that nothing is shared
+// through package level state.
+type server struct {
+	store    MovieStore
+	ids      IDGenerator
+	users    UserStore
+	userIDs  IDGenerator
+	metadata MetadataProvider // nil if importing isn't configured
+	recs     *Recommender     // nil if recommendations aren't computed
+	media    MediaStore       // nil if posters and trailers can't be uploaded
 }
 
-type Director struct {
-	Firstname string `json:"firstname"`
-	Lastname  string `json:"lastname"`
-}
+func main() {
+	store, closeStore, err := openStore()
+	if err != nil {
+		log.Fatal(err)
+	}
+	var movieIDs, userIDs []string
+	for _, m := range store.List() {
+		movieIDs = append(movieIDs, m.ID)
+	}
+	for _, u := range store.ListUsers() {
+		userIDs = append(userIDs, u.ID)
+	}
+	scheme := getenv("MOVIES_ID_SCHEME", "sequence")
+	ids, err := NewIDGenerator(scheme, movieIDs)
+	if err != nil {
+		log.Fatal(err)
+	}
+	userIDGen, err := NewIDGenerator(scheme, userIDs)
+	if err != nil {
+		log.Fatal(err)
+	}
+	media, err := openMedia()
+	if err != nil {
+		log.Fatal(err)
+	}
+	s := &server{store: store, ids: ids, users: store, userIDs: userIDGen, metadata: openMetadata(), media: media}
 
-var movies []Movie
+	// some sample movies, only for a store that has none yet
+	if len(store.List()) == 0 {
+		for _, m := range []Movie{
+			{ID: "1", Title: "Movie One", Year: 1999, Runtime: 104, Genres: []string{"Drama"}, Rating: "PG",
+				Directors: []Director{{Firstname: "John", Lastname: "Doe"}},
+				Cast:      []CastMember{{Name: "Alice Example", Role: "Alice"}}},
+			{ID: "2", Title: "Movie Two", Year: 2008, Runtime: 118, Genres: []string{"Action", "Thriller"}, Rating: "R",
+				Directors: []Director{{Firstname: "Steve", Lastname: "Smith"}},
+				Cast:      []CastMember{{Name: "Bob Example", Role: "Agent Bob"}}},
+			{ID: "3", Title: "Movie Three", Year: 2015, Runtime: 95, Genres: []string{"Comedy", "Drama"}, Rating: "PG-13",
+				Directors: []Director{{Firstname: "Jane", Lastname: "Doe"}, {Firstname: "Steve", Lastname: "Smith"}},
+				Cast:      []CastMember{{Name: "Alice Example", Role: "Carol"}, {Name: "Bob Example", Role: "Dave"}}},
+		} {
+			s.store.Create(m)
+		}
+	}
 
-func main() {
-	r := mux.NewRouter()
+	// stop on Ctrl+C or docker stop, letting the store flush its log
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// the similarities are computed once before serving, then kept up to
+	// date as movies and ratings change
+	s.recs = NewRecommender(store, store)
+	s.recs.Refresh()
+	go s.recs.Run(ctx)
+
+	router := s.routes()
+	stack, err := middleware(router)
+	if err != nil {
+		log.Fatal(err)
+	}
+	srv := &http.Server{Addr: ":8080", Handler: Chain(router, stack...)}
+	servers := []*http.Server{srv}
+
+	// HTTPS if a certificate is configured, with HTTP/2 and an optional
+	// listener sending plain HTTP clients over
+	certs, err := openTLS()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if certs != nil {
+		srv.Addr = getenv("MOVIES_ADDR", ":8443")
+		srv.TLSConfig = certs.TLSConfig()
+		go certs.Run(ctx)
+		if addr := os.Getenv("MOVIES_REDIRECT_ADDR"); addr != "" {
+			servers = append(servers, &http.Server{Addr: addr, Handler: redirectToHTTPS(srv.Addr)})
+		}
+	} else {
+		srv.Addr = getenv("MOVIES_ADDR", ":8080")
+	}
+
+	for _, srv := range servers {
+		go func(srv *http.Server) {
+			var err error
+			if srv.TLSConfig != nil {
+				fmt.Println("Server is running on: https://localhost" + srv.Addr)
+				err = srv.ListenAndServeTLS("", "")
+			} else {
+				fmt.Println("Server is running on: http://localhost" + srv.Addr)
+				err = srv.ListenAndServe()
+			}
+			if err != http.ErrServerClosed {
+				log.Fatal(err)
+			}
+		}(srv)
+	}
 
-	// some sample movies	(Get-Content .\main.go -Raw) -replace "`0", "" | Set-Content .\main.go
-	movies = append(movies,
-		Movie{ID: "1", ISBN: "123456", Title: "Movie One", Director: &Director{Firstname: "John", Lastname: "Doe"}},
-		Movie{ID: "2", ISBN: "654321", Title: "Movie Two", Director: &Director{Firstname: "Steve", Lastname: "Smith"}},
-		Movie{ID: "3", ISBN: "987654", Title: "Movie Three", Director: &Director{Firstname: "Jane", Lastname: "Doe"}},
-	)
+	<-ctx.Done()
+
+	shutdown, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for _, srv := range servers {
+		srv.Shutdown(shutdown)
+	}
+	if err := closeStore(); err != nil {
+		log.Println(err)
+	}
+}
+
+func (s *server) routes() *mux.Router {
+	r := mux.NewRouter()
 
 	// Routes
-	r.HandleFunc("/movies", getMovies).Methods("GET") // ✅
-	r.HandleFunc("/movies/{id}", getMovie).Methods("GET") // ✅
-	r.HandleFunc("/movies", createMovie).Methods("POST") // ✅
-	r.HandleFunc("/movies/{id}", updateMovie).Methods("PUT") // ✅
-	r.HandleFunc("/movies/{id}", deleteMovie).Methods("DELETE") // ✅
+	r.HandleFunc("/movies", negotiate(s.getMovies)).Methods("GET") // ✅
+	r.HandleFunc("/movies/{id}", negotiate(s.getMovie)).Methods("GET") // ✅
+	r.HandleFunc("/movies", negotiate(s.createMovie)).Methods("POST") // ✅
+	r.HandleFunc("/movies/import", negotiate(s.importMovie)).Methods("POST") // ✅
+	r.HandleFunc("/movies/{id}", negotiate(s.updateMovie)).Methods("PUT") // ✅
+	r.HandleFunc("/movies/{id}", negotiate(s.patchMovie)).Methods("PATCH") // ✅
+	r.HandleFunc("/movies/{id}", negotiate(s.deleteMovie)).Methods("DELETE") // ✅
+	r.HandleFunc("/movies/{id}/similar", negotiate(s.similarMovies)).Methods("GET") // ✅
+	r.HandleFunc("/movies/{id}/versions", negotiate(s.getVersions)).Methods("GET") // ✅
+	r.HandleFunc("/movies/{id}/versions/{n:[0-9]+}", negotiate(s.getVersion)).Methods("GET") // ✅
+	r.HandleFunc("/movies/{id}/diff", negotiate(s.diffVersions)).Methods("GET") // ✅
+	r.HandleFunc("/movies/{id}/revert/{n:[0-9]+}", negotiate(s.revertMovie)).Methods("POST") // ✅
+	r.HandleFunc("/movies/{id}/{kind:poster|trailer}", s.uploadMedia).Methods("PUT") // ✅
+	r.HandleFunc("/movies/{id}/{kind:poster|trailer}", s.getMedia).Methods("GET", "HEAD") // ✅
+	r.HandleFunc("/movies/{id}/{kind:poster|trailer}", s.deleteMedia).Methods("DELETE") // ✅
+
+	r.HandleFunc("/users", s.getUsers).Methods("GET") // ✅
+	r.HandleFunc("/users", s.createUser).Methods("POST") // ✅
+	r.HandleFunc("/users/{id}", s.getUser).Methods("GET") // ✅
+	r.HandleFunc("/users/{id}", s.updateUser).Methods("PUT") // ✅
+	r.HandleFunc("/users/{id}", s.deleteUser).Methods("DELETE") // ✅
+	r.HandleFunc("/users/{id}/watchlist", s.getWatchlist).Methods("GET") // ✅
+	r.HandleFunc("/users/{id}/watchlist", s.addToWatchlist).Methods("POST") // ✅
+	r.HandleFunc("/users/{id}/watchlist", s.reorderWatchlist).Methods("PUT") // ✅
+	r.HandleFunc("/users/{id}/watchlist/{movieId}", s.removeFromWatchlist).Methods("DELETE") // ✅
+	r.HandleFunc("/users/{id}/history", s.getHistory).Methods("GET") // ✅
+	r.HandleFunc("/users/{id}/history", s.addViewing).Methods("POST") // ✅
+	r.HandleFunc("/users/{id}/history/{viewingId}", s.deleteViewing).Methods("DELETE") // ✅
+	r.HandleFunc("/users/{id}/recommendations", s.userRecommendations).Methods("GET") // ✅
 
-	fmt.Println("Server is running on: http://localhost:8080")
-	log.Fatal(http.ListenAndServe(":8080", r))
+	return r
 }
 
-func getMovies(w http.ResponseWriter, r *http.Request) {
-	// if r.URL.Path != "/movies" {
-	// 	http.Error(w, "400 Not Found", http.StatusBadRequest)
-	// }
-	// if r.Method != "GET" {
-	// 	http.Error(w, "Method is not supported", http.StatusMethodNotAllowed)
-	// 	return
-	// }
+// openStore returns the store configured by the environment: a file store
+// in MOVIES_DATA_DIR, or an in-memory one that is lost on restart if that
+// isn't set. MOVIES_FSYNC picks the sync policy (always, interval or never),
+// MOVIES_FSYNC_INTERVAL its period and MOVIES_COMPACT_EVERY how many logged
+// mutations trigger a snapshot.
+func openStore() (Store, func() error, error) {
+	dir := os.Getenv("MOVIES_DATA_DIR")
+	if dir == "" {
+		return NewMemoryStore(), func() error { return nil }, nil
+	}
+
+	opts := FileStoreOptions{
+		Sync:         SyncPolicy(getenv("MOVIES_FSYNC", string(SyncInterval))),
+		SyncEvery:    time.Second,
+		CompactEvery: 1000,
+	}
+	if v := os.Getenv("MOVIES_FSYNC_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, nil, fmt.Errorf("MOVIES_FSYNC_INTERVAL: %w", err)
+		}
+		opts.SyncEvery = d
+	}
+	if v := os.Getenv("MOVIES_COMPACT_EVERY"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, nil, fmt.Errorf("MOVIES_COMPACT_EVERY: %w", err)
+		}
+		opts.CompactEvery = n
+	}
 
-	// setting header to json, so that the webbrowser or postman can understand that its an json
-	w.Header().Set("Content-Type", "application/json")
-	// encoding the movies slice into json to send json
-	json.NewEncoder(w).Encode(movies)
+	fs, err := OpenFileStore(dir, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	return fs, fs.Close, nil
 }
 
-func deleteMovie(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
+// middleware returns the middleware stack configured by the environment,
+// outermost first:
+//   - security headers, with MOVIES_HSTS_MAX_AGE (default a year, 0 for
+//     none) and MOVIES_CSP;
+//   - CORS for the comma separated origins in MOVIES_CORS_ORIGINS, if set,
+//     with MOVIES_CORS_CREDENTIALS and MOVIES_CORS_MAX_AGE;
+//   - compression of responses from MOVIES_COMPRESS_MIN_SIZE bytes
+//     (default 1024), or none if it is "off".
+func middleware(router *mux.Router) ([]Middleware, error) {
+	security := SecurityOptions{
+		HSTSMaxAge:            365 * 24 * time.Hour,
+		HSTSIncludeSubdomains: true,
+		ContentSecurityPolicy: os.Getenv("MOVIES_CSP"),
+	}
+	if v := os.Getenv("MOVIES_HSTS_MAX_AGE"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("MOVIES_HSTS_MAX_AGE: %w", err)
+		}
+		security.HSTSMaxAge = d
+	}
+	stack := []Middleware{SecurityHeaders(security)}
 
-	params := mux.Vars(r) // params --> /movies/{id}
+	if v := os.Getenv("MOVIES_CORS_ORIGINS"); v != "" {
+		cors := CORSOptions{MaxAge: 10 * time.Minute}
+		for _, o := range strings.Split(v, ",") {
+			if o = strings.TrimSpace(o); o != "" {
+				cors.AllowedOrigins = append(cors.AllowedOrigins, o)
+			}
+		}
+		if v := os.Getenv("MOVIES_CORS_CREDENTIALS"); v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return nil, fmt.Errorf("MOVIES_CORS_CREDENTIALS: %w", err)
+			}
+			cors.AllowCredentials = b
+		}
+		if v := os.Getenv("MOVIES_CORS_MAX_AGE"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return nil, fmt.Errorf("MOVIES_CORS_MAX_AGE: %w", err)
+			}
+			cors.MaxAge = d
+		}
+		mw, err := CORS(router, cors)
+		if err != nil {
+			return nil, fmt.Errorf("MOVIES_CORS_ORIGINS: %w", err)
+		}
+		stack = append(stack, mw)
+	}
 
-	for i, item := range movies {
-		if item.ID == params["id"] {
-			movies = append(movies[:i], movies[i+1:]...)
-			break
+	if v := getenv("MOVIES_COMPRESS_MIN_SIZE", "1024"); v != "off" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("MOVIES_COMPRESS_MIN_SIZE: %w", err)
 		}
+		stack = append(stack, Compress(n))
 	}
+	return stack, nil
+}
+
+// openMedia returns the store for posters and trailers: the directory in
+// MOVIES_MEDIA_DIR, by default "media" in MOVIES_DATA_DIR, or in the
+// working directory without one.
+func openMedia() (MediaStore, error) {
+	dir := os.Getenv("MOVIES_MEDIA_DIR")
+	if dir == "" {
+		dir = filepath.Join(os.Getenv("MOVIES_DATA_DIR"), "media")
+	}
+	return NewDiskMediaStore(dir)
+}
 
-	json.NewEncoder(w).Encode(movies)
+// openTLS returns the certificate configured by the environment, or nil
+// to serve plain HTTP if MOVIES_TLS_CERT isn't set. MOVIES_TLS_KEY is its
+// key, and MOVIES_TLS_CLIENT_CA the CAs client certificates must be signed
+// by, if any are asked for; MOVIES_TLS_CLIENT_AUTH=optional also lets
+// clients without one in.
+func openTLS() (*certReloader, error) {
+	cert := os.Getenv("MOVIES_TLS_CERT")
+	if cert == "" {
+		return nil, nil
+	}
+	opts := TLSOptions{
+		CertFile:     cert,
+		KeyFile:      os.Getenv("MOVIES_TLS_KEY"),
+		ClientCAFile: os.Getenv("MOVIES_TLS_CLIENT_CA"),
+	}
+	switch v := os.Getenv("MOVIES_TLS_CLIENT_AUTH"); v {
+	case "", "require":
+	case "optional":
+		opts.ClientAuth = tls.VerifyClientCertIfGiven
+	default:
+		return nil, fmt.Errorf("MOVIES_TLS_CLIENT_AUTH: %q is neither require nor optional", v)
+	}
+	return NewCertReloader(opts)
 }
 
-func getMovie(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
+// openMetadata returns the TMDB client configured by the environment, or
+// nil if TMDB_API_TOKEN isn't set. TMDB_BASE_URL points it at another
+// TMDB compatible API.
+func openMetadata() MetadataProvider {
+	token := os.Getenv("TMDB_API_TOKEN")
+	if token == "" {
+		return nil
+	}
+	tmdb := NewTMDB(TMDBOptions{
+		BaseURL: os.Getenv("TMDB_BASE_URL"),
+		Token:   token,
+		Retries: 3,
+	})
+	return NewCachedProvider(tmdb, 24*time.Hour, time.Hour)
+}
 
-	params := mux.Vars(r)
+func getenv(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+func (s *server) getMovies(w http.ResponseWriter, r *http.Request) {
+	// the query string filters, sorts and pages the list, see query.go
+	q, err := parseQuery(r.URL.RawQuery)
+	if err != nil {
+		writeRequestError(w, err)
+		return
+	}
+	list, total, next, err := q.Run(s.store)
+	if err != nil {
+		writeRequestError(w, err)
+		return
+	}
+	// the body stays a plain list; paging goes in the headers
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	if next != "" {
+		w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"next\"", nextPage(r.URL, next)))
+	}
+	respond(w, http.StatusOK, q.Project(list))
+}
+
+// nextPage is u with its offset or cursor replaced by cursor.
+func nextPage(u *url.URL, cursor string) string {
+	var parts []string
+	for _, p := range strings.Split(u.RawQuery, "&") {
+		if p != "" && !strings.HasPrefix(p, "cursor=") && !strings.HasPrefix(p, "offset=") {
+			parts = append(parts, p)
+		}
+	}
+	parts = append(parts, "cursor="+cursor)
+	return u.Path + "?" + strings.Join(parts, "&")
+}
 
-	for _, item := range movies {
-		if item.ID == params["id"] {
-			json.NewEncoder(w).Encode(item)
-			return
+func (s *server) deleteMovie(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r) // params --> /movies/{id}
+
+	if err := s.store.Delete(params["id"]); err != nil {
+		writeStoreError(w, err, params["id"])
+		return
+	}
+	s.touch(params["id"])
+	if s.media != nil {
+		if err := s.media.DeleteAll(params["id"]); err != nil {
+			log.Printf("deleting the media of movie %s: %v", params["id"], err)
 		}
 	}
+
+	// nothing left to show, so no body
+	w.WriteHeader(http.StatusNoContent)
 }
 
-func createMovie(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
+func (s *server) getMovie(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+
+	// ?at= asks for the movie as it was then, see version_handlers.go
+	if when := r.URL.Query().Get("at"); when != "" {
+		s.movieAt(w, params["id"], when)
+		return
+	}
+
+	item, err := s.store.Get(params["id"])
+	if err != nil {
+		writeStoreError(w, err, params["id"])
+		return
+	}
+
+	respond(w, http.StatusOK, item)
+}
 
+func (s *server) createMovie(w http.ResponseWriter, r *http.Request) {
 	var movie Movie
 
 	// r.Body - return the body fron the request in json
-	// json.NewDecoder used to decode the json same as JSON.parse in js
-	// .Decode will put in movie struct
-	_ = json.NewDecoder(r.Body).Decode(&movie)
+	// decodeBody works like JSON.parse in js and puts it in movie struct,
+	// telling the client what is wrong if it can't; the body can also be
+	// XML, CSV or MessagePack, as its Content-Type says
+	if err := decodeBody(r, &movie); err != nil {
+		writeRequestError(w, err)
+		return
+	}
+	if err := movie.Validate(); err != nil {
+		writeRequestError(w, err)
+		return
+	}
 
-	// Itoa --> int to str
-	movie.ID = strconv.Itoa(rand.Intn(10000000))
-	movies = append(movies, movie)
+	movie, err := s.insert(movie)
+	if err != nil {
+		writeStoreError(w, err, movie.ID)
+		return
+	}
+	s.touch(movie.ID)
 
-	json.NewEncoder(w).Encode(movie)
+	// 201 tells the client where the new movie lives
+	w.Header().Set("Location", "/movies/"+url.PathEscape(movie.ID))
+	respond(w, http.StatusCreated, movie)
+}
+
+// insert stores a new movie under its own ID, which must be free, or under
+// a generated one if it has none.
+func (s *server) insert(movie Movie) (Movie, error) {
+	// generated IDs can still be taken by a client supplied one, so skip
+	// ahead until one is free
+	generated := movie.ID == ""
+	for {
+		if generated {
+			movie.ID = s.ids.NewID()
+		}
+		err := s.store.Create(movie)
+		if err == ErrExists && generated {
+			continue
+		}
+		return movie, err
+	}
 }
 
-func updateMovie(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
+// importMovie creates a movie from the metadata provider's record for the
+// IMDb ID in the query string.
+func (s *server) importMovie(w http.ResponseWriter, r *http.Request) {
+	if s.metadata == nil {
+		writeError(w, http.StatusServiceUnavailable, "importing is not configured; set TMDB_API_TOKEN")
+		return
+	}
+	imdbID := r.URL.Query().Get("imdb_id")
+	if !imdbIDPattern.MatchString(imdbID) {
+		writeRequestError(w, &requestError{Field: "imdb_id", Msg: `must look like "tt0111161"`})
+		return
+	}
+
+	// one movie per IMDb ID; importing it again points at the first. This
+	// only spares the provider a request: the store makes sure of it
+	if m, err := s.store.ByIMDb(imdbID); err == nil {
+		writeStoreError(w, &ErrIMDbTaken{IMDbID: imdbID, ID: m.ID}, "")
+		return
+	}
+
+	movie, err := s.metadata.LookupIMDb(r.Context(), imdbID)
+	var limited *ErrRateLimited
+	switch {
+	case err == ErrNoMetadata:
+		writeError(w, http.StatusNotFound, "no movie "+imdbID+" at the metadata provider")
+		return
+	case errors.As(err, &limited):
+		w.Header().Set("Retry-After", strconv.Itoa(int(limited.RetryAfter.Seconds()+0.5)))
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	case err != nil:
+		writeError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+
+	// the provider's data has to pass the same checks as a client's
+	if err := movie.Validate(); err != nil {
+		var re *requestError
+		errors.As(err, &re)
+		respond(w, http.StatusUnprocessableEntity, apiError{Error: "imported movie is incomplete: " + re.Msg, Field: re.Field})
+		return
+	}
+
+	movie.ID = ""
+	movie, err = s.insert(movie)
+	if err != nil {
+		writeStoreError(w, err, movie.ID)
+		return
+	}
+	s.touch(movie.ID)
 
+	w.Header().Set("Location", "/movies/"+url.PathEscape(movie.ID))
+	respond(w, http.StatusCreated, movie)
+}
+
+func (s *server) updateMovie(w http.ResponseWriter, r *http.Request) {
 	var movie Movie
 
 	params := mux.Vars(r)
-	_ = json.NewDecoder(r.Body).Decode(&movie)
+	if err := decodeBody(r, &movie); err != nil {
+		writeRequestError(w, err)
+		return
+	}
 
+	// the ID comes from the URL; a different one in the body is a mistake
+	if movie.ID != "" && movie.ID != params["id"] {
+		writeRequestError(w, &requestError{Field: "id", Msg: "does not match the movie in the URL"})
+		return
+	}
 	movie.ID = params["id"]
 
-	for i, item := range movies {
-		if item.ID == params["id"] {
-			movies = append(movies[:i], movies[i+1:]...) // delete exiting movie
-		}
+	// PUT replaces the whole movie, so a missing field isn't kept from the
+	// old one but fails validation; PATCH is there to change only some
+	if err := movie.Validate(); err != nil {
+		writeRequestError(w, err)
+		return
+	}
+
+	// Update keeps the movie where it was in the list
+	if err := s.store.Update(movie); err != nil {
+		writeStoreError(w, err, movie.ID)
+		return
+	}
+	s.touch(movie.ID)
+
+	respond(w, http.StatusOK, movie)
+}
+
+// patchMovie merges a partial movie into the stored one, as a JSON merge
+// patch: only the fields sent change, nested director fields included, and
+// a null removes a field.
+func (s *server) patchMovie(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+
+	var patch map[string]interface{}
+	if err := decodeBody(r, &patch); err != nil {
+		writeRequestError(w, err)
+		return
+	}
+	if id, ok := patch["id"]; ok && id != params["id"] {
+		writeRequestError(w, &requestError{Field: "id", Msg: "does not match the movie in the URL"})
+		return
 	}
 
-	movies = append(movies, movie)
+	movie, err := s.store.Modify(params["id"], func(m Movie) (Movie, error) {
+		m, err := patchMovie(m, patch)
+		if err != nil {
+			return m, err
+		}
+		return m, m.Validate()
+	})
+	if err != nil {
+		writeStoreError(w, err, params["id"])
+		return
+	}
+	s.touch(movie.ID)
 
-	json.NewEncoder(w).Encode(movie)
-}
\ No newline at end of file
+	respond(w, http.StatusOK, movie)
+}
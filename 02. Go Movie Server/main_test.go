@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMovieStatus(t *testing.T) {
+	s := &server{store: NewMemoryStore(), ids: &sequence{}}
+	s.store.Create(Movie{ID: "1", Title: "Heat", Directors: []Director{{Lastname: "Mann"}}})
+	h := s.routes()
+
+	for _, c := range []struct {
+		method, target, body string
+		code                 int
+		location             string
+		field                string // the field a 400 names
+	}{
+		{"GET", "/movies/1", "", http.StatusOK, "", ""},
+		{"GET", "/movies/9", "", http.StatusNotFound, "", ""},
+		{"POST", "/movies", `{"title": "Thief", "directors": [{"lastname": "Mann"}]}`, http.StatusCreated, "/movies/2", ""},
+		{"POST", "/movies", `{"id": "a b", "title": "Ali", "directors": [{"lastname": "Mann"}]}`, http.StatusCreated, "/movies/a%20b", ""},
+		{"POST", "/movies", ``, http.StatusBadRequest, "", ""},
+		{"POST", "/movies", `{"title": "Thief"`, http.StatusBadRequest, "", ""},
+		{"POST", "/movies", `{"title": 7}`, http.StatusBadRequest, "", "title"},
+		{"POST", "/movies", `{"title": "Thief"} {}`, http.StatusBadRequest, "", ""},
+		{"POST", "/movies", `{"title": "", "directors": [{"lastname": "Mann"}]}`, http.StatusBadRequest, "", "title"},
+		{"PUT", "/movies/9", `{"title": "Heat", "directors": [{"lastname": "Mann"}]}`, http.StatusNotFound, "", ""},
+		{"PUT", "/movies/1", `{"id": "2", "title": "Heat", "directors": [{"lastname": "Mann"}]}`, http.StatusBadRequest, "", "id"},
+		{"PATCH", "/movies/9", `{"year": 1995}`, http.StatusNotFound, "", ""},
+		{"DELETE", "/movies/9", "", http.StatusNotFound, "", ""},
+		{"DELETE", "/movies/1", "", http.StatusNoContent, "", ""},
+		{"GET", "/movies/1", "", http.StatusNotFound, "", ""},
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(c.method, c.target, strings.NewReader(c.body)))
+		name := c.method + " " + c.target + " " + c.body
+		if w.Code != c.code {
+			t.Errorf("%s: got %d with %s, want %d", name, w.Code, w.Body, c.code)
+			continue
+		}
+		// the sequence skips 1, which is taken
+		if got := w.Header().Get("Location"); got != c.location {
+			t.Errorf("%s: Location %q, want %q", name, got, c.location)
+		}
+		if c.code == http.StatusNoContent && w.Body.Len() != 0 {
+			t.Errorf("%s: a body with 204: %s", name, w.Body)
+		}
+		if c.code >= 400 {
+			var e apiError
+			if err := json.Unmarshal(w.Body.Bytes(), &e); err != nil || e.Error == "" || e.Field != c.field {
+				t.Errorf("%s: error %s, want one for field %q", name, w.Body, c.field)
+			}
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// ErrNoMedia means a movie has no file of the name asked for.
+var ErrNoMedia = errors.New("no such media")
+
+// MediaStore keeps the files attached to movies, such as posters and
+// trailers, each under its movie's ID and a name.
+type MediaStore interface {
+	// Put stores what r holds under movieID and name, replacing any file
+	// there, and returns info completed with the file's size, ETag and
+	// modification time. A failed read of r leaves the old file in place.
+	Put(movieID, name string, info MediaInfo, r io.Reader) (MediaInfo, error)
+	// Open returns the file under movieID and name with its info, or
+	// ErrNoMedia.
+	Open(movieID, name string) (MediaFile, MediaInfo, error)
+	// Delete removes the file, or returns ErrNoMedia if there is none.
+	Delete(movieID, name string) error
+	// DeleteAll removes all of a movie's files.
+	DeleteAll(movieID string) error
+}
+
+// MediaFile is an open media file; http.ServeContent reads ranges of it.
+type MediaFile interface {
+	io.ReadSeekCloser
+}
+
+// MediaInfo describes a stored file.
+type MediaInfo struct {
+	ContentType string    `json:"content_type"`
+	Filename    string    `json:"filename"` // offered to clients that save it
+	Size        int64     `json:"size"`
+	ETag        string    `json:"etag"`
+	ModTime     time.Time `json:"mod_time"`
+}
+
+// diskMediaStore keeps each movie's files in a directory of their own,
+// next to a JSON file with the info of each.
+type diskMediaStore struct {
+	dir string
+	// mu keeps a file and its info from being replaced in between reading
+	// one and the other.
+	mu sync.RWMutex
+}
+
+func NewDiskMediaStore(dir string) (MediaStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &diskMediaStore{dir: dir}, nil
+}
+
+// path returns where a movie's file of the given name lives. Movie IDs
+// are escaped, and prefixed so that none can be "." or "..".
+func (s *diskMediaStore) path(movieID, name string) string {
+	return filepath.Join(s.movieDir(movieID), name)
+}
+
+func (s *diskMediaStore) movieDir(movieID string) string {
+	return filepath.Join(s.dir, "movie-"+url.PathEscape(movieID))
+}
+
+func (s *diskMediaStore) Put(movieID, name string, info MediaInfo, r io.Reader) (MediaInfo, error) {
+	dir := s.movieDir(movieID)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return info, err
+	}
+
+	// write to a temporary file first, so a failed upload doesn't destroy
+	// the file it was to replace
+	tmp, err := os.CreateTemp(dir, name+".*.tmp")
+	if err != nil {
+		return info, err
+	}
+	defer os.Remove(tmp.Name())
+	h := sha256.New()
+	info.Size, err = io.Copy(io.MultiWriter(tmp, h), r)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return info, err
+	}
+	info.ETag = `"` + hex.EncodeToString(h.Sum(nil))[:32] + `"`
+	info.ModTime = time.Now().UTC().Truncate(time.Second)
+
+	meta, err := json.Marshal(info)
+	if err != nil {
+		return info, err
+	}
+	metaTmp := tmp.Name() + ".json"
+	if err := os.WriteFile(metaTmp, meta, 0o644); err != nil {
+		return info, err
+	}
+	defer os.Remove(metaTmp)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := os.Rename(tmp.Name(), s.path(movieID, name)); err != nil {
+		return info, err
+	}
+	return info, os.Rename(metaTmp, s.path(movieID, name)+".json")
+}
+
+func (s *diskMediaStore) Open(movieID, name string) (MediaFile, MediaInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var info MediaInfo
+	meta, err := os.ReadFile(s.path(movieID, name) + ".json")
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, info, ErrNoMedia
+	}
+	if err != nil {
+		return nil, info, err
+	}
+	if err := json.Unmarshal(meta, &info); err != nil {
+		return nil, info, err
+	}
+	f, err := os.Open(s.path(movieID, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, info, ErrNoMedia
+	}
+	return f, info, err
+}
+
+func (s *diskMediaStore) Delete(movieID, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := os.Remove(s.path(movieID, name) + ".json")
+	if errors.Is(err, os.ErrNotExist) {
+		return ErrNoMedia
+	}
+	if err != nil {
+		return err
+	}
+	return os.Remove(s.path(movieID, name))
+}
+
+func (s *diskMediaStore) DeleteAll(movieID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return os.RemoveAll(s.movieDir(movieID))
+}
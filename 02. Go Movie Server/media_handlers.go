@@ -0,0 +1,410 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/gorilla/mux"
+	xdraw "golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// mediaKind is a kind of file a movie can have attached.
+type mediaKind struct {
+	name    string
+	maxSize int64
+	// types are the content types accepted, as sniffed from the file
+	// rather than as the client says, with the extension each gets.
+	types map[string]string
+}
+
+var (
+	posterKind  = &mediaKind{name: "poster", maxSize: 10 << 20, types: map[string]string{"image/jpeg": ".jpg", "image/png": ".png", "image/webp": ".webp"}}
+	trailerKind = &mediaKind{name: "trailer", maxSize: 2 << 30, types: map[string]string{"video/mp4": ".mp4", "video/webm": ".webm"}}
+	mediaKinds  = map[string]*mediaKind{"poster": posterKind, "trailer": trailerKind}
+)
+
+func (k *mediaKind) typeList() string {
+	var list []string
+	for t := range k.types {
+		list = append(list, t)
+	}
+	sort.Strings(list)
+	return strings.Join(list, ", ")
+}
+
+// posterWidths are the widths poster thumbnails are made in, TMDB's.
+var posterWidths = []int{92, 185, 342, 500}
+
+// maxPosterPixels keeps a small file that unpacks into a huge image from
+// taking all the memory.
+const maxPosterPixels = 40_000_000
+
+// thumbnailName is the media name of a poster thumbnail.
+func thumbnailName(width int) string {
+	return "poster-w" + strconv.Itoa(width)
+}
+
+// mediaView is what the upload routes answer with.
+type mediaView struct {
+	URL         string `json:"url"`
+	ContentType string `json:"content_type"`
+	Filename    string `json:"filename"`
+	Size        int64  `json:"size"`
+	ETag        string `json:"etag"`
+	// Sizes are the URLs of a poster's thumbnails by width, such as
+	// "w185"; widths the poster is too small for are left out.
+	Sizes map[string]string `json:"sizes,omitempty"`
+}
+
+// uploadMedia stores a poster or trailer for a movie, replacing the one it
+// had. The file is the request body, or the "file" field of a multipart
+// form as browsers send it.
+func (s *server) uploadMedia(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	id, kind := params["id"], mediaKinds[params["kind"]]
+	if s.media == nil {
+		writeError(w, http.StatusServiceUnavailable, "media uploads are not configured")
+		return
+	}
+	m, err := s.store.Get(id)
+	if err != nil {
+		writeStoreError(w, err, id)
+		return
+	}
+
+	body, filename, err := uploadedFile(w, r, kind.maxSize)
+	if err != nil {
+		writeRequestError(w, err)
+		return
+	}
+
+	// what the file is, is up to its contents, not to what the client
+	// says it is
+	br := bufio.NewReaderSize(body, 512)
+	head, err := br.Peek(512)
+	if err != nil && err != io.EOF {
+		writeRequestError(w, uploadError(err, kind))
+		return
+	}
+	if len(head) == 0 {
+		writeRequestError(w, &requestError{Field: "file", Msg: "is empty"})
+		return
+	}
+	contentType := http.DetectContentType(head)
+	ext, ok := kind.types[contentType]
+	if !ok {
+		writeRequestError(w, &statusError{Status: http.StatusUnsupportedMediaType, Field: "file",
+			Msg: fmt.Sprintf("a %s must be one of %s, not %s", kind.name, kind.typeList(), contentType)})
+		return
+	}
+	if filename == "" {
+		filename = slug(m.Title) + "-" + kind.name + ext
+	}
+
+	existing, _, err := s.media.Open(id, kind.name)
+	status := http.StatusCreated
+	if err == nil {
+		existing.Close()
+		status = http.StatusOK
+	}
+
+	info := MediaInfo{ContentType: contentType, Filename: filename}
+	view := mediaView{URL: "/movies/" + url.PathEscape(id) + "/" + kind.name}
+	if kind == posterKind {
+		info, view.Sizes, err = s.putPoster(id, info, br, view.URL)
+	} else {
+		info, err = s.media.Put(id, kind.name, info, br)
+	}
+	if err != nil {
+		writeRequestError(w, uploadError(err, kind))
+		return
+	}
+
+	view.ContentType, view.Filename, view.Size, view.ETag = info.ContentType, info.Filename, info.Size, info.ETag
+	w.Header().Set("Location", view.URL)
+	writeJSON(w, status, view)
+}
+
+// putPoster stores a poster and thumbnails of it, and returns their URLs.
+// Posters are read whole, as they have to be decoded for the thumbnails;
+// their size limit keeps that cheap.
+func (s *server) putPoster(id string, info MediaInfo, r io.Reader, posterURL string) (MediaInfo, map[string]string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return info, nil, err
+	}
+	thumbs, err := thumbnails(data)
+	if err != nil {
+		return info, nil, err
+	}
+	info, err = s.media.Put(id, posterKind.name, info, bytes.NewReader(data))
+	if err != nil {
+		return info, nil, err
+	}
+
+	base := strings.TrimSuffix(info.Filename, filepath.Ext(info.Filename))
+	sizes := map[string]string{}
+	for _, width := range posterWidths {
+		name := thumbnailName(width)
+		thumb, ok := thumbs[width]
+		if !ok {
+			// drop the thumbnail of a larger poster this one replaces
+			if err := s.media.Delete(id, name); err != nil && err != ErrNoMedia {
+				return info, nil, err
+			}
+			continue
+		}
+		thumbInfo := MediaInfo{ContentType: "image/jpeg", Filename: fmt.Sprintf("%s-w%d.jpg", base, width)}
+		if _, err := s.media.Put(id, name, thumbInfo, bytes.NewReader(thumb)); err != nil {
+			return info, nil, err
+		}
+		size := "w" + strconv.Itoa(width)
+		sizes[size] = posterURL + "?size=" + size
+	}
+	return info, sizes, nil
+}
+
+// thumbnails scales a poster down to each of posterWidths narrower than
+// it, as JPEGs, on white where the poster is transparent.
+func thumbnails(data []byte) (map[int][]byte, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, &statusError{Status: http.StatusUnprocessableEntity, Field: "file", Msg: "is not an image that can be read: " + err.Error()}
+	}
+	if config.Width*config.Height > maxPosterPixels {
+		return nil, &statusError{Status: http.StatusUnprocessableEntity, Field: "file",
+			Msg: fmt.Sprintf("is %dx%d; posters can have at most %d pixels", config.Width, config.Height, maxPosterPixels)}
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, &statusError{Status: http.StatusUnprocessableEntity, Field: "file", Msg: "is not an image that can be read: " + err.Error()}
+	}
+
+	thumbs := map[int][]byte{}
+	for _, width := range posterWidths {
+		if width >= config.Width {
+			continue
+		}
+		height := max(1, config.Height*width/config.Width)
+		dst := image.NewRGBA(image.Rect(0, 0, width, height))
+		draw.Draw(dst, dst.Bounds(), image.White, image.Point{}, draw.Src)
+		xdraw.CatmullRom.Scale(dst, dst.Bounds(), img, img.Bounds(), xdraw.Over, nil)
+
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 85}); err != nil {
+			return nil, err
+		}
+		thumbs[width] = buf.Bytes()
+	}
+	return thumbs, nil
+}
+
+// errTooLarge is what an upload's reader returns past its size limit.
+var errTooLarge = errors.New("upload too large")
+
+// uploadedFile returns the file in a request and the name the client gave
+// it, if any: the body, named by ?filename=, or the "file" part of a
+// multipart form. Reading more than max bytes of it fails with
+// errTooLarge.
+func uploadedFile(w http.ResponseWriter, r *http.Request, max int64) (io.Reader, string, error) {
+	// room for the multipart framing around the file
+	r.Body = http.MaxBytesReader(w, r.Body, max+1<<20)
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		return &sizeLimit{r: r.Body, n: max}, cleanFilename(r.URL.Query().Get("filename")), nil
+	}
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, "", &requestError{Msg: "malformed multipart form: " + err.Error()}
+	}
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return nil, "", &requestError{Field: "file", Msg: "is required"}
+		}
+		if err != nil {
+			return nil, "", &requestError{Msg: "malformed multipart form: " + err.Error()}
+		}
+		if part.FormName() == "file" {
+			return &sizeLimit{r: part, n: max}, cleanFilename(part.FileName()), nil
+		}
+	}
+}
+
+// sizeLimit reads from r until more than n bytes were read, and then
+// fails with errTooLarge.
+type sizeLimit struct {
+	r io.Reader
+	n int64
+}
+
+func (l *sizeLimit) Read(p []byte) (int, error) {
+	if l.n < 0 {
+		return 0, errTooLarge
+	}
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1]
+	}
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	if l.n < 0 {
+		return n, errTooLarge
+	}
+	return n, err
+}
+
+// uploadError turns an error reading or storing an upload into one for
+// the client.
+func uploadError(err error, kind *mediaKind) error {
+	var maxBytes *http.MaxBytesError
+	var re *requestError
+	var se *statusError
+	switch {
+	case errors.Is(err, errTooLarge), errors.As(err, &maxBytes):
+		return &statusError{Status: http.StatusRequestEntityTooLarge, Field: "file",
+			Msg: fmt.Sprintf("a %s can be at most %d MiB", kind.name, kind.maxSize>>20)}
+	case errors.As(err, &re), errors.As(err, &se):
+		return err
+	}
+	log.Printf("storing a %s: %v", kind.name, err)
+	return &statusError{Status: http.StatusInternalServerError, Msg: "the " + kind.name + " could not be stored"}
+}
+
+// cleanFilename keeps the base name of what a client calls its file,
+// without control characters.
+func cleanFilename(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, `\`, "/"))
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || r == '"' {
+			return -1
+		}
+		return r
+	}, name)
+	if name == "." || name == "/" {
+		return ""
+	}
+	return name
+}
+
+// slug makes a file name out of a movie title.
+func slug(title string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(title) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+			dash = false
+		} else if !dash && b.Len() > 0 {
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+	s := strings.TrimSuffix(b.String(), "-")
+	if s == "" {
+		return "movie"
+	}
+	return s
+}
+
+// getMedia serves a movie's poster or trailer with http.ServeContent, so
+// range requests and conditional GETs work. ?size=w185 asks for a poster
+// thumbnail, or the poster itself if it is no wider; ?download offers the
+// file to save rather than show.
+func (s *server) getMedia(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	id, kind := params["id"], mediaKinds[params["kind"]]
+	if s.media == nil {
+		writeError(w, http.StatusServiceUnavailable, "media uploads are not configured")
+		return
+	}
+
+	name := kind.name
+	if size := r.URL.Query().Get("size"); size != "" {
+		width, err := strconv.Atoi(strings.TrimPrefix(size, "w"))
+		if kind != posterKind || !strings.HasPrefix(size, "w") || err != nil || !containsInt(posterWidths, width) {
+			writeRequestError(w, &requestError{Field: "size", Msg: "must be one of w92, w185, w342 or w500, for posters"})
+			return
+		}
+		name = thumbnailName(width)
+	}
+
+	f, info, err := s.media.Open(id, name)
+	if err == ErrNoMedia && name != kind.name {
+		f, info, err = s.media.Open(id, kind.name)
+	}
+	switch {
+	case err == ErrNoMedia:
+		writeError(w, http.StatusNotFound, "movie "+id+" has no "+kind.name)
+		return
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer f.Close()
+
+	disposition := "inline"
+	if r.URL.Query().Has("download") {
+		disposition = "attachment"
+	}
+	h := w.Header()
+	h.Set("Content-Type", info.ContentType)
+	h.Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": info.Filename}))
+	h.Set("ETag", info.ETag)
+	// clients keep the file but ask whether it is still current
+	h.Set("Cache-Control", "no-cache")
+	http.ServeContent(w, r, info.Filename, info.ModTime, f)
+}
+
+func containsInt(list []int, n int) bool {
+	for _, v := range list {
+		if v == n {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *server) deleteMedia(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	id, kind := params["id"], mediaKinds[params["kind"]]
+	if s.media == nil {
+		writeError(w, http.StatusServiceUnavailable, "media uploads are not configured")
+		return
+	}
+
+	err := s.media.Delete(id, kind.name)
+	switch {
+	case err == ErrNoMedia:
+		writeError(w, http.StatusNotFound, "movie "+id+" has no "+kind.name)
+		return
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if kind == posterKind {
+		for _, width := range posterWidths {
+			if err := s.media.Delete(id, thumbnailName(width)); err != nil && err != ErrNoMedia {
+				log.Printf("deleting a thumbnail of movie %s: %v", id, err)
+			}
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func testPoster(t *testing.T, width, height int) []byte {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.NRGBA{uint8(x), uint8(y), 128, 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestMedia(t *testing.T) {
+	media, err := NewDiskMediaStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &server{store: NewMemoryStore(), ids: &sequence{}, media: media}
+	h := s.routes()
+	do := func(method, target string, header http.Header, body io.Reader) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, body)
+		for k, v := range header {
+			r.Header[k] = v
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+	if w := do("POST", "/movies", nil, strings.NewReader(`{"title": "The Third Man", "directors": [{"lastname": "Reed"}]}`)); w.Code != http.StatusCreated {
+		t.Fatalf("creating a movie: got %d %s", w.Code, w.Body)
+	}
+
+	poster := testPoster(t, 400, 600)
+	w := do("PUT", "/movies/1/poster", nil, bytes.NewReader(poster))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("uploading a poster: got %d %s", w.Code, w.Body)
+	}
+	var view mediaView
+	json.Unmarshal(w.Body.Bytes(), &view)
+	if view.ContentType != "image/png" || view.Filename != "the-third-man-poster.png" || view.Size != int64(len(poster)) {
+		t.Errorf("uploaded %+v", view)
+	}
+	if len(view.Sizes) != 3 || view.Sizes["w500"] != "" {
+		t.Errorf("thumbnails %v, want all but w500 for a 400 pixel wide poster", view.Sizes)
+	}
+
+	w = do("GET", "/movies/1/poster?size=w185", nil, nil)
+	config, format, err := image.DecodeConfig(w.Body)
+	if err != nil || format != "jpeg" || config.Width != 185 || config.Height != 277 {
+		t.Errorf("w185 thumbnail: %s %dx%d %v", format, config.Width, config.Height, err)
+	}
+	if w = do("GET", "/movies/1/poster?size=w500", nil, nil); !bytes.Equal(w.Body.Bytes(), poster) {
+		t.Error("a size wider than the poster didn't serve the poster itself")
+	}
+
+	w = do("GET", "/movies/1/poster?download=1", http.Header{"Range": {"bytes=0-9"}}, nil)
+	if w.Code != http.StatusPartialContent || !bytes.Equal(w.Body.Bytes(), poster[:10]) {
+		t.Errorf("range request: got %d with %d bytes", w.Code, w.Body.Len())
+	}
+	if cd := w.Header().Get("Content-Disposition"); cd != `attachment; filename=the-third-man-poster.png` {
+		t.Errorf("Content-Disposition %q", cd)
+	}
+	if w = do("GET", "/movies/1/poster", http.Header{"If-None-Match": {view.ETag}}, nil); w.Code != http.StatusNotModified {
+		t.Errorf("conditional GET: got %d, want 304", w.Code)
+	}
+
+	// a smaller poster drops the thumbnails it is too small for
+	var form bytes.Buffer
+	mw := multipart.NewWriter(&form)
+	part, _ := mw.CreateFormFile("file", `C:\posters\small.png`)
+	part.Write(testPoster(t, 100, 150))
+	mw.Close()
+	w = do("PUT", "/movies/1/poster", http.Header{"Content-Type": {mw.FormDataContentType()}}, &form)
+	view = mediaView{}
+	json.Unmarshal(w.Body.Bytes(), &view)
+	if w.Code != http.StatusOK || view.Filename != "small.png" || len(view.Sizes) != 1 {
+		t.Errorf("replacing from a form: got %d %s", w.Code, w.Body)
+	}
+	if w = do("GET", "/movies/1/poster?size=w185", nil, nil); w.Header().Get("Content-Type") != "image/png" {
+		t.Errorf("the old w185 thumbnail is still served")
+	}
+
+	for _, c := range []struct {
+		name string
+		body []byte
+		code int
+	}{
+		{"text", []byte("not a poster"), http.StatusUnsupportedMediaType},
+		{"too large", append(poster[:len(poster):len(poster)], make([]byte, 10<<20)...), http.StatusRequestEntityTooLarge},
+		{"truncated", poster[:100], http.StatusUnprocessableEntity},
+	} {
+		if w := do("PUT", "/movies/1/poster", nil, bytes.NewReader(c.body)); w.Code != c.code {
+			t.Errorf("%s: got %d, want %d: %s", c.name, w.Code, c.code, w.Body)
+		}
+	}
+	if w = do("PUT", "/movies/2/poster", nil, bytes.NewReader(poster)); w.Code != http.StatusNotFound {
+		t.Errorf("poster for a missing movie: got %d", w.Code)
+	}
+
+	do("DELETE", "/movies/1", nil, nil)
+	if w = do("GET", "/movies/1/poster", nil, nil); w.Code != http.StatusNotFound {
+		t.Errorf("poster of a deleted movie: got %d", w.Code)
+	}
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memoryStore is a MovieStore kept in a map guarded by a RWMutex. order
+// keeps insertion order for List; pos maps an ID to its position in order.
+// byGenre, byDirector and byYear are secondary indexes from a genre, a
+// director's last name (both lower case) and a year to the IDs of the
+// movies that have it; years keeps the indexed years sorted for ranges.
+// byIMDb maps an IMDb ID to the one movie that has it.
+// history has the versions of each movie, oldest first.
+// The users are kept the same way as the movies, without indexes.
+type memoryStore struct {
+	mu     sync.RWMutex
+	movies map[string]Movie
+	order  []string
+	pos    map[string]int
+
+	byGenre    map[string]idSet
+	byDirector map[string]idSet
+	byYear     map[int]idSet
+	years      []int
+	byIMDb     map[string]string
+
+	history map[string][]Version
+
+	users     map[string]User
+	userOrder []string
+	userPos   map[string]int
+}
+
+type idSet map[string]struct{}
+
+func NewMemoryStore() *memoryStore {
+	return &memoryStore{
+		movies:     map[string]Movie{},
+		pos:        map[string]int{},
+		byGenre:    map[string]idSet{},
+		byDirector: map[string]idSet{},
+		byYear:     map[int]idSet{},
+		byIMDb:     map[string]string{},
+		history:    map[string][]Version{},
+		users:      map[string]User{},
+		userPos:    map[string]int{},
+	}
+}
+
+func (s *memoryStore) List() []Movie {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	list := make([]Movie, 0, len(s.order))
+	for _, id := range s.order {
+		list = append(list, s.movies[id].clone())
+	}
+	return list
+}
+
+func (s *memoryStore) Get(id string) (Movie, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	m, ok := s.movies[id]
+	if !ok {
+		return Movie{}, ErrNotFound
+	}
+	return m.clone(), nil
+}
+
+func (s *memoryStore) ByGenre(genres ...string) []Movie {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.collect(s.lookup(s.byGenre, genres))
+}
+
+func (s *memoryStore) ByDirector(lastnames ...string) []Movie {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.collect(s.lookup(s.byDirector, lastnames))
+}
+
+func (s *memoryStore) ByIMDb(imdbID string) (Movie, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	id, ok := s.byIMDb[imdbID]
+	if !ok {
+		return Movie{}, ErrNotFound
+	}
+	return s.movies[id].clone(), nil
+}
+
+// lookup returns the IDs indexed under any of keys. The caller holds s.mu.
+func (s *memoryStore) lookup(index map[string]idSet, keys []string) idSet {
+	if len(keys) == 1 {
+		return index[strings.ToLower(keys[0])]
+	}
+	ids := idSet{}
+	for _, k := range keys {
+		for id := range index[strings.ToLower(k)] {
+			ids[id] = struct{}{}
+		}
+	}
+	return ids
+}
+
+func (s *memoryStore) ByYear(from, to int) []Movie {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	i := sort.SearchInts(s.years, from)
+	j := sort.Search(len(s.years), func(k int) bool { return s.years[k] > to })
+	if i >= j {
+		return nil
+	}
+	if i == j-1 {
+		return s.collect(s.byYear[s.years[i]])
+	}
+	ids := idSet{}
+	for _, y := range s.years[i:j] {
+		for id := range s.byYear[y] {
+			ids[id] = struct{}{}
+		}
+	}
+	return s.collect(ids)
+}
+
+// collect returns the movies in ids in List order. The caller holds s.mu.
+func (s *memoryStore) collect(ids idSet) []Movie {
+	found := make([]string, 0, len(ids))
+	for id := range ids {
+		found = append(found, id)
+	}
+	sort.Slice(found, func(i, j int) bool { return s.pos[found[i]] < s.pos[found[j]] })
+
+	list := make([]Movie, 0, len(found))
+	for _, id := range found {
+		list = append(list, s.movies[id].clone())
+	}
+	return list
+}
+
+func (s *memoryStore) Create(m Movie) error {
+	return s.create(m, time.Now().UTC(), s.imdbFree)
+}
+
+// create is Create as of the given time, for replaying a file store's log.
+// check, if any, runs under the lock before anything changes; replay has
+// none, since what is in the log was checked before it was written.
+func (s *memoryStore) create(m Movie, at time.Time, check func(Movie) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.movies[m.ID]; ok {
+		return ErrExists
+	}
+	if check != nil {
+		if err := check(m); err != nil {
+			return err
+		}
+	}
+	s.put(m)
+	s.pos[m.ID] = len(s.order)
+	s.order = append(s.order, m.ID)
+	s.record(m, "create", 0, at)
+	return nil
+}
+
+func (s *memoryStore) Update(m Movie) error {
+	return s.update(m, time.Now().UTC(), s.imdbFree)
+}
+
+// update is Update as of the given time, for replaying a file store's log,
+// with check as for create.
+func (s *memoryStore) update(m Movie, at time.Time, check func(Movie) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.movies[m.ID]; !ok {
+		return ErrNotFound
+	}
+	if check != nil {
+		if err := check(m); err != nil {
+			return err
+		}
+	}
+	s.put(m)
+	s.record(m, "update", 0, at)
+	return nil
+}
+
+func (s *memoryStore) Modify(id string, fn func(Movie) (Movie, error)) (Movie, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m, ok := s.movies[id]
+	if !ok {
+		return Movie{}, ErrNotFound
+	}
+	m, err := fn(m.clone())
+	if err != nil {
+		return Movie{}, err
+	}
+	m.ID = id
+	if err := s.imdbFree(m); err != nil {
+		return Movie{}, err
+	}
+	s.put(m)
+	s.record(m, "update", 0, time.Now().UTC())
+	return m, nil
+}
+
+func (s *memoryStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i, ok := s.pos[id]
+	if !ok {
+		return ErrNotFound
+	}
+	s.unindex(s.movies[id])
+	delete(s.movies, id)
+	delete(s.pos, id)
+	delete(s.history, id)
+	s.order = append(s.order[:i], s.order[i+1:]...)
+	for ; i < len(s.order); i++ {
+		s.pos[s.order[i]] = i
+	}
+	return nil
+}
+
+// imdbFree fails with ErrIMDbTaken if another movie has m's IMDb ID. The
+// caller holds s.mu.
+func (s *memoryStore) imdbFree(m Movie) error {
+	if id, ok := s.byIMDb[m.IMDbID]; ok && m.IMDbID != "" && id != m.ID {
+		return &ErrIMDbTaken{IMDbID: m.IMDbID, ID: id}
+	}
+	return nil
+}
+
+// checkIMDb is imdbFree for callers that don't hold s.mu.
+func (s *memoryStore) checkIMDb(m Movie) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.imdbFree(m)
+}
+
+// put stores a copy of m and brings the indexes up to date with it. The
+// caller holds s.mu.
+func (s *memoryStore) put(m Movie) {
+	if old, ok := s.movies[m.ID]; ok {
+		s.unindex(old)
+	}
+	m = m.clone()
+	s.movies[m.ID] = m
+
+	for _, g := range m.Genres {
+		add(s.byGenre, strings.ToLower(g), m.ID)
+	}
+	for _, d := range m.Directors {
+		add(s.byDirector, strings.ToLower(d.Lastname), m.ID)
+	}
+	if m.Year != 0 {
+		if _, ok := s.byYear[m.Year]; !ok {
+			i := sort.SearchInts(s.years, m.Year)
+			s.years = append(s.years[:i], append([]int{m.Year}, s.years[i:]...)...)
+		}
+		add(s.byYear, m.Year, m.ID)
+	}
+	if m.IMDbID != "" {
+		s.byIMDb[m.IMDbID] = m.ID
+	}
+}
+
+func (s *memoryStore) unindex(m Movie) {
+	for _, g := range m.Genres {
+		remove(s.byGenre, strings.ToLower(g), m.ID)
+	}
+	for _, d := range m.Directors {
+		remove(s.byDirector, strings.ToLower(d.Lastname), m.ID)
+	}
+	if m.Year != 0 {
+		remove(s.byYear, m.Year, m.ID)
+		if _, ok := s.byYear[m.Year]; !ok {
+			i := sort.SearchInts(s.years, m.Year)
+			s.years = append(s.years[:i], s.years[i+1:]...)
+		}
+	}
+	if s.byIMDb[m.IMDbID] == m.ID {
+		delete(s.byIMDb, m.IMDbID)
+	}
+}
+
+func add[K comparable](index map[K]idSet, key K, id string) {
+	ids, ok := index[key]
+	if !ok {
+		ids = idSet{}
+		index[key] = ids
+	}
+	ids[id] = struct{}{}
+}
+
+func remove[K comparable](index map[K]idSet, key K, id string) {
+	ids := index[key]
+	delete(ids, id)
+	if len(ids) == 0 {
+		delete(index, key)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+func (s *memoryStore) ListUsers() []User {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	list := make([]User, 0, len(s.userOrder))
+	for _, id := range s.userOrder {
+		list = append(list, s.users[id].clone())
+	}
+	return list
+}
+
+func (s *memoryStore) GetUser(id string) (User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	u, ok := s.users[id]
+	if !ok {
+		return User{}, ErrUserNotFound
+	}
+	return u.clone(), nil
+}
+
+func (s *memoryStore) CreateUser(u User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[u.ID]; ok {
+		return ErrUserExists
+	}
+	s.users[u.ID] = u.clone()
+	s.userPos[u.ID] = len(s.userOrder)
+	s.userOrder = append(s.userOrder, u.ID)
+	return nil
+}
+
+// updateUser replaces a user, for replaying a file store's log.
+func (s *memoryStore) updateUser(u User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[u.ID]; !ok {
+		return ErrUserNotFound
+	}
+	s.users[u.ID] = u.clone()
+	return nil
+}
+
+func (s *memoryStore) ModifyUser(id string, fn func(User) (User, error)) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[id]
+	if !ok {
+		return User{}, ErrUserNotFound
+	}
+	u, err := fn(u.clone())
+	if err != nil {
+		return User{}, err
+	}
+	u.ID = id
+	s.users[id] = u.clone()
+	return u, nil
+}
+
+func (s *memoryStore) DeleteUser(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i, ok := s.userPos[id]
+	if !ok {
+		return ErrUserNotFound
+	}
+	delete(s.users, id)
+	delete(s.userPos, id)
+	s.userOrder = append(s.userOrder[:i], s.userOrder[i+1:]...)
+	for ; i < len(s.userOrder); i++ {
+		s.userPos[s.userOrder[i]] = i
+	}
+	return nil
+}
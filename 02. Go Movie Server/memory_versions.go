@@ -0,0 +1,97 @@
+package main
+
+import (
+	"reflect"
+	"time"
+)
+
+func (s *memoryStore) Versions(id string) ([]Version, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, ok := s.movies[id]; !ok {
+		return nil, ErrNotFound
+	}
+	return cloneVersions(s.history[id]), nil
+}
+
+func (s *memoryStore) Revert(id string, n int) (Movie, error) {
+	return s.revert(id, n, time.Now().UTC(), s.imdbFree)
+}
+
+// revert is Revert as of the given time, for replaying a file store's log,
+// with check as for create.
+func (s *memoryStore) revert(id string, n int, at time.Time, check func(Movie) error) (Movie, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.movies[id]; !ok {
+		return Movie{}, ErrNotFound
+	}
+	v, ok := find(s.history[id], n)
+	if !ok {
+		return Movie{}, ErrNoVersion
+	}
+	if check != nil {
+		if err := check(v.Movie); err != nil {
+			return Movie{}, err
+		}
+	}
+	s.put(v.Movie)
+	s.record(v.Movie, "revert", n, at)
+	return v.Movie.clone(), nil
+}
+
+// record adds m as the latest version of its movie, unless nothing changed
+// since the one before. The caller holds s.mu.
+func (s *memoryStore) record(m Movie, op string, revertedTo int, at time.Time) {
+	versions := s.history[m.ID]
+	n := 1
+	if len(versions) > 0 {
+		last := versions[len(versions)-1]
+		if reflect.DeepEqual(last.Movie, m) {
+			return
+		}
+		n = last.N + 1
+	}
+	versions = append(versions, Version{N: n, Time: at, Op: op, RevertedTo: revertedTo, Movie: m.clone()})
+	if len(versions) > maxVersions {
+		versions = versions[len(versions)-maxVersions:]
+	}
+	s.history[m.ID] = versions
+}
+
+// allVersions returns the versions of every movie, for a file store's
+// snapshot.
+func (s *memoryStore) allVersions() map[string][]Version {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	all := make(map[string][]Version, len(s.history))
+	for id, versions := range s.history {
+		all[id] = cloneVersions(versions)
+	}
+	return all
+}
+
+// restoreVersions replaces the versions of the movies in history, for
+// loading a file store's snapshot.
+func (s *memoryStore) restoreVersions(history map[string][]Version) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, versions := range history {
+		if _, ok := s.movies[id]; ok && len(versions) > 0 {
+			s.history[id] = cloneVersions(versions)
+		}
+	}
+}
+
+func cloneVersions(versions []Version) []Version {
+	list := make([]Version, len(versions))
+	for i, v := range versions {
+		v.Movie = v.Movie.clone()
+		list[i] = v
+	}
+	return list
+}
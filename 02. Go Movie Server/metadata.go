@@ -0,0 +1,312 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNoMetadata means the provider has no movie for the ID asked about.
+var ErrNoMetadata = errors.New("no metadata for that movie")
+
+// ErrRateLimited means the provider kept refusing requests for coming too
+// fast; RetryAfter says when to try again.
+type ErrRateLimited struct {
+	RetryAfter time.Duration
+}
+
+func (e *ErrRateLimited) Error() string {
+	return fmt.Sprintf("metadata provider is rate limiting, retry after %s", e.RetryAfter)
+}
+
+// MetadataProvider looks movies up in an external database. The Movie it
+// returns has no ID; the caller stores it under one of its own.
+type MetadataProvider interface {
+	LookupIMDb(ctx context.Context, imdbID string) (Movie, error)
+}
+
+// TMDBOptions configure a TMDB client. Token is a TMDB API read access
+// token; BaseURL defaults to TMDB's own API and is changed for tests.
+type TMDBOptions struct {
+	BaseURL string
+	Token   string
+	Client  *http.Client
+	// Retries is how often a request refused with 429 Too Many Requests
+	// is tried again, after waiting as long as the provider asks to, up to
+	// MaxWait. Beyond that an ErrRateLimited is returned.
+	Retries int
+	MaxWait time.Duration
+}
+
+const tmdbBaseURL = "https://api.themoviedb.org/3"
+
+// tmdb is a MetadataProvider for the TMDB API, or anything that speaks it.
+type tmdb struct {
+	opts TMDBOptions
+}
+
+func NewTMDB(opts TMDBOptions) MetadataProvider {
+	if opts.BaseURL == "" {
+		opts.BaseURL = tmdbBaseURL
+	}
+	opts.BaseURL = strings.TrimSuffix(opts.BaseURL, "/")
+	if opts.Client == nil {
+		opts.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	if opts.MaxWait == 0 {
+		opts.MaxWait = 10 * time.Second
+	}
+	return &tmdb{opts: opts}
+}
+
+// tmdbMovie is the part of TMDB's /movie/{id} response, with credits and
+// release dates appended, that maps onto a Movie.
+type tmdbMovie struct {
+	ID          int    `json:"id"`
+	IMDbID      string `json:"imdb_id"`
+	Title       string `json:"title"`
+	Overview    string `json:"overview"`
+	ReleaseDate string `json:"release_date"`
+	Runtime     int    `json:"runtime"`
+	Genres      []struct {
+		Name string `json:"name"`
+	} `json:"genres"`
+	Credits struct {
+		Cast []struct {
+			Name      string `json:"name"`
+			Character string `json:"character"`
+			Order     int    `json:"order"`
+		} `json:"cast"`
+		Crew []struct {
+			Name string `json:"name"`
+			Job  string `json:"job"`
+		} `json:"crew"`
+	} `json:"credits"`
+	ReleaseDates struct {
+		Results []struct {
+			Country string `json:"iso_3166_1"`
+			Dates   []struct {
+				Certification string `json:"certification"`
+			} `json:"release_dates"`
+		} `json:"results"`
+	} `json:"release_dates"`
+}
+
+// maxCast is how many of the top billed actors an import keeps.
+const maxCast = 10
+
+func (t *tmdb) LookupIMDb(ctx context.Context, imdbID string) (Movie, error) {
+	var found struct {
+		MovieResults []struct {
+			ID int `json:"id"`
+		} `json:"movie_results"`
+	}
+	err := t.get(ctx, "/find/"+url.PathEscape(imdbID), url.Values{"external_source": {"imdb_id"}}, &found)
+	if err != nil {
+		return Movie{}, err
+	}
+	if len(found.MovieResults) == 0 {
+		return Movie{}, ErrNoMetadata
+	}
+
+	var tm tmdbMovie
+	path := "/movie/" + strconv.Itoa(found.MovieResults[0].ID)
+	if err := t.get(ctx, path, url.Values{"append_to_response": {"credits,release_dates"}}, &tm); err != nil {
+		return Movie{}, err
+	}
+	return tm.movie(), nil
+}
+
+// movie maps tm onto a Movie, leaving out what the Movie can't hold:
+// genres and ratings it doesn't know, and all but the top billed actors.
+func (tm *tmdbMovie) movie() Movie {
+	m := Movie{
+		Title:    tm.Title,
+		Runtime:  tm.Runtime,
+		Synopsis: truncate(tm.Overview, maxSynopsis),
+		IMDbID:   tm.IMDbID,
+		TMDBID:   tm.ID,
+	}
+	if len(tm.ReleaseDate) >= 4 {
+		m.Year, _ = strconv.Atoi(tm.ReleaseDate[:4])
+	}
+	for _, g := range tm.Genres {
+		if name, ok := lookup(genres, g.Name); ok {
+			m.Genres = append(m.Genres, name)
+		}
+	}
+	for _, c := range tm.Credits.Crew {
+		if c.Job == "Director" {
+			m.Directors = append(m.Directors, splitName(c.Name))
+		}
+	}
+
+	cast := tm.Credits.Cast
+	for i := 0; i < len(cast) && len(m.Cast) < maxCast; i++ {
+		m.Cast = append(m.Cast, CastMember{
+			Name: truncate(cast[i].Name, maxName),
+			Role: truncate(cast[i].Character, maxName),
+		})
+	}
+
+	// MPAA ratings are the US certifications.
+	for _, r := range tm.ReleaseDates.Results {
+		if r.Country != "US" {
+			continue
+		}
+		for _, d := range r.Dates {
+			if rating, ok := lookup(ratings, d.Certification); ok {
+				m.Rating = rating
+				break
+			}
+		}
+	}
+	return m
+}
+
+// splitName takes the last word of a name for the last name, which is all
+// there is for directors known by one name.
+func splitName(name string) Director {
+	name = strings.TrimSpace(name)
+	i := strings.LastIndex(name, " ")
+	if i < 0 {
+		return Director{Lastname: name}
+	}
+	return Director{Firstname: name[:i], Lastname: name[i+1:]}
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	// Cut at a rune boundary.
+	for n > 0 && s[n]&0xc0 == 0x80 {
+		n--
+	}
+	return s[:n]
+}
+
+// get fetches path from the API into v, retrying when rate limited.
+func (t *tmdb) get(ctx context.Context, path string, query url.Values, v interface{}) error {
+	u := t.opts.BaseURL + path + "?" + query.Encode()
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Accept", "application/json")
+		if t.opts.Token != "" {
+			req.Header.Set("Authorization", "Bearer "+t.opts.Token)
+		}
+
+		resp, err := t.opts.Client.Do(req)
+		if err != nil {
+			return err
+		}
+		body, err := io.ReadAll(io.LimitReader(resp.Body, 4<<20))
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case resp.StatusCode == http.StatusOK:
+			return json.Unmarshal(body, v)
+		case resp.StatusCode == http.StatusNotFound:
+			return ErrNoMetadata
+		case resp.StatusCode == http.StatusTooManyRequests:
+			wait := retryAfter(resp.Header.Get("Retry-After"), attempt)
+			if attempt >= t.opts.Retries || wait > t.opts.MaxWait {
+				return &ErrRateLimited{RetryAfter: wait}
+			}
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		default:
+			return fmt.Errorf("metadata provider: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+		}
+	}
+}
+
+// retryAfter reads a Retry-After header, in seconds or as a date, and
+// backs off exponentially from a second when there is none.
+func retryAfter(header string, attempt int) time.Duration {
+	if s, err := strconv.Atoi(header); err == nil && s >= 0 {
+		return time.Duration(s) * time.Second
+	}
+	if t, err := http.ParseTime(header); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+		return 0
+	}
+	return time.Second << attempt
+}
+
+// cachedProvider remembers what another provider answered, for ttl, and
+// that it had nothing, for missTTL, so repeated imports don't spend the
+// provider's rate limit. Other errors aren't cached.
+type cachedProvider struct {
+	next    MetadataProvider
+	ttl     time.Duration
+	missTTL time.Duration
+	now     func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+}
+
+type cacheEntry struct {
+	movie   Movie
+	err     error
+	expires time.Time
+}
+
+func NewCachedProvider(next MetadataProvider, ttl, missTTL time.Duration) MetadataProvider {
+	return &cachedProvider{
+		next:    next,
+		ttl:     ttl,
+		missTTL: missTTL,
+		now:     time.Now,
+		entries: map[string]cacheEntry{},
+	}
+}
+
+func (c *cachedProvider) LookupIMDb(ctx context.Context, imdbID string) (Movie, error) {
+	c.mu.Lock()
+	e, ok := c.entries[imdbID]
+	c.mu.Unlock()
+	if ok && c.now().Before(e.expires) {
+		return e.movie.clone(), e.err
+	}
+
+	m, err := c.next.LookupIMDb(ctx, imdbID)
+	switch err {
+	case nil:
+		e = cacheEntry{movie: m.clone(), expires: c.now().Add(c.ttl)}
+	case ErrNoMetadata:
+		e = cacheEntry{err: err, expires: c.now().Add(c.missTTL)}
+	default:
+		return m, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for id, old := range c.entries {
+		if !c.now().Before(old.expires) {
+			delete(c.entries, id)
+		}
+	}
+	c.entries[imdbID] = e
+	return m, err
+}
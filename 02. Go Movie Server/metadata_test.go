@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeTMDB is a local stand-in for the TMDB API. It knows the movies in
+// movies, keyed by IMDb ID, answers the first limited requests with 429,
+// and counts the requests it gets.
+type fakeTMDB struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	movies   map[string]tmdbFixture
+	limited  int
+	requests int
+}
+
+type tmdbFixture struct {
+	tmdbID int
+	body   string // the /movie/{id} response
+}
+
+const fakeToken = "test-token"
+
+func newFakeTMDB(t *testing.T) *fakeTMDB {
+	f := &fakeTMDB{movies: map[string]tmdbFixture{
+		"tt0111161": {278, `{
+			"id": 278, "imdb_id": "tt0111161", "title": "The Shawshank Redemption",
+			"overview": "Two imprisoned men bond over a number of years.",
+			"release_date": "1994-09-23", "runtime": 142,
+			"genres": [{"id": 18, "name": "Drama"}, {"id": 80, "name": "Crime"}, {"id": 1, "name": "Prison Film"}],
+			"credits": {
+				"cast": [
+					{"name": "Tim Robbins", "character": "Andy Dufresne", "order": 0},
+					{"name": "Morgan Freeman", "character": "Ellis Boyd 'Red' Redding", "order": 1}
+				],
+				"crew": [
+					{"name": "Frank Darabont", "job": "Director"},
+					{"name": "Roger Deakins", "job": "Director of Photography"}
+				]
+			},
+			"release_dates": {"results": [
+				{"iso_3166_1": "DE", "release_dates": [{"certification": "12"}]},
+				{"iso_3166_1": "US", "release_dates": [{"certification": ""}, {"certification": "R"}]}
+			]}
+		}`},
+		"tt0000002": {2, `{"id": 2, "imdb_id": "tt0000002", "title": "No Director", "credits": {}}`},
+	}}
+
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.requests++
+
+		if r.Header.Get("Authorization") != "Bearer "+fakeToken {
+			http.Error(w, `{"status_message": "Invalid API key"}`, http.StatusUnauthorized)
+			return
+		}
+		if f.limited > 0 {
+			f.limited--
+			w.Header().Set("Retry-After", "0")
+			http.Error(w, `{"status_message": "Too many requests"}`, http.StatusTooManyRequests)
+			return
+		}
+
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/find/"):
+			results := []map[string]int{}
+			if m, ok := f.movies[strings.TrimPrefix(r.URL.Path, "/find/")]; ok {
+				results = append(results, map[string]int{"id": m.tmdbID})
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"movie_results": results})
+		case strings.HasPrefix(r.URL.Path, "/movie/"):
+			for _, m := range f.movies {
+				if r.URL.Path == "/movie/"+strconv.Itoa(m.tmdbID) {
+					w.Write([]byte(m.body))
+					return
+				}
+			}
+			http.NotFound(w, r)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeTMDB) provider(retries int) MetadataProvider {
+	return NewTMDB(TMDBOptions{BaseURL: f.URL, Token: fakeToken, Retries: retries})
+}
+
+func (f *fakeTMDB) count() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.requests
+}
+
+func TestTMDBLookup(t *testing.T) {
+	f := newFakeTMDB(t)
+
+	got, err := f.provider(0).LookupIMDb(context.Background(), "tt0111161")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Movie{
+		Title:     "The Shawshank Redemption",
+		Year:      1994,
+		Runtime:   142,
+		Genres:    []string{"Drama", "Crime"},
+		Directors: []Director{{Firstname: "Frank", Lastname: "Darabont"}},
+		Cast: []CastMember{
+			{Name: "Tim Robbins", Role: "Andy Dufresne"},
+			{Name: "Morgan Freeman", Role: "Ellis Boyd 'Red' Redding"},
+		},
+		Rating:   "R",
+		Synopsis: "Two imprisoned men bond over a number of years.",
+		IMDbID:   "tt0111161",
+		TMDBID:   278,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got  %+v\nwant %+v", got, want)
+	}
+
+	if _, err := f.provider(0).LookupIMDb(context.Background(), "tt9999999"); err != ErrNoMetadata {
+		t.Errorf("unknown movie: got %v, want ErrNoMetadata", err)
+	}
+}
+
+func TestTMDBRateLimit(t *testing.T) {
+	f := newFakeTMDB(t)
+
+	f.limited = 2
+	if _, err := f.provider(2).LookupIMDb(context.Background(), "tt0111161"); err != nil {
+		t.Fatalf("two 429s with two retries: %v", err)
+	}
+	if n := f.count(); n != 4 {
+		t.Errorf("made %d requests, want 2 refused and 2 answered", n)
+	}
+
+	f.limited = 2
+	_, err := f.provider(1).LookupIMDb(context.Background(), "tt0111161")
+	var limited *ErrRateLimited
+	if !errors.As(err, &limited) {
+		t.Errorf("two 429s with one retry: got %v, want ErrRateLimited", err)
+	}
+}
+
+func TestCachedProvider(t *testing.T) {
+	f := newFakeTMDB(t)
+	c := NewCachedProvider(f.provider(0), time.Hour, time.Minute).(*cachedProvider)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	first, err := c.LookupIMDb(ctx, "tt0111161")
+	if err != nil {
+		t.Fatal(err)
+	}
+	first.Genres[0] = "changed by the caller"
+	again, _ := c.LookupIMDb(ctx, "tt0111161")
+	if n := f.count(); n != 2 {
+		t.Errorf("made %d requests for a cached movie, want the first lookup's 2", n)
+	}
+	if again.Genres[0] != "Drama" {
+		t.Errorf("cached movie was changed through a returned copy: %v", again.Genres)
+	}
+
+	c.LookupIMDb(ctx, "tt9999999")
+	if _, err := c.LookupIMDb(ctx, "tt9999999"); err != ErrNoMetadata || f.count() != 3 {
+		t.Errorf("missing movie: got %v after %d requests, want a cached ErrNoMetadata after 3", err, f.count())
+	}
+
+	now = now.Add(2 * time.Minute)
+	c.LookupIMDb(ctx, "tt9999999")
+	c.LookupIMDb(ctx, "tt0111161")
+	if n := f.count(); n != 4 {
+		t.Errorf("made %d requests, want only the expired miss looked up again", n)
+	}
+}
+
+func TestImportMovie(t *testing.T) {
+	f := newFakeTMDB(t)
+	store := NewMemoryStore()
+	s := &server{store: store, ids: &sequence{}, metadata: f.provider(0)}
+	h := s.routes()
+
+	post := func(query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/movies/import"+query, nil))
+		return w
+	}
+
+	w := post("?imdb_id=tt0111161")
+	if w.Code != http.StatusCreated || w.Header().Get("Location") != "/movies/1" {
+		t.Fatalf("import: got %d at %q, want 201 at /movies/1: %s", w.Code, w.Header().Get("Location"), w.Body)
+	}
+	m, err := store.Get("1")
+	if err != nil || m.Title != "The Shawshank Redemption" || m.TMDBID != 278 {
+		t.Errorf("stored %+v, %v", m, err)
+	}
+
+	for _, c := range []struct {
+		query string
+		code  int
+	}{
+		{"?imdb_id=tt0111161", http.StatusConflict},
+		{"?imdb_id=tt9999999", http.StatusNotFound},
+		{"?imdb_id=tt0000002", http.StatusUnprocessableEntity},
+		{"?imdb_id=shawshank", http.StatusBadRequest},
+		{"", http.StatusBadRequest},
+	} {
+		if w := post(c.query); w.Code != c.code {
+			t.Errorf("import%s: got %d, want %d: %s", c.query, w.Code, c.code, w.Body)
+		}
+	}
+	if n := len(store.List()); n != 1 {
+		t.Errorf("store has %d movies, want only the one imported", n)
+	}
+
+	// nor can a movie get the IMDb ID some other way
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/movies",
+		strings.NewReader(`{"title": "Shawshank", "imdb_id": "tt0111161", "directors": [{"lastname": "Darabont"}]}`)))
+	if w.Code != http.StatusConflict || w.Header().Get("Location") != "/movies/1" {
+		t.Errorf("create with an imported IMDb ID: got %d at %q, want 409 at /movies/1", w.Code, w.Header().Get("Location"))
+	}
+
+	s.metadata = nil
+	if w := post("?imdb_id=tt0111161"); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("import without provider: got %d, want 503", w.Code)
+	}
+}
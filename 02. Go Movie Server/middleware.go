@@ -0,0 +1,444 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/gorilla/mux"
+)
+
+// Middleware wraps a handler in something every route shares.
+type Middleware func(http.Handler) http.Handler
+
+// Chain wraps h in mws, the first outermost, so it sees requests first and
+// responses last.
+func Chain(h http.Handler, mws ...Middleware) http.Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
+
+// CORSOptions configure which other origins may call the API from a
+// browser.
+type CORSOptions struct {
+	// AllowedOrigins are the origins allowed, such as
+	// "https://app.example.com", or "*" for any, which can't be had with
+	// AllowCredentials.
+	AllowedOrigins []string
+	// AllowedMethods limits the methods allowed; by default a route's own
+	// methods are.
+	AllowedMethods []string
+	// AllowedHeaders are the request headers a client may send, beyond
+	// the ones browsers always allow.
+	AllowedHeaders []string
+	// ExposedHeaders are the response headers scripts may read.
+	ExposedHeaders []string
+	// AllowCredentials lets requests carry cookies and authorization.
+	AllowCredentials bool
+	// MaxAge is how long browsers may cache a preflight answer.
+	MaxAge time.Duration
+}
+
+var (
+	defaultCORSHeaders = []string{"Accept", "Authorization", "Content-Type", "If-Match", "If-None-Match"}
+	defaultCORSExposed = []string{"Link", "Location", "Retry-After", "X-Total-Count"}
+	// routeMethods are the methods a preflight asks the router about.
+	routeMethods = []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+)
+
+// CORS answers preflight requests and marks responses as readable by the
+// allowed origins. It wraps router from outside rather than through
+// router.Use, since routes registered with .Methods() don't match OPTIONS
+// and mux runs its middleware on matched routes only; the methods a
+// preflight is told are the ones the router has a route for at that path.
+func CORS(router *mux.Router, opts CORSOptions) (Middleware, error) {
+	if opts.AllowedHeaders == nil {
+		opts.AllowedHeaders = defaultCORSHeaders
+	}
+	if opts.ExposedHeaders == nil {
+		opts.ExposedHeaders = defaultCORSExposed
+	}
+	anyOrigin := false
+	origins := map[string]bool{}
+	for _, o := range opts.AllowedOrigins {
+		if o == "*" {
+			anyOrigin = true
+		}
+		origins[strings.ToLower(strings.TrimSuffix(o, "/"))] = true
+	}
+	// Any page on the web could then make requests with the user's
+	// cookies and read the answers.
+	if anyOrigin && opts.AllowCredentials {
+		return nil, errors.New(`cors: origin "*" can't be allowed credentials; list the origins`)
+	}
+	allowedHeaders := map[string]bool{}
+	for _, h := range opts.AllowedHeaders {
+		allowedHeaders[http.CanonicalHeaderKey(h)] = true
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+			w.Header().Add("Vary", "Origin")
+			if origin == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if !anyOrigin && !origins[strings.ToLower(origin)] {
+				if preflight {
+					writeError(w, http.StatusForbidden, "origin "+origin+" is not allowed")
+					return
+				}
+				// the browser keeps the response from the page, as it
+				// has no CORS headers
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			h := w.Header()
+			if anyOrigin {
+				h.Set("Access-Control-Allow-Origin", "*")
+			} else {
+				h.Set("Access-Control-Allow-Origin", origin)
+			}
+			if opts.AllowCredentials {
+				h.Set("Access-Control-Allow-Credentials", "true")
+			}
+			if !preflight {
+				if len(opts.ExposedHeaders) > 0 {
+					h.Set("Access-Control-Expose-Headers", strings.Join(opts.ExposedHeaders, ", "))
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			h.Add("Vary", "Access-Control-Request-Method")
+			h.Add("Vary", "Access-Control-Request-Headers")
+			methods := allowedMethods(router, r, opts.AllowedMethods)
+			if len(methods) == 0 {
+				// no route at this path; let the router say so
+				next.ServeHTTP(w, r)
+				return
+			}
+			for _, name := range strings.Split(r.Header.Get("Access-Control-Request-Headers"), ",") {
+				name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+				if name != "" && !allowedHeaders[name] {
+					writeError(w, http.StatusForbidden, "header "+name+" is not allowed")
+					return
+				}
+			}
+
+			// a method that isn't listed fails in the browser, which is
+			// where the client learns about it
+			h.Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+			if len(opts.AllowedHeaders) > 0 {
+				h.Set("Access-Control-Allow-Headers", strings.Join(opts.AllowedHeaders, ", "))
+			}
+			if opts.MaxAge > 0 {
+				h.Set("Access-Control-Max-Age", strconv.Itoa(int(opts.MaxAge.Seconds())))
+			}
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}, nil
+}
+
+// allowedMethods returns the methods router has a route for at r's path,
+// limited to only if that isn't empty.
+func allowedMethods(router *mux.Router, r *http.Request, only []string) []string {
+	var methods []string
+	for _, m := range routeMethods {
+		if len(only) > 0 && !contains(only, m) {
+			continue
+		}
+		req := new(http.Request)
+		*req = *r
+		req.Method = m
+		var match mux.RouteMatch
+		if router.Match(req, &match) && match.MatchErr == nil {
+			methods = append(methods, m)
+		}
+	}
+	return methods
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Compress compresses responses with brotli or gzip, whichever the
+// client's Accept-Encoding prefers, br when it likes both the same.
+// Responses smaller than minSize, of types that don't compress (images,
+// video, archives) or that are a range of a file are sent as they are.
+func Compress(minSize int) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Accept-Encoding")
+			encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+			// a range is of the file as it is, not of its compressed form
+			if encoding == "" || r.Method == http.MethodHead || r.Header.Get("Range") != "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			cw := &compressWriter{ResponseWriter: w, encoding: encoding, minSize: minSize}
+			defer cw.Close()
+			next.ServeHTTP(cw, r)
+		})
+	}
+}
+
+// negotiateEncoding picks br or gzip by the q-values of an Accept-Encoding
+// header, or returns "" for neither.
+func negotiateEncoding(header string) string {
+	q := map[string]float64{}
+	for _, part := range strings.Split(header, ",") {
+		params := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(params[0]))
+		if name == "" {
+			continue
+		}
+		weight := 1.0
+		for _, p := range params[1:] {
+			if v, ok := strings.CutPrefix(strings.TrimSpace(p), "q="); ok {
+				if f, err := strconv.ParseFloat(v, 64); err == nil {
+					weight = f
+				}
+			}
+		}
+		q[name] = weight
+	}
+
+	best, bestQ := "", 0.0
+	for _, enc := range []string{"br", "gzip"} {
+		weight, ok := q[enc]
+		if !ok {
+			weight = q["*"]
+		}
+		if weight > bestQ {
+			best, bestQ = enc, weight
+		}
+	}
+	return best
+}
+
+// compressible reports whether a response of the given Content-Type is
+// worth compressing.
+func compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	switch {
+	case strings.HasPrefix(mediaType, "text/"),
+		strings.HasSuffix(mediaType, "+json"),
+		strings.HasSuffix(mediaType, "+xml"):
+		return true
+	}
+	switch mediaType {
+	case "application/json", "application/xml", "application/javascript", "application/msgpack", "image/svg+xml":
+		return true
+	}
+	return false
+}
+
+var (
+	gzipWriters   = sync.Pool{New: func() interface{} { return gzip.NewWriter(io.Discard) }}
+	brotliWriters = sync.Pool{New: func() interface{} { return brotli.NewWriterLevel(io.Discard, 5) }}
+)
+
+// compressWriter holds back the start of a response until it knows
+// whether to compress it: once minSize bytes are written, or the handler
+// is done.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	minSize  int
+
+	status  int
+	buf     []byte
+	decided bool
+	enc     io.WriteCloser // nil if the response isn't compressed
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.status != 0 || cw.decided {
+		return
+	}
+	if status < 200 {
+		cw.ResponseWriter.WriteHeader(status)
+		return
+	}
+	cw.status = status
+	// nothing to compress in these
+	if status == http.StatusNoContent || status == http.StatusNotModified {
+		cw.decide(false)
+	}
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if cw.status == 0 {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if !cw.decided {
+		cw.buf = append(cw.buf, p...)
+		if len(cw.buf) < cw.minSize {
+			return len(p), nil
+		}
+		if err := cw.decide(true); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+	if cw.enc != nil {
+		return cw.enc.Write(p)
+	}
+	return cw.ResponseWriter.Write(p)
+}
+
+// decide sends the header, compressed if big says the response is large
+// enough and its headers allow it, and then what was held back.
+func (cw *compressWriter) decide(big bool) error {
+	cw.decided = true
+	h := cw.Header()
+	if h.Get("Content-Type") == "" && len(cw.buf) > 0 {
+		h.Set("Content-Type", http.DetectContentType(cw.buf))
+	}
+	if big && cw.status != http.StatusPartialContent && h.Get("Content-Encoding") == "" &&
+		h.Get("Content-Range") == "" && compressible(h.Get("Content-Type")) {
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", cw.encoding)
+		switch cw.encoding {
+		case "br":
+			bw := brotliWriters.Get().(*brotli.Writer)
+			bw.Reset(cw.ResponseWriter)
+			cw.enc = bw
+		case "gzip":
+			gw := gzipWriters.Get().(*gzip.Writer)
+			gw.Reset(cw.ResponseWriter)
+			cw.enc = gw
+		}
+	}
+
+	cw.ResponseWriter.WriteHeader(cw.status)
+	if len(cw.buf) == 0 {
+		return nil
+	}
+	var err error
+	if cw.enc != nil {
+		_, err = cw.enc.Write(cw.buf)
+	} else {
+		_, err = cw.ResponseWriter.Write(cw.buf)
+	}
+	cw.buf = nil
+	return err
+}
+
+// Close sends a response too small to compress, or finishes a compressed
+// one.
+func (cw *compressWriter) Close() error {
+	if !cw.decided {
+		if cw.status == 0 {
+			// the handler wrote nothing; let the server answer as usual
+			return nil
+		}
+		return cw.decide(false)
+	}
+	if cw.enc == nil {
+		return nil
+	}
+	err := cw.enc.Close()
+	switch enc := cw.enc.(type) {
+	case *brotli.Writer:
+		brotliWriters.Put(enc)
+	case *gzip.Writer:
+		gzipWriters.Put(enc)
+	}
+	cw.enc = nil
+	return err
+}
+
+// Flush sends what has been written so far, compressed or not, to the
+// client.
+func (cw *compressWriter) Flush() {
+	if !cw.decided && cw.status != 0 {
+		cw.decide(len(cw.buf) >= cw.minSize)
+	}
+	if f, ok := cw.enc.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (cw *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := cw.ResponseWriter.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+	return nil, nil, fmt.Errorf("compress: %T can't be hijacked", cw.ResponseWriter)
+}
+
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// SecurityOptions configure the security headers sent with every
+// response.
+type SecurityOptions struct {
+	// HSTSMaxAge is how long browsers should only use HTTPS for this host;
+	// 0 leaves Strict-Transport-Security out. It is sent over HTTPS only,
+	// as browsers ignore it otherwise.
+	HSTSMaxAge            time.Duration
+	HSTSIncludeSubdomains bool
+	// ContentSecurityPolicy defaults to one that lets a response load
+	// nothing and be framed nowhere, which suits a JSON API.
+	ContentSecurityPolicy string
+}
+
+const defaultCSP = "default-src 'none'; frame-ancestors 'none'"
+
+func SecurityHeaders(opts SecurityOptions) Middleware {
+	if opts.ContentSecurityPolicy == "" {
+		opts.ContentSecurityPolicy = defaultCSP
+	}
+	hsts := ""
+	if opts.HSTSMaxAge > 0 {
+		hsts = "max-age=" + strconv.Itoa(int(opts.HSTSMaxAge.Seconds()))
+		if opts.HSTSIncludeSubdomains {
+			hsts += "; includeSubDomains"
+		}
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			h := w.Header()
+			h.Set("X-Content-Type-Options", "nosniff")
+			h.Set("Content-Security-Policy", opts.ContentSecurityPolicy)
+			h.Set("X-Frame-Options", "DENY")
+			h.Set("Referrer-Policy", "no-referrer")
+			// behind a TLS terminating proxy, X-Forwarded-Proto says
+			// the client used HTTPS
+			if hsts != "" && (r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https") {
+				h.Set("Strict-Transport-Security", hsts)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+)
+
+func TestCORSPreflight(t *testing.T) {
+	s := &server{store: NewMemoryStore(), ids: &sequence{}}
+	router := s.routes()
+	cors, err := CORS(router, CORSOptions{AllowedOrigins: []string{"https://app.example.com"}, AllowCredentials: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := Chain(router, cors)
+
+	preflight := func(path, origin, method string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodOptions, path, nil)
+		r.Header.Set("Origin", origin)
+		r.Header.Set("Access-Control-Request-Method", method)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	w := preflight("/movies/1", "https://app.example.com", "PATCH")
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("preflight: got %d, want 204: %s", w.Code, w.Body)
+	}
+	for header, want := range map[string]string{
+		"Access-Control-Allow-Origin":      "https://app.example.com",
+		"Access-Control-Allow-Methods":     "GET, PUT, PATCH, DELETE",
+		"Access-Control-Allow-Credentials": "true",
+	} {
+		if got := w.Header().Get(header); got != want {
+			t.Errorf("%s: got %q, want %q", header, got, want)
+		}
+	}
+
+	if w := preflight("/movies", "https://other.example.com", "POST"); w.Code != http.StatusForbidden {
+		t.Errorf("preflight from another origin: got %d, want 403", w.Code)
+	}
+	if w := preflight("/nowhere", "https://app.example.com", "GET"); w.Code != http.StatusNotFound {
+		t.Errorf("preflight for no route: got %d, want 404", w.Code)
+	}
+
+	// credentials for any origin would let any page act as the user
+	if _, err := CORS(router, CORSOptions{AllowedOrigins: []string{"https://app.example.com", "*"}, AllowCredentials: true}); err == nil {
+		t.Error(`"*" was allowed credentials`)
+	}
+	cors, err = CORS(router, CORSOptions{AllowedOrigins: []string{"*"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h = Chain(router, cors)
+	w = preflight("/movies", "https://other.example.com", "POST")
+	if got := w.Header().Get("Access-Control-Allow-Origin"); w.Code != http.StatusNoContent || got != "*" ||
+		w.Header().Get("Access-Control-Allow-Credentials") != "" {
+		t.Errorf("preflight for any origin: got %d with Access-Control-Allow-Origin %q", w.Code, got)
+	}
+}
+
+func TestCompress(t *testing.T) {
+	body := strings.Repeat(`{"title": "Movie One"}`, 100)
+	h := Compress(1024)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		size, _ := strconv.Atoi(r.URL.Query().Get("size"))
+		io.WriteString(w, body[:size])
+	}))
+
+	get := func(query, acceptEncoding string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/"+query, nil)
+		r.Header.Set("Accept-Encoding", acceptEncoding)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	for _, c := range []struct {
+		acceptEncoding, want string
+	}{
+		{"gzip, br", "br"},
+		{"gzip;q=1, br;q=0.5", "gzip"},
+		{"*", "br"},
+		{"br;q=0, gzip;q=0", ""},
+		{"", ""},
+	} {
+		w := get("?size="+strconv.Itoa(len(body)), c.acceptEncoding)
+		if got := w.Header().Get("Content-Encoding"); got != c.want {
+			t.Errorf("Accept-Encoding %q: got %q, want %q", c.acceptEncoding, got, c.want)
+			continue
+		}
+		var r io.Reader = w.Body
+		switch c.want {
+		case "gzip":
+			gr, err := gzip.NewReader(w.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r = gr
+		case "br":
+			r = brotli.NewReader(w.Body)
+		}
+		if got, _ := io.ReadAll(r); string(got) != body {
+			t.Errorf("Accept-Encoding %q: body doesn't survive the round trip", c.acceptEncoding)
+		}
+	}
+
+	if w := get("?size=1000", "gzip"); w.Header().Get("Content-Encoding") != "" {
+		t.Errorf("small response was compressed")
+	}
+}
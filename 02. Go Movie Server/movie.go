@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+type Movie struct {
+	ID        string       `json:"id"`
+	Title     string       `json:"title"`
+	Year      int          `json:"year,omitempty"`
+	Runtime   int          `json:"runtime,omitempty"` // minutes
+	Genres    []string     `json:"genres,omitempty"`
+	Directors []Director   `json:"directors"`
+	Cast      []CastMember `json:"cast,omitempty"`
+	Rating    string       `json:"rating,omitempty"` // MPAA
+	Synopsis  string       `json:"synopsis,omitempty"`
+	IMDbID    string       `json:"imdb_id,omitempty"`
+	TMDBID    int          `json:"tmdb_id,omitempty"`
+}
+
+type Director struct {
+	Firstname string `json:"firstname" xml:"firstname,omitempty"`
+	Lastname  string `json:"lastname" xml:"lastname"`
+}
+
+type CastMember struct {
+	Name string `json:"name" xml:"name"`
+	Role string `json:"role,omitempty" xml:"role,omitempty"`
+}
+
+// UnmarshalJSON also reads the single "director" that movies had before
+// they could have several, so that older clients and data files still work.
+func (m *Movie) UnmarshalJSON(data []byte) error {
+	type movie Movie
+	var v struct {
+		movie
+		Director *Director `json:"director"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*m = Movie(v.movie)
+	if len(m.Directors) == 0 && v.Director != nil {
+		m.Directors = []Director{*v.Director}
+	}
+	return nil
+}
+
+// clone returns a deep copy of m, so that callers never share a slice with
+// the store.
+func (m Movie) clone() Movie {
+	m.Genres = append([]string(nil), m.Genres...)
+	m.Directors = append([]Director(nil), m.Directors...)
+	m.Cast = append([]CastMember(nil), m.Cast...)
+	return m
+}
+
+// genres are TMDB's movie genres, so that imported movies fit.
+var genres = []string{
+	"Action", "Adventure", "Animation", "Comedy", "Crime", "Documentary",
+	"Drama", "Family", "Fantasy", "History", "Horror", "Music", "Mystery",
+	"Romance", "Science Fiction", "Thriller", "TV Movie", "War", "Western",
+}
+
+// ratings are the MPAA ratings; NR is not rated.
+var ratings = []string{"G", "PG", "PG-13", "R", "NC-17", "NR"}
+
+var imdbIDPattern = regexp.MustCompile(`^tt[0-9]{7,10}$`)
+
+const (
+	maxTitle    = 200
+	maxName     = 100
+	maxSynopsis = 5000
+	maxRuntime  = 1000
+	firstFilm   = 1888 // Roundhay Garden Scene
+)
+
+// Validate checks every field of m and normalises genres and the rating to
+// their canonical spelling. The error is a requestError naming the field.
+func (m *Movie) Validate() error {
+	m.Title = strings.TrimSpace(m.Title)
+	switch {
+	case m.Title == "":
+		return &requestError{Field: "title", Msg: "is required"}
+	case len(m.Title) > maxTitle:
+		return &requestError{Field: "title", Msg: fmt.Sprintf("is longer than %d characters", maxTitle)}
+	}
+
+	if m.Year != 0 {
+		if last := time.Now().Year() + 10; m.Year < firstFilm || m.Year > last {
+			return &requestError{Field: "year", Msg: fmt.Sprintf("must be between %d and %d", firstFilm, last)}
+		}
+	}
+	if m.Runtime < 0 || m.Runtime > maxRuntime {
+		return &requestError{Field: "runtime", Msg: fmt.Sprintf("must be at most %d minutes", maxRuntime)}
+	}
+
+	seen := map[string]bool{}
+	for i, g := range m.Genres {
+		field := fmt.Sprintf("genres[%d]", i)
+		canonical, ok := lookup(genres, g)
+		if !ok {
+			return &requestError{Field: field, Msg: fmt.Sprintf("unknown genre %q; one of %s", g, strings.Join(genres, ", "))}
+		}
+		if seen[canonical] {
+			return &requestError{Field: field, Msg: fmt.Sprintf("%s is listed twice", canonical)}
+		}
+		seen[canonical] = true
+		m.Genres[i] = canonical
+	}
+
+	if len(m.Directors) == 0 {
+		return &requestError{Field: "directors", Msg: "at least one is required"}
+	}
+	for i, d := range m.Directors {
+		field := fmt.Sprintf("directors[%d]", i)
+		if strings.TrimSpace(d.Lastname) == "" {
+			return &requestError{Field: field + ".lastname", Msg: "is required"}
+		}
+		if len(d.Firstname) > maxName || len(d.Lastname) > maxName {
+			return &requestError{Field: field, Msg: fmt.Sprintf("names are limited to %d characters", maxName)}
+		}
+	}
+
+	for i, c := range m.Cast {
+		field := fmt.Sprintf("cast[%d]", i)
+		if strings.TrimSpace(c.Name) == "" {
+			return &requestError{Field: field + ".name", Msg: "is required"}
+		}
+		if len(c.Name) > maxName || len(c.Role) > maxName {
+			return &requestError{Field: field, Msg: fmt.Sprintf("name and role are limited to %d characters", maxName)}
+		}
+	}
+
+	if m.Rating != "" {
+		canonical, ok := lookup(ratings, m.Rating)
+		if !ok {
+			return &requestError{Field: "rating", Msg: fmt.Sprintf("must be one of %s", strings.Join(ratings, ", "))}
+		}
+		m.Rating = canonical
+	}
+	if len(m.Synopsis) > maxSynopsis {
+		return &requestError{Field: "synopsis", Msg: fmt.Sprintf("is longer than %d characters", maxSynopsis)}
+	}
+	if m.IMDbID != "" && !imdbIDPattern.MatchString(m.IMDbID) {
+		return &requestError{Field: "imdb_id", Msg: `must look like "tt0111161"`}
+	}
+	if m.TMDBID < 0 {
+		return &requestError{Field: "tmdb_id", Msg: "must be positive"}
+	}
+	return nil
+}
+
+// lookup finds s in list, ignoring case.
+func lookup(list []string, s string) (string, bool) {
+	for _, v := range list {
+		if strings.EqualFold(v, strings.TrimSpace(s)) {
+			return v, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	for _, c := range []struct {
+		movie string
+		field string // the field the error names, "" if the movie is valid
+	}{
+		{`{"title": "Heat", "directors": [{"lastname": "Mann"}]}`, ""},
+		{`{"title": " Heat ", "year": 1995, "runtime": 170, "genres": ["crime", "Drama"], "rating": "r",
+			"directors": [{"firstname": "Michael", "lastname": "Mann"}], "cast": [{"name": "Al Pacino", "role": "Hanna"}],
+			"imdb_id": "tt0113277", "tmdb_id": 949}`, ""},
+		{`{"title": " ", "directors": [{"lastname": "Mann"}]}`, "title"},
+		{`{"title": "` + strings.Repeat("x", maxTitle+1) + `", "directors": [{"lastname": "Mann"}]}`, "title"},
+		{`{"title": "Heat", "year": 1800, "directors": [{"lastname": "Mann"}]}`, "year"},
+		{`{"title": "Heat", "runtime": -1, "directors": [{"lastname": "Mann"}]}`, "runtime"},
+		{`{"title": "Heat", "genres": ["Heist"], "directors": [{"lastname": "Mann"}]}`, "genres[0]"},
+		{`{"title": "Heat", "genres": ["Crime", "crime"], "directors": [{"lastname": "Mann"}]}`, "genres[1]"},
+		{`{"title": "Heat"}`, "directors"},
+		{`{"title": "Heat", "directors": [{"lastname": "Mann"}, {"firstname": "Michael"}]}`, "directors[1].lastname"},
+		{`{"title": "Heat", "directors": [{"lastname": "Mann"}], "cast": [{"role": "Hanna"}]}`, "cast[0].name"},
+		{`{"title": "Heat", "directors": [{"lastname": "Mann"}], "rating": "X"}`, "rating"},
+		{`{"title": "Heat", "directors": [{"lastname": "Mann"}], "imdb_id": "0113277"}`, "imdb_id"},
+		{`{"title": "Heat", "directors": [{"lastname": "Mann"}], "tmdb_id": -949}`, "tmdb_id"},
+	} {
+		var m Movie
+		if err := json.Unmarshal([]byte(c.movie), &m); err != nil {
+			t.Fatal(err)
+		}
+		err := m.Validate()
+		var field string
+		if re, ok := err.(*requestError); ok {
+			field = re.Field
+		} else if err != nil {
+			t.Errorf("%s: %v is not a requestError", c.movie, err)
+		}
+		if field != c.field || (err != nil) != (c.field != "") {
+			t.Errorf("%s: got error %v, want one for field %q", c.movie, err, c.field)
+		}
+	}
+
+	// what is valid is put in canonical form
+	m := Movie{Title: " Heat ", Genres: []string{"crime"}, Rating: "r", Directors: []Director{{Lastname: "Mann"}}}
+	if err := m.Validate(); err != nil || m.Title != "Heat" || m.Genres[0] != "Crime" || m.Rating != "R" {
+		t.Errorf("validated as %+v, %v", m, err)
+	}
+}
+
+func TestIndexes(t *testing.T) {
+	for _, c := range testStores(t) {
+		t.Run(c.name, func(t *testing.T) {
+			store := c.store
+			for _, m := range []Movie{
+				{ID: "1", Title: "Heat", Year: 1995, Genres: []string{"Crime", "Drama"}, Directors: []Director{{Lastname: "Mann"}}},
+				{ID: "2", Title: "Alien", Year: 1979, Genres: []string{"Horror"}, Directors: []Director{{Lastname: "Scott"}}},
+				{ID: "3", Title: "Thief", Year: 1981, Genres: []string{"Crime"}, Directors: []Director{{Lastname: "Mann"}}},
+				{ID: "4", Title: "Untitled", Directors: []Director{{Lastname: "Doe"}}},
+			} {
+				if err := store.Create(m); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// the changes move a movie from one key to another
+			store.Update(Movie{ID: "3", Title: "Thief", Year: 1981, Genres: []string{"Drama"},
+				Directors: []Director{{Lastname: "Mann"}, {Lastname: "Scott"}}})
+			store.Delete("1")
+
+			for _, q := range []struct {
+				name string
+				got  []Movie
+				want []string
+			}{
+				{"genre crime", store.ByGenre("crime"), nil},
+				{"genre drama", store.ByGenre("DRAMA"), []string{"3"}},
+				{"genres horror or drama", store.ByGenre("Horror", "Drama"), []string{"2", "3"}},
+				{"director scott", store.ByDirector("scott"), []string{"2", "3"}},
+				{"director mann", store.ByDirector("Mann"), []string{"3"}},
+				{"years 1979-1981", store.ByYear(1979, 1981), []string{"2", "3"}},
+				{"year 1981", store.ByYear(1981, 1981), []string{"3"}},
+				{"years 1995-2000", store.ByYear(1995, 2000), nil},
+				{"years 0-0", store.ByYear(0, 0), nil},
+			} {
+				if got := ids(q.got); !reflect.DeepEqual(got, q.want) {
+					t.Errorf("%s: got %v, want %v", q.name, got, q.want)
+				}
+			}
+		})
+	}
+}
+
+// Clients from before movies had several directors send one "director".
+func TestLegacyDirector(t *testing.T) {
+	var m Movie
+	json.Unmarshal([]byte(`{"title": "Heat", "director": {"firstname": "Michael", "lastname": "Mann"}}`), &m)
+	if want := []Director{{Firstname: "Michael", Lastname: "Mann"}}; !reflect.DeepEqual(m.Directors, want) {
+		t.Errorf("read directors %+v, want %+v", m.Directors, want)
+	}
+
+	s := &server{store: NewMemoryStore(), ids: &sequence{}}
+	s.store.Create(Movie{ID: "1", Title: "Heat", Directors: []Director{{Lastname: "Man"}, {Lastname: "Scott"}}})
+	s.store.Create(Movie{ID: "2", Title: "Thief"})
+	h := s.routes()
+
+	for _, c := range []struct {
+		id, patch string
+		code      int
+		want      []Director
+	}{
+		// a patch of the first director, keeping the fields it doesn't send
+		{"1", `{"director": {"lastname": "Mann"}}`, http.StatusOK,
+			[]Director{{Lastname: "Mann"}, {Lastname: "Scott"}}},
+		{"1", `{"director": {"firstname": "Michael"}}`, http.StatusOK,
+			[]Director{{Firstname: "Michael", Lastname: "Mann"}, {Lastname: "Scott"}}},
+		{"1", `{"director": null}`, http.StatusBadRequest,
+			[]Director{{Firstname: "Michael", Lastname: "Mann"}, {Lastname: "Scott"}}},
+		{"1", `{"director": "Mann"}`, http.StatusBadRequest,
+			[]Director{{Firstname: "Michael", Lastname: "Mann"}, {Lastname: "Scott"}}},
+		{"1", `{"director": {"lastname": "Mann"}, "directors": []}`, http.StatusBadRequest,
+			[]Director{{Firstname: "Michael", Lastname: "Mann"}, {Lastname: "Scott"}}},
+		// a movie without directors gets one
+		{"2", `{"director": {"lastname": "Mann"}}`, http.StatusOK, []Director{{Lastname: "Mann"}}},
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("PATCH", "/movies/"+c.id, strings.NewReader(c.patch)))
+		m, _ := s.store.Get(c.id)
+		if w.Code != c.code || !reflect.DeepEqual(m.Directors, c.want) {
+			t.Errorf("PATCH %s: got %d with directors %+v, want %d with %+v", c.patch, w.Code, m.Directors, c.code, c.want)
+		}
+	}
+}
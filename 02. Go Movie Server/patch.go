@@ -0,0 +1,86 @@
+package main
+
+import "encoding/json"
+
+// mergePatch applies a JSON merge patch (RFC 7396) to target: objects merge
+// key by key, recursively, a null removes the key, and anything else
+// replaces the old value outright.
+func mergePatch(target, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = map[string]interface{}{}
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(t, k)
+		} else {
+			t[k] = mergePatch(t[k], v)
+		}
+	}
+	return t
+}
+
+// patchMovie returns m with patch merged into it. A patch that doesn't fit
+// the Movie type gives a requestError naming the field.
+func patchMovie(m Movie, patch map[string]interface{}) (Movie, error) {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return Movie{}, err
+	}
+	var doc interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return Movie{}, err
+	}
+
+	patch, err = legacyDirector(doc, patch)
+	if err != nil {
+		return Movie{}, err
+	}
+	data, err = json.Marshal(mergePatch(doc, patch))
+	if err != nil {
+		return Movie{}, err
+	}
+	var patched Movie
+	if err := json.Unmarshal(data, &patched); err != nil {
+		return Movie{}, jsonError(err)
+	}
+	return patched, nil
+}
+
+// legacyDirector turns the single "director" of older clients into a patch
+// of the first of the movie's directors, as doc has them, so that it isn't
+// dropped on the way through Movie. It can't be sent with "directors", nor
+// be null: which director it would remove isn't clear.
+func legacyDirector(doc interface{}, patch map[string]interface{}) (map[string]interface{}, error) {
+	director, ok := patch["director"]
+	if !ok {
+		return patch, nil
+	}
+	if _, ok := patch["directors"]; ok {
+		return nil, &requestError{Field: "director", Msg: `can't be sent with "directors"`}
+	}
+	if _, ok := director.(map[string]interface{}); !ok {
+		return nil, &requestError{Field: "director", Msg: `must be an object; use "directors" to remove directors`}
+	}
+
+	var directors []interface{}
+	if m, ok := doc.(map[string]interface{}); ok {
+		directors, _ = m["directors"].([]interface{})
+	}
+	if len(directors) == 0 {
+		directors = []interface{}{nil}
+	}
+	directors = append([]interface{}{mergePatch(directors[0], director)}, directors[1:]...)
+
+	p := make(map[string]interface{}, len(patch))
+	for k, v := range patch {
+		p[k] = v
+	}
+	delete(p, "director")
+	p["directors"] = directors
+	return p, nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// The examples from RFC 7396, appendix A.
+func TestMergePatch(t *testing.T) {
+	for _, c := range []struct{ target, patch, want string }{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`{"a":"foo"}`, `null`, `null`},
+		{`{"a":"foo"}`, `"bar"`, `"bar"`},
+		{`{"e":null}`, `{"a":1}`, `{"a":1,"e":null}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	} {
+		var target, patch, want interface{}
+		json.Unmarshal([]byte(c.target), &target)
+		json.Unmarshal([]byte(c.patch), &patch)
+		json.Unmarshal([]byte(c.want), &want)
+		if got := mergePatch(target, patch); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s patched with %s: got %v, want %s", c.target, c.patch, got, c.want)
+		}
+	}
+}
+
+func TestPutPatch(t *testing.T) {
+	s := &server{store: NewMemoryStore(), ids: &sequence{}}
+	for _, id := range []string{"1", "2", "3"} {
+		s.store.Create(Movie{ID: id, Title: "Movie " + id, Year: 1995, Rating: "R",
+			Directors: []Director{{Firstname: "Michael", Lastname: "Mann"}}})
+	}
+	h := s.routes()
+
+	for _, c := range []struct {
+		method, body string
+		code         int
+		want         Movie // the whole movie 2 after the request
+	}{
+		// PUT replaces the whole movie, and what it leaves out is gone
+		{"PUT", `{"title": "Heat", "directors": [{"lastname": "Mann"}]}`, http.StatusOK,
+			Movie{ID: "2", Title: "Heat", Directors: []Director{{Lastname: "Mann"}}}},
+		{"PUT", `{"title": "Heat"}`, http.StatusBadRequest,
+			Movie{ID: "2", Title: "Heat", Directors: []Director{{Lastname: "Mann"}}}},
+		// PATCH changes only what it sends
+		{"PATCH", `{"year": 1995, "rating": "R"}`, http.StatusOK,
+			Movie{ID: "2", Title: "Heat", Year: 1995, Rating: "R", Directors: []Director{{Lastname: "Mann"}}}},
+		{"PATCH", `{"rating": null}`, http.StatusOK,
+			Movie{ID: "2", Title: "Heat", Year: 1995, Directors: []Director{{Lastname: "Mann"}}}},
+		// arrays are replaced, not merged
+		{"PATCH", `{"directors": [{"firstname": "Michael", "lastname": "Mann"}]}`, http.StatusOK,
+			Movie{ID: "2", Title: "Heat", Year: 1995, Directors: []Director{{Firstname: "Michael", Lastname: "Mann"}}}},
+		// a patch that fails validation or doesn't fit changes nothing
+		{"PATCH", `{"title": null}`, http.StatusBadRequest,
+			Movie{ID: "2", Title: "Heat", Year: 1995, Directors: []Director{{Firstname: "Michael", Lastname: "Mann"}}}},
+		{"PATCH", `{"year": "1995"}`, http.StatusBadRequest,
+			Movie{ID: "2", Title: "Heat", Year: 1995, Directors: []Director{{Firstname: "Michael", Lastname: "Mann"}}}},
+		{"PATCH", `{"id": "3"}`, http.StatusBadRequest,
+			Movie{ID: "2", Title: "Heat", Year: 1995, Directors: []Director{{Firstname: "Michael", Lastname: "Mann"}}}},
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(c.method, "/movies/2", strings.NewReader(c.body)))
+		if w.Code != c.code {
+			t.Errorf("%s %s: got %d with %s, want %d", c.method, c.body, w.Code, w.Body, c.code)
+		}
+		if m, _ := s.store.Get("2"); !reflect.DeepEqual(m, c.want) {
+			t.Errorf("%s %s: movie is %+v, want %+v", c.method, c.body, m, c.want)
+		}
+	}
+
+	// neither moves the movie in the list
+	var got []string
+	for _, m := range s.store.List() {
+		got = append(got, m.ID)
+	}
+	if want := []string{"1", "2", "3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("movies in the order %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,510 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// movieQuery is a parsed GET /movies query string, such as
+//
+//	genre=Drama&year>=2000&director.lastname=Doe&title~=night
+//	&sort=-year,title&limit=10&fields=title,year
+//
+// Filters are ANDed; a comma in the value of an = filter ORs the values.
+type movieQuery struct {
+	filters []filter
+	sort    []sortKey
+	limit   int // 0 is no limit
+	offset  int
+	cursor  *cursor
+	fields  []string
+}
+
+type filter struct {
+	field string
+	op    string // =, !=, ~=, <, <=, >, >=
+	text  []string
+	num   []int
+}
+
+// filterFields says which fields can be filtered on and whether they are
+// numbers, which take <, <=, > and >= as well.
+var filterFields = map[string]bool{
+	"id":                 false,
+	"title":              false,
+	"genre":              false,
+	"rating":             false,
+	"director.firstname": false,
+	"director.lastname":  false,
+	"cast.name":          false,
+	"imdb_id":            false,
+	"year":               true,
+	"runtime":            true,
+	"tmdb_id":            true,
+}
+
+var term = regexp.MustCompile(`^([a-z_.]+)(>=|<=|~=|!=|=|>|<)(.*)$`)
+
+const maxLimit = 1000
+
+// parseQuery reads the raw query string itself, since url.ParseQuery would
+// take "year>=2000" for a "year>" parameter and can't split "year>2000" at
+// all. Errors are requestErrors naming the offending parameter.
+func parseQuery(raw string) (*movieQuery, error) {
+	q := &movieQuery{}
+	for _, part := range strings.Split(raw, "&") {
+		if part == "" {
+			continue
+		}
+		part, err := url.QueryUnescape(strings.ReplaceAll(part, "+", " "))
+		if err != nil {
+			return nil, &requestError{Msg: "malformed query string: " + err.Error()}
+		}
+		m := term.FindStringSubmatch(part)
+		if m == nil {
+			return nil, &requestError{Field: part, Msg: "expected a parameter such as title=..., year>=... or sort=..."}
+		}
+		name, op, value := m[1], m[2], m[3]
+
+		switch name {
+		case "sort", "limit", "offset", "cursor", "fields":
+			if op != "=" {
+				return nil, &requestError{Field: name, Msg: "takes = only"}
+			}
+			err = q.setOption(name, value)
+		default:
+			err = q.addFilter(name, op, value)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if q.cursor != nil && q.offset != 0 {
+		return nil, &requestError{Field: "cursor", Msg: "can't be combined with offset"}
+	}
+	return q, nil
+}
+
+func (q *movieQuery) setOption(name, value string) error {
+	switch name {
+	case "sort":
+		for _, k := range strings.Split(value, ",") {
+			key := sortKey{field: k}
+			if strings.HasPrefix(k, "-") {
+				key = sortKey{field: k[1:], desc: true}
+			}
+			if _, ok := sortFields[key.field]; !ok {
+				return &requestError{Field: "sort", Msg: fmt.Sprintf("can't sort by %q; one of %s", key.field, keys(sortFields))}
+			}
+			q.sort = append(q.sort, key)
+		}
+	case "limit":
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxLimit {
+			return &requestError{Field: "limit", Msg: fmt.Sprintf("must be between 1 and %d", maxLimit)}
+		}
+		q.limit = n
+	case "offset":
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return &requestError{Field: "offset", Msg: "must be a number of at least 0"}
+		}
+		q.offset = n
+	case "cursor":
+		c, err := decodeCursor(value)
+		if err != nil {
+			return &requestError{Field: "cursor", Msg: "is not a cursor this server handed out"}
+		}
+		q.cursor = c
+	case "fields":
+		for _, f := range strings.Split(value, ",") {
+			if !movieFields[f] {
+				return &requestError{Field: "fields", Msg: fmt.Sprintf("unknown field %q", f)}
+			}
+			q.fields = append(q.fields, f)
+		}
+	}
+	return nil
+}
+
+func (q *movieQuery) addFilter(name, op, value string) error {
+	numeric, ok := filterFields[name]
+	if !ok {
+		return &requestError{Field: name, Msg: fmt.Sprintf("unknown parameter; filter on one of %s", keys(filterFields))}
+	}
+	f := filter{field: name, op: op, text: strings.Split(value, ",")}
+	if op != "=" && len(f.text) > 1 {
+		return &requestError{Field: name, Msg: "only = takes a list of values"}
+	}
+
+	if !numeric {
+		if op != "=" && op != "!=" && op != "~=" {
+			return &requestError{Field: name, Msg: "takes =, != or ~="}
+		}
+		q.filters = append(q.filters, f)
+		return nil
+	}
+
+	if op == "~=" {
+		return &requestError{Field: name, Msg: "is a number and takes =, !=, <, <=, > or >="}
+	}
+	for _, v := range f.text {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return &requestError{Field: name, Msg: fmt.Sprintf("%q is not a number", v)}
+		}
+		f.num = append(f.num, n)
+	}
+	q.filters = append(q.filters, f)
+	return nil
+}
+
+// match reports whether m passes f.
+func (f filter) match(m Movie) bool {
+	if f.num != nil {
+		var v int
+		switch f.field {
+		case "year":
+			// An unknown year matches no year, as in the index.
+			if m.Year == 0 {
+				return f.op == "!="
+			}
+			v = m.Year
+		case "runtime":
+			v = m.Runtime
+		case "tmdb_id":
+			v = m.TMDBID
+		}
+		switch f.op {
+		case "=":
+			for _, n := range f.num {
+				if v == n {
+					return true
+				}
+			}
+			return false
+		case "!=":
+			return v != f.num[0]
+		case "<":
+			return v < f.num[0]
+		case "<=":
+			return v <= f.num[0]
+		case ">":
+			return v > f.num[0]
+		}
+		return v >= f.num[0]
+	}
+
+	var values []string
+	switch f.field {
+	case "id":
+		values = []string{m.ID}
+	case "title":
+		values = []string{m.Title}
+	case "genre":
+		values = m.Genres
+	case "rating":
+		values = []string{m.Rating}
+	case "imdb_id":
+		values = []string{m.IMDbID}
+	case "director.firstname":
+		for _, d := range m.Directors {
+			values = append(values, d.Firstname)
+		}
+	case "director.lastname":
+		for _, d := range m.Directors {
+			values = append(values, d.Lastname)
+		}
+	case "cast.name":
+		for _, c := range m.Cast {
+			values = append(values, c.Name)
+		}
+	}
+
+	// A movie matches if any of its values does: any genre, any director.
+	for _, v := range values {
+		for _, want := range f.text {
+			if f.op == "~=" && strings.Contains(strings.ToLower(v), strings.ToLower(want)) ||
+				f.op != "~=" && strings.EqualFold(v, want) {
+				return f.op != "!="
+			}
+		}
+	}
+	return f.op == "!="
+}
+
+// candidates returns the movies that can match q, from the most selective
+// index its filters allow, or every movie if none of them is indexed.
+func (q *movieQuery) candidates(store MovieStore) []Movie {
+	var best []Movie
+	found := false
+	for _, f := range q.filters {
+		var list []Movie
+		switch {
+		case f.field == "genre" && f.op == "=":
+			list = store.ByGenre(f.text...)
+		case f.field == "director.lastname" && f.op == "=":
+			list = store.ByDirector(f.text...)
+		case f.field == "year" && f.op != "!=":
+			list = yearRange(store, f)
+		default:
+			continue
+		}
+		if !found || len(list) < len(best) {
+			best, found = list, true
+		}
+	}
+	if !found {
+		return store.List()
+	}
+	return best
+}
+
+// yearRange looks up the years f allows. For a list of years it takes the
+// range they span, leaving the rest to f.match.
+func yearRange(store MovieStore, f filter) []Movie {
+	from, to := math.MinInt, math.MaxInt
+	n := f.num[0]
+	switch f.op {
+	case "=":
+		from, to = n, n
+		for _, y := range f.num {
+			from, to = min(from, y), max(to, y)
+		}
+	case "<":
+		to = n - 1
+	case "<=":
+		to = n
+	case ">":
+		from = n + 1
+	case ">=":
+		from = n
+	}
+	return store.ByYear(from, to)
+}
+
+// Run answers q from store. It returns the page of movies, the number of
+// matches before paging, and a cursor for the next page if there is one.
+func (q *movieQuery) Run(store MovieStore) ([]Movie, int, string, error) {
+	var list []Movie
+	for _, m := range q.candidates(store) {
+		ok := true
+		for _, f := range q.filters {
+			if !f.match(m) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			list = append(list, m)
+		}
+	}
+
+	// Index results come in List order, which is kept if no sort is asked
+	// for; otherwise the ID breaks ties.
+	if len(q.sort) > 0 {
+		sort.Slice(list, func(i, j int) bool { return q.less(list[i], list[j]) })
+	}
+	total := len(list)
+
+	start := q.offset
+	if q.cursor != nil {
+		if q.cursor.Query != q.fingerprint() {
+			return nil, 0, "", &requestError{Field: "cursor", Msg: "belongs to a different query"}
+		}
+		start = q.resume(list)
+	}
+	start = min(max(start, 0), len(list))
+	end := len(list)
+	if q.limit > 0 && start+q.limit < end {
+		end = start + q.limit
+	}
+
+	next := ""
+	if end < len(list) {
+		next = q.nextCursor(list[end-1], end)
+	}
+	return list[start:end], total, next, nil
+}
+
+type sortKey struct {
+	field string
+	desc  bool
+}
+
+// sortFields give the value a movie sorts by: a string, compared without
+// case, or a number.
+var sortFields = map[string]func(Movie) interface{}{
+	"id":      func(m Movie) interface{} { return m.ID },
+	"title":   func(m Movie) interface{} { return strings.ToLower(m.Title) },
+	"year":    func(m Movie) interface{} { return m.Year },
+	"runtime": func(m Movie) interface{} { return m.Runtime },
+	"rating": func(m Movie) interface{} {
+		for i, r := range ratings {
+			if r == m.Rating {
+				return i
+			}
+		}
+		return len(ratings)
+	},
+	"director.lastname": func(m Movie) interface{} {
+		if len(m.Directors) == 0 {
+			return ""
+		}
+		return strings.ToLower(m.Directors[0].Lastname)
+	},
+}
+
+func (q *movieQuery) less(a, b Movie) bool {
+	return q.compare(q.sortValues(a), a.ID, q.sortValues(b), b.ID) < 0
+}
+
+func (q *movieQuery) sortValues(m Movie) []interface{} {
+	values := make([]interface{}, len(q.sort))
+	for i, k := range q.sort {
+		values[i] = sortFields[k.field](m)
+	}
+	return values
+}
+
+// compare orders two movies by their sort values and then their IDs.
+func (q *movieQuery) compare(a []interface{}, aID string, b []interface{}, bID string) int {
+	for i, k := range q.sort {
+		c := 0
+		switch x := a[i].(type) {
+		case int:
+			c = x - b[i].(int)
+		case string:
+			c = strings.Compare(x, b[i].(string))
+		}
+		if c != 0 {
+			if k.desc {
+				return -c
+			}
+			return c
+		}
+	}
+	return strings.Compare(aID, bID)
+}
+
+// cursor marks where a page ended: after the movie with ID After, whose
+// sort values were Values, at position Offset. Query ties it to the filters
+// and sort order it was made for.
+type cursor struct {
+	After  string            `json:"after"`
+	Values []json.RawMessage `json:"values,omitempty"`
+	Offset int               `json:"offset"`
+	Query  string            `json:"query"`
+}
+
+func (q *movieQuery) nextCursor(last Movie, offset int) string {
+	c := cursor{After: last.ID, Offset: offset, Query: q.fingerprint()}
+	for _, v := range q.sortValues(last) {
+		data, _ := json.Marshal(v)
+		c.Values = append(c.Values, data)
+	}
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(s string) (*cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	var c cursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, err
+	}
+	// the cursor comes back from the client, which may have made it up
+	if c.Offset < 0 {
+		return nil, errors.New("negative offset")
+	}
+	return &c, nil
+}
+
+// resume finds where the page after the cursor starts in list. Movies added
+// or removed before it don't shift the page, as they would with offsets,
+// since it starts right after the last movie shown. If that movie is gone,
+// a sorted query continues after its sort values, and an unsorted one at
+// the old offset.
+func (q *movieQuery) resume(list []Movie) int {
+	c := q.cursor
+	for i, m := range list {
+		if m.ID == c.After {
+			return i + 1
+		}
+	}
+	if len(q.sort) == 0 || len(c.Values) != len(q.sort) {
+		return c.Offset
+	}
+
+	values := make([]interface{}, len(q.sort))
+	for i, raw := range c.Values {
+		var err error
+		switch sortFields[q.sort[i].field](Movie{}).(type) {
+		case int:
+			var n int
+			err = json.Unmarshal(raw, &n)
+			values[i] = n
+		case string:
+			var s string
+			err = json.Unmarshal(raw, &s)
+			values[i] = s
+		}
+		if err != nil {
+			return c.Offset
+		}
+	}
+	return sort.Search(len(list), func(i int) bool {
+		return q.compare(q.sortValues(list[i]), list[i].ID, values, c.After) > 0
+	})
+}
+
+// fingerprint identifies the filters and sort order of q, which a cursor
+// must be used with.
+func (q *movieQuery) fingerprint() string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%v|%v", q.filters, q.sort)
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// movieFieldNames are the top level JSON fields of a Movie, in order.
+var movieFieldNames = []string{
+	"id", "title", "year", "runtime", "genres", "directors", "cast",
+	"rating", "synopsis", "imdb_id", "tmdb_id",
+}
+
+// movieFields are movieFieldNames as a set, for fields=.
+var movieFields = func() map[string]bool {
+	set := map[string]bool{}
+	for _, f := range movieFieldNames {
+		set[f] = true
+	}
+	return set
+}()
+
+// Project returns the movies to be cut down to the fields asked for,
+// always with their ID, or all of them if no fields were asked for.
+func (q *movieQuery) Project(list []Movie) movieList {
+	return movieList{Movies: list, Fields: q.fields}
+}
+
+func keys[V any](m map[string]V) string {
+	list := make([]string, 0, len(m))
+	for k := range m {
+		list = append(list, k)
+	}
+	sort.Strings(list)
+	return strings.Join(list, ", ")
+}
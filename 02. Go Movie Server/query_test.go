@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Cursors come back from clients, who can forge them along with the
+// fingerprint of the query.
+func TestForgedCursor(t *testing.T) {
+	s := &server{store: NewMemoryStore(), ids: &sequence{}}
+	for _, title := range []string{"Heat", "Thief", "Collateral"} {
+		s.store.Create(Movie{ID: title, Title: title, Directors: []Director{{Lastname: "Mann"}}})
+	}
+	h := s.routes()
+
+	q, err := parseQuery("limit=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	forge := func(offset int) string {
+		data, _ := json.Marshal(cursor{After: "gone", Offset: offset, Query: q.fingerprint()})
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+
+	for _, c := range []struct {
+		offset int
+		code   int
+		count  int
+	}{
+		{-5, http.StatusBadRequest, 0},
+		{1, http.StatusOK, 1},
+		{100, http.StatusOK, 0},
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/movies?limit=1&cursor="+forge(c.offset), nil))
+		var list []Movie
+		json.Unmarshal(w.Body.Bytes(), &list)
+		if w.Code != c.code || (c.code == http.StatusOK && len(list) != c.count) {
+			t.Errorf("offset %d: got %d with %s, want %d with %d movies", c.offset, w.Code, w.Body, c.code, c.count)
+		}
+	}
+}
@@ -0,0 +1,412 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Recommender keeps the similarity of every pair of movies, blended from
+// two measures:
+//
+//   - item-item collaborative filtering: the adjusted cosine of the ratings
+//     the users who rated both movies gave them, each centred on that
+//     user's mean rating;
+//   - content similarity: the Jaccard similarity of the movies' genres,
+//     directors and cast, weighted 0.5, 0.3 and 0.2.
+//
+// The more users rated both movies, the more the ratings count:
+// with n co-raters the blend is n/(n+shrink) ratings and the rest content.
+//
+// Similarities are computed in a background goroutine. Touch marks movies
+// whose data or ratings changed, and only their rows are recomputed.
+type Recommender struct {
+	movies MovieStore
+	users  UserStore
+
+	// Debounce is how long Run waits after a Touch for more to come
+	// before refreshing; Rebuild is how often everything is recomputed.
+	Debounce time.Duration
+	Rebuild  time.Duration
+
+	mu    sync.RWMutex
+	sims  map[string]map[string]float64 // movie ID to movie ID to similarity
+	built bool
+
+	dirtyMu sync.Mutex
+	dirty   map[string]bool
+	all     bool
+	wake    chan struct{}
+}
+
+const (
+	shrink         = 5.0
+	genreWeight    = 0.5
+	directorWeight = 0.3
+	castWeight     = 0.2
+)
+
+func NewRecommender(movies MovieStore, users UserStore) *Recommender {
+	return &Recommender{
+		movies:   movies,
+		users:    users,
+		Debounce: 500 * time.Millisecond,
+		Rebuild:  time.Hour,
+		sims:     map[string]map[string]float64{},
+		dirty:    map[string]bool{},
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+// Touch marks movies for their similarities to be recomputed.
+func (r *Recommender) Touch(movieIDs ...string) {
+	if len(movieIDs) == 0 {
+		return
+	}
+	r.dirtyMu.Lock()
+	for _, id := range movieIDs {
+		r.dirty[id] = true
+	}
+	r.dirtyMu.Unlock()
+
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run keeps the similarities up to date until ctx is done. It doesn't
+// compute them to begin with; call Refresh for that first.
+func (r *Recommender) Run(ctx context.Context) {
+	rebuild := time.NewTicker(r.Rebuild)
+	defer rebuild.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-rebuild.C:
+			r.dirtyMu.Lock()
+			r.all = true
+			r.dirtyMu.Unlock()
+		case <-r.wake:
+			// Let a burst of changes settle.
+			select {
+			case <-time.After(r.Debounce):
+			case <-ctx.Done():
+				return
+			}
+		}
+		r.Refresh()
+	}
+}
+
+// Refresh recomputes the rows of the touched movies, or every row after a
+// full rebuild was due or before anything was computed.
+func (r *Recommender) Refresh() {
+	r.dirtyMu.Lock()
+	dirty, all := r.dirty, r.all
+	r.dirty, r.all = map[string]bool{}, false
+	r.dirtyMu.Unlock()
+
+	r.mu.RLock()
+	all = all || !r.built
+	r.mu.RUnlock()
+
+	start := time.Now()
+	d := r.load()
+	if all {
+		dirty = map[string]bool{}
+		for id := range d.movies {
+			dirty[id] = true
+		}
+	}
+	if len(dirty) == 0 {
+		return
+	}
+
+	rows := map[string]map[string]float64{}
+	for i := range dirty {
+		if _, ok := d.movies[i]; !ok {
+			continue
+		}
+		row := map[string]float64{}
+		for j := range d.movies {
+			if i != j {
+				if s := d.similarity(i, j); s != 0 {
+					row[j] = s
+				}
+			}
+		}
+		rows[i] = row
+	}
+
+	r.mu.Lock()
+	if all {
+		r.sims = map[string]map[string]float64{}
+		r.built = true
+	}
+	for i := range dirty {
+		// Drop the old row and its mirror entries, then put the new ones in:
+		// similarity is symmetric, so a new row changes a column as well.
+		for j := range r.sims[i] {
+			delete(r.sims[j], i)
+		}
+		delete(r.sims, i)
+		for j, s := range rows[i] {
+			r.set(i, j, s)
+			r.set(j, i, s)
+		}
+	}
+	r.mu.Unlock()
+
+	log.Printf("recommender: refreshed %d of %d movies in %s", len(rows), len(d.movies), time.Since(start).Round(time.Millisecond))
+}
+
+// set puts one similarity in. The caller holds r.mu.
+func (r *Recommender) set(i, j string, s float64) {
+	row, ok := r.sims[i]
+	if !ok {
+		row = map[string]float64{}
+		r.sims[i] = row
+	}
+	row[j] = s
+}
+
+// recData is what similarities are computed from: every movie, and every
+// movie's ratings by user, centred on that user's mean rating.
+type recData struct {
+	movies  map[string]Movie
+	ratings map[string]map[string]float64
+}
+
+func (r *Recommender) load() *recData {
+	d := &recData{movies: map[string]Movie{}, ratings: map[string]map[string]float64{}}
+	for _, m := range r.movies.List() {
+		d.movies[m.ID] = m
+	}
+	for _, u := range r.users.ListUsers() {
+		latest := latestRatings(u)
+		if len(latest) == 0 {
+			continue
+		}
+		var sum float64
+		for _, rating := range latest {
+			sum += float64(rating)
+		}
+		mean := sum / float64(len(latest))
+		for movieID, rating := range latest {
+			if d.ratings[movieID] == nil {
+				d.ratings[movieID] = map[string]float64{}
+			}
+			d.ratings[movieID][u.ID] = float64(rating) - mean
+		}
+	}
+	return d
+}
+
+// latestRatings returns the latest rating a user gave each movie they
+// rated.
+func latestRatings(u User) map[string]int {
+	latest := map[string]int{}
+	when := map[string]time.Time{}
+	for _, v := range u.History {
+		if v.Rating == 0 {
+			continue
+		}
+		if t, ok := when[v.MovieID]; !ok || !v.WatchedAt.Before(t) {
+			latest[v.MovieID] = v.Rating
+			when[v.MovieID] = v.WatchedAt
+		}
+	}
+	return latest
+}
+
+func (d *recData) similarity(i, j string) float64 {
+	content := contentSimilarity(d.movies[i], d.movies[j])
+
+	ri, rj := d.ratings[i], d.ratings[j]
+	if len(ri) > len(rj) {
+		ri, rj = rj, ri
+	}
+	var dot, ni, nj float64
+	n := 0
+	for u, a := range ri {
+		b, ok := rj[u]
+		if !ok {
+			continue
+		}
+		dot += a * b
+		ni += a * a
+		nj += b * b
+		n++
+	}
+	if n == 0 || ni == 0 || nj == 0 {
+		return content
+	}
+	cf := dot / math.Sqrt(ni*nj)
+	alpha := float64(n) / (float64(n) + shrink)
+	return alpha*cf + (1-alpha)*content
+}
+
+func contentSimilarity(a, b Movie) float64 {
+	var directorsA, directorsB, castA, castB []string
+	for _, d := range a.Directors {
+		directorsA = append(directorsA, d.Firstname+" "+d.Lastname)
+	}
+	for _, d := range b.Directors {
+		directorsB = append(directorsB, d.Firstname+" "+d.Lastname)
+	}
+	for _, c := range a.Cast {
+		castA = append(castA, c.Name)
+	}
+	for _, c := range b.Cast {
+		castB = append(castB, c.Name)
+	}
+	return genreWeight*jaccard(a.Genres, b.Genres) +
+		directorWeight*jaccard(directorsA, directorsB) +
+		castWeight*jaccard(castA, castB)
+}
+
+// jaccard is the size of the intersection of a and b over that of their
+// union, ignoring case.
+func jaccard(a, b []string) float64 {
+	setA, setB := map[string]bool{}, map[string]bool{}
+	for _, s := range a {
+		setA[strings.ToLower(s)] = true
+	}
+	for _, s := range b {
+		setB[strings.ToLower(s)] = true
+	}
+	both := 0
+	for s := range setA {
+		if setB[s] {
+			both++
+		}
+	}
+	union := len(setA) + len(setB) - both
+	if union == 0 {
+		return 0
+	}
+	return float64(both) / float64(union)
+}
+
+// Scored is a movie with how well it fits a similar or recommendations
+// list; Because names the movie that contributed most to a recommendation.
+type Scored struct {
+	MovieID string
+	Score   float64
+	Because string
+}
+
+// Similar returns the movies most similar to movieID, best first.
+func (r *Recommender) Similar(movieID string, limit int) []Scored {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var list []Scored
+	for j, s := range r.sims[movieID] {
+		if s > 0 {
+			list = append(list, Scored{MovieID: j, Score: s})
+		}
+	}
+	return top(list, limit)
+}
+
+// Weights of what a user did with a movie, for recommendations. A rating
+// counts from -1 for a 1 to 1 for a 10.
+const (
+	watchedWeight   = 0.5
+	watchlistWeight = 0.3
+)
+
+// Recommend returns the movies u is most likely to enjoy and hasn't seen
+// or put on their watchlist: those most similar to what they rated highly,
+// watched or want to watch, and least similar to what they rated low.
+func (r *Recommender) Recommend(u User, limit int) []Scored {
+	weights := map[string]float64{}
+	for _, v := range u.History {
+		weights[v.MovieID] = watchedWeight
+	}
+	for movieID, rating := range latestRatings(u) {
+		weights[movieID] = (float64(rating) - 5.5) / 4.5
+	}
+	for _, e := range u.Watchlist {
+		if _, ok := weights[e.MovieID]; !ok {
+			weights[e.MovieID] = watchlistWeight
+		}
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	scores := map[string]float64{}
+	best := map[string]float64{}
+	because := map[string]string{}
+	for i, w := range weights {
+		for j, s := range r.sims[i] {
+			if _, known := weights[j]; known {
+				continue
+			}
+			contribution := w * s
+			scores[j] += contribution
+			if contribution > best[j] {
+				best[j], because[j] = contribution, i
+			}
+		}
+	}
+
+	var list []Scored
+	for j, s := range scores {
+		if s > 0 {
+			list = append(list, Scored{MovieID: j, Score: s, Because: because[j]})
+		}
+	}
+	return top(list, limit)
+}
+
+// popularPrior is how many ratings of 5.5 every movie starts with for
+// Popular, so that one 10 doesn't make a movie the most popular.
+const popularPrior = 2
+
+// Popular returns the best rated movies for users with nothing to go by
+// yet, leaving out those in exclude. The score is the movie's mean rating,
+// pulled towards the middle when it has few, over 10.
+func (r *Recommender) Popular(exclude map[string]bool, limit int) []Scored {
+	sums := map[string]float64{}
+	counts := map[string]int{}
+	for _, u := range r.users.ListUsers() {
+		for movieID, rating := range latestRatings(u) {
+			sums[movieID] += float64(rating)
+			counts[movieID]++
+		}
+	}
+
+	var list []Scored
+	for movieID, n := range counts {
+		if exclude[movieID] {
+			continue
+		}
+		mean := (sums[movieID] + popularPrior*5.5) / float64(n+popularPrior)
+		list = append(list, Scored{MovieID: movieID, Score: mean / 10})
+	}
+	return top(list, limit)
+}
+
+// top sorts list best first, ties by ID, and cuts it to limit.
+func top(list []Scored, limit int) []Scored {
+	sort.Slice(list, func(a, b int) bool {
+		if list[a].Score != list[b].Score {
+			return list[a].Score > list[b].Score
+		}
+		return list[a].MovieID < list[b].MovieID
+	})
+	if len(list) > limit {
+		list = list[:limit]
+	}
+	return list
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// recommendation is a movie in a similar or recommendations list. Because
+// is the ID of the movie that led to a recommendation most.
+type recommendation struct {
+	Movie   Movie   `json:"movie" xml:"movie"`
+	Score   float64 `json:"score" xml:"score"`
+	Because string  `json:"because,omitempty" xml:"because,omitempty"`
+}
+
+const (
+	defaultRecommendations = 10
+	maxRecommendations     = 100
+)
+
+// recommendations turns scored movies into the list the routes show,
+// leaving out movies deleted since the similarities were computed.
+func (s *server) recommendations(scored []Scored) []recommendation {
+	list := []recommendation{}
+	for _, sc := range scored {
+		if m := s.movie(sc.MovieID); m != nil {
+			list = append(list, recommendation{Movie: *m, Score: sc.Score, Because: sc.Because})
+		}
+	}
+	return list
+}
+
+// recommendationLimit reads ?limit=, which defaults to 10.
+func recommendationLimit(r *http.Request) (int, error) {
+	v := r.URL.Query().Get("limit")
+	if v == "" {
+		return defaultRecommendations, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 || n > maxRecommendations {
+		return 0, &requestError{Field: "limit", Msg: "must be between 1 and " + strconv.Itoa(maxRecommendations)}
+	}
+	return n, nil
+}
+
+func (s *server) similarMovies(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	limit, err := recommendationLimit(r)
+	if err != nil {
+		writeRequestError(w, err)
+		return
+	}
+	if _, err := s.store.Get(id); err != nil {
+		writeStoreError(w, err, id)
+		return
+	}
+	if s.recs == nil {
+		writeError(w, http.StatusServiceUnavailable, "recommendations are not computed")
+		return
+	}
+	respond(w, http.StatusOK, s.recommendations(s.recs.Similar(id, limit)))
+}
+
+// userRecommendations recommends movies to a user from what they watched,
+// rated and put on their watchlist, or the best rated movies if they have
+// done none of that yet.
+func (s *server) userRecommendations(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	limit, err := recommendationLimit(r)
+	if err != nil {
+		writeRequestError(w, err)
+		return
+	}
+	u, err := s.users.GetUser(id)
+	if err != nil {
+		writeStoreError(w, err, id)
+		return
+	}
+	if s.recs == nil {
+		writeError(w, http.StatusServiceUnavailable, "recommendations are not computed")
+		return
+	}
+
+	if len(u.History) == 0 && len(u.Watchlist) == 0 {
+		writeJSON(w, http.StatusOK, s.recommendations(s.recs.Popular(nil, limit)))
+		return
+	}
+	writeJSON(w, http.StatusOK, s.recommendations(s.recs.Recommend(u, limit)))
+}
+
+// touch tells the recommender, if there is one, that movies or their
+// ratings changed.
+func (s *server) touch(movieIDs ...string) {
+	if s.recs != nil {
+		s.recs.Touch(movieIDs...)
+	}
+}
+
+// rated returns the IDs of the movies u rated, whose similarities change
+// when u's ratings do: each is centred on u's mean.
+func rated(u User) []string {
+	var ids []string
+	for movieID := range latestRatings(u) {
+		ids = append(ids, movieID)
+	}
+	return ids
+}
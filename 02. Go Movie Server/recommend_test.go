@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Heat and Thief share a director, an actor and a genre; Gladiator has
+// Heat's genres and Alien's director.
+var (
+	heat = Movie{ID: "heat", Title: "Heat", Genres: []string{"Crime", "Drama"},
+		Directors: []Director{{Firstname: "Michael", Lastname: "Mann"}}, Cast: []CastMember{{Name: "Al Pacino"}}}
+	thief = Movie{ID: "thief", Title: "Thief", Genres: []string{"crime"},
+		Directors: []Director{{Firstname: "Michael", Lastname: "Mann"}}, Cast: []CastMember{{Name: "al pacino"}}}
+	alien = Movie{ID: "alien", Title: "Alien", Genres: []string{"Horror"},
+		Directors: []Director{{Firstname: "Ridley", Lastname: "Scott"}}}
+	gladiator = Movie{ID: "gladiator", Title: "Gladiator", Genres: []string{"Crime", "Drama"},
+		Directors: []Director{{Firstname: "Ridley", Lastname: "Scott"}}}
+)
+
+func near(a, b float64) bool { return math.Abs(a-b) < 1e-9 }
+
+func TestContentSimilarity(t *testing.T) {
+	for _, c := range []struct {
+		a, b Movie
+		want float64
+	}{
+		// half the genres, the director and the cast, ignoring case
+		{heat, thief, genreWeight*0.5 + directorWeight + castWeight},
+		{heat, gladiator, genreWeight},
+		{thief, gladiator, genreWeight * 0.5},
+		{alien, gladiator, directorWeight},
+		{heat, alien, 0},
+		{Movie{}, Movie{}, 0},
+	} {
+		if got := contentSimilarity(c.a, c.b); !near(got, c.want) {
+			t.Errorf("%s and %s: got %v, want %v", c.a.ID, c.b.ID, got, c.want)
+		}
+		if got := contentSimilarity(c.b, c.a); !near(got, c.want) {
+			t.Errorf("%s and %s: got %v, want %v", c.b.ID, c.a.ID, got, c.want)
+		}
+	}
+}
+
+// rate gives u a viewing of each movie with the rating, a day apart.
+func rate(u *User, ratings map[string]int) {
+	day := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, id := range []string{"heat", "thief", "alien", "gladiator"} {
+		if rating, ok := ratings[id]; ok {
+			u.LastViewing++
+			u.History = append(u.History, Viewing{ID: u.LastViewing, MovieID: id, WatchedAt: day, Rating: rating})
+			day = day.AddDate(0, 0, 1)
+		}
+	}
+}
+
+func TestRecommender(t *testing.T) {
+	store := NewMemoryStore()
+	for _, m := range []Movie{heat, thief, alien, gladiator} {
+		store.Create(m)
+	}
+	// both who rated Heat and Alien liked one and not the other
+	for id, ratings := range map[string]map[string]int{
+		"ann": {"heat": 10, "alien": 2},
+		"bob": {"heat": 8, "alien": 4},
+	} {
+		u := User{ID: id, Name: id}
+		rate(&u, ratings)
+		store.CreateUser(u)
+	}
+	store.CreateUser(User{ID: "new", Name: "New"})
+
+	recs := NewRecommender(store, store)
+	recs.Refresh()
+
+	// with n co-raters the ratings count n/(n+shrink): here an adjusted
+	// cosine of -1, blended with a content similarity of 0
+	d := recs.load()
+	if got, want := d.similarity("heat", "alien"), -2/(2+shrink); !near(got, want) {
+		t.Errorf("similarity of Heat and Alien: got %v, want %v", got, want)
+	}
+
+	similar := func(id string) map[string]float64 {
+		got := map[string]float64{}
+		for _, s := range recs.Similar(id, 10) {
+			got[s.MovieID] = s.Score
+		}
+		return got
+	}
+	for _, c := range []struct {
+		id   string
+		want map[string]float64 // only the positive ones are listed
+	}{
+		{"heat", map[string]float64{"thief": contentSimilarity(heat, thief), "gladiator": genreWeight}},
+		{"alien", map[string]float64{"gladiator": directorWeight}},
+	} {
+		got := similar(c.id)
+		for id, s := range c.want {
+			if !near(got[id], s) {
+				t.Errorf("similar to %s: got %v, want %v", c.id, got, c.want)
+			}
+		}
+		if len(got) != len(c.want) {
+			t.Errorf("similar to %s: got %v, want %v", c.id, got, c.want)
+		}
+	}
+	if got := recs.Similar("heat", 1); len(got) != 1 || got[0].MovieID != "thief" {
+		t.Errorf("most similar to Heat: %+v", got)
+	}
+
+	// someone who loved Heat and has Thief on their watchlist, which is a
+	// little like Gladiator too
+	fan := User{ID: "fan", Watchlist: []WatchlistEntry{{MovieID: "thief"}}}
+	rate(&fan, map[string]int{"heat": 10})
+	got := recs.Recommend(fan, 10)
+	if len(got) != 1 || got[0].MovieID != "gladiator" || got[0].Because != "heat" ||
+		!near(got[0].Score, genreWeight+watchlistWeight*genreWeight*0.5) {
+		t.Errorf("recommended %+v, Gladiator because of Heat", got)
+	}
+
+	// Heat's mean of 9 is pulled towards 5.5 by two ratings of that
+	popular := recs.Popular(map[string]bool{"alien": true}, 10)
+	if len(popular) != 1 || popular[0].MovieID != "heat" || !near(popular[0].Score, (18+popularPrior*5.5)/(2+popularPrior)/10) {
+		t.Errorf("popular: %+v", popular)
+	}
+
+	// only touched movies are recomputed, columns included
+	changed := gladiator.clone()
+	changed.Genres = []string{"Horror"}
+	store.Update(changed)
+	store.Delete("thief")
+	recs.Touch("gladiator", "thief")
+	recs.Refresh()
+	if got := similar("heat"); len(got) != 0 {
+		t.Errorf("similar to Heat after the changes: %v", got)
+	}
+	if got := similar("alien")["gladiator"]; !near(got, genreWeight+directorWeight) {
+		t.Errorf("similarity of Alien and Gladiator after the change: %v", got)
+	}
+
+	s := &server{store: store, ids: &sequence{}, users: store, userIDs: &sequence{}, recs: recs}
+	h := s.routes()
+	for _, c := range []struct {
+		target string
+		code   int
+		want   []string
+	}{
+		{"/movies/alien/similar", http.StatusOK, []string{"gladiator"}},
+		{"/movies/heat/similar", http.StatusOK, []string{}},
+		{"/movies/thief/similar", http.StatusNotFound, nil},
+		{"/movies/heat/similar?limit=0", http.StatusBadRequest, nil},
+		// Gladiator is like Alien now, which Ann didn't like
+		{"/users/ann/recommendations", http.StatusOK, []string{}},
+		// nothing to go by yet, so the best rated
+		{"/users/new/recommendations", http.StatusOK, []string{"heat", "alien"}},
+		{"/users/new/recommendations?limit=1", http.StatusOK, []string{"heat"}},
+		{"/users/gone/recommendations", http.StatusNotFound, nil},
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", c.target, nil))
+		var list []recommendation
+		json.Unmarshal(w.Body.Bytes(), &list)
+		got := []string{}
+		for _, r := range list {
+			got = append(got, r.Movie.ID)
+		}
+		if w.Code != c.code || (c.want != nil && !reflect.DeepEqual(got, c.want)) {
+			t.Errorf("%s: got %d with %s, want %d with %v", c.target, w.Code, w.Body, c.code, c.want)
+		}
+	}
+
+	s.recs = nil
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/movies/heat/similar", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("without a recommender: got %d, want 503", w.Code)
+	}
+}
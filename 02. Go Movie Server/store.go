@@ -0,0 +1,60 @@
+package main
+
+import "errors"
+
+var (
+	ErrNotFound = errors.New("movie not found")
+	ErrExists   = errors.New("movie already exists")
+)
+
+// ErrIMDbTaken means a change would give a movie the IMDb ID of another
+// one, movie ID; there is one movie per IMDb ID.
+type ErrIMDbTaken struct {
+	IMDbID string
+	ID     string
+}
+
+func (e *ErrIMDbTaken) Error() string {
+	return "movie " + e.IMDbID + " is already movie " + e.ID
+}
+
+// MovieStore holds the movies. Implementations must be safe for concurrent
+// use, and every Movie they return is a copy the caller may modify.
+type MovieStore interface {
+	// List returns a snapshot of all movies in insertion order.
+	List() []Movie
+	Get(id string) (Movie, error)
+	// ByGenre, ByDirector and ByYear answer from secondary indexes, in List
+	// order. ByGenre and ByDirector return the movies with any of the genres
+	// or directors' last names given, regardless of case; the years of
+	// ByYear are inclusive and movies without a year never match.
+	ByGenre(genres ...string) []Movie
+	ByDirector(lastnames ...string) []Movie
+	ByYear(from, to int) []Movie
+	// ByIMDb returns the movie with the IMDb ID, or ErrNotFound.
+	ByIMDb(imdbID string) (Movie, error)
+	// Create adds m, failing with ErrExists if its ID is taken. Create,
+	// Update, Modify and Revert fail with *ErrIMDbTaken if the movie would
+	// get the IMDb ID of another one.
+	Create(m Movie) error
+	// Update replaces the movie with m's ID, failing with ErrNotFound.
+	Update(m Movie) error
+	// Modify replaces the movie with the given ID by what fn makes of it,
+	// atomically with respect to other changes. An error from fn is
+	// returned as is and leaves the movie alone; fn must keep the ID and
+	// must not call back into the store.
+	Modify(id string, fn func(Movie) (Movie, error)) (Movie, error)
+	Delete(id string) error
+	// Versions returns the kept versions of a movie, oldest first, or
+	// ErrNotFound. A deleted movie's versions go with it.
+	Versions(id string) ([]Version, error)
+	// Revert makes the movie what it was in version n, as a new version,
+	// failing with ErrNotFound, or ErrNoVersion if n isn't kept.
+	Revert(id string, n int) (Movie, error)
+}
+
+// Store is everything the server keeps.
+type Store interface {
+	MovieStore
+	UserStore
+}
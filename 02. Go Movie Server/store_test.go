@@ -0,0 +1,160 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// testStores returns a new store of each kind, for tests that every
+// MovieStore must pass.
+func testStores(t *testing.T) []struct {
+	name  string
+	store MovieStore
+} {
+	fs, err := OpenFileStore(t.TempDir(), FileStoreOptions{Sync: SyncNever, CompactEvery: 50})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { fs.Close() })
+	return []struct {
+		name  string
+		store MovieStore
+	}{
+		{"memory", NewMemoryStore()},
+		{"file", fs},
+	}
+}
+
+// TestStoreConcurrency is for go test -race: handlers create, change,
+// delete and list movies at the same time.
+func TestStoreConcurrency(t *testing.T) {
+	const workers, each = 8, 25
+
+	for _, c := range testStores(t) {
+		t.Run(c.name, func(t *testing.T) {
+			store := c.store
+			var wg sync.WaitGroup
+			for w := 0; w < workers; w++ {
+				wg.Add(1)
+				go func(w int) {
+					defer wg.Done()
+					for i := 0; i < each; i++ {
+						id := fmt.Sprintf("%d-%d", w, i)
+						if err := store.Create(Movie{ID: id, Title: id, Genres: []string{"Drama"}}); err != nil {
+							t.Error(err)
+							return
+						}
+						_, err := store.Modify(id, func(m Movie) (Movie, error) {
+							m.Year = 1900 + i
+							m.Genres = append(m.Genres, "Crime")
+							return m, nil
+						})
+						if err != nil {
+							t.Error(err)
+						}
+						store.List()
+						store.ByGenre("crime")
+						store.ByYear(1900, 1910)
+						store.Versions(id)
+						if i%2 == 1 {
+							if err := store.Delete(id); err != nil {
+								t.Error(err)
+							}
+						}
+					}
+				}(w)
+			}
+			wg.Wait()
+
+			list := store.List()
+			if want := workers * (each + 1) / 2; len(list) != want {
+				t.Errorf("%d movies left, want %d", len(list), want)
+			}
+			for _, m := range list {
+				if len(m.Genres) != 2 || m.Year < 1900 {
+					t.Errorf("movie %s is %+v after its change", m.ID, m)
+				}
+			}
+			if n := len(store.ByGenre("crime")); n != len(list) {
+				t.Errorf("the genre index has %d movies, want %d", n, len(list))
+			}
+
+			// what List returns is the caller's to change
+			list[0].Genres[0] = "Western"
+			if m, _ := store.Get(list[0].ID); m.Genres[0] != "Drama" {
+				t.Error("changing a listed movie changed the stored one")
+			}
+		})
+	}
+}
+
+// Imports of the same movie can race; the store keeps one per IMDb ID.
+func TestUniqueIMDb(t *testing.T) {
+	for _, c := range testStores(t) {
+		t.Run(c.name, func(t *testing.T) {
+			store := c.store
+			var wg sync.WaitGroup
+			created := make(chan string, 8)
+			for i := 0; i < 8; i++ {
+				wg.Add(1)
+				go func(id string) {
+					defer wg.Done()
+					err := store.Create(Movie{ID: id, Title: "Heat", IMDbID: "tt0113277"})
+					var taken *ErrIMDbTaken
+					switch {
+					case err == nil:
+						created <- id
+					case !errors.As(err, &taken) || taken.IMDbID != "tt0113277":
+						t.Errorf("create %s: %v", id, err)
+					}
+				}(fmt.Sprint(i))
+			}
+			wg.Wait()
+			close(created)
+			if len(created) != 1 {
+				t.Fatalf("%d movies created with the same IMDb ID", len(created))
+			}
+			heat := <-created
+			if m, err := store.ByIMDb("tt0113277"); err != nil || m.ID != heat {
+				t.Errorf("ByIMDb: got %+v, %v, want movie %s", m, err, heat)
+			}
+
+			store.Create(Movie{ID: "thief", Title: "Thief", IMDbID: "tt0083190"})
+			isTaken := func(err error) bool {
+				var taken *ErrIMDbTaken
+				return errors.As(err, &taken) && taken.ID == heat
+			}
+			if err := store.Update(Movie{ID: "thief", Title: "Thief", IMDbID: "tt0113277"}); !isTaken(err) {
+				t.Errorf("update to a taken IMDb ID: %v", err)
+			}
+			_, err := store.Modify("thief", func(m Movie) (Movie, error) {
+				m.IMDbID = "tt0113277"
+				return m, nil
+			})
+			if !isTaken(err) {
+				t.Errorf("modify to a taken IMDb ID: %v", err)
+			}
+			if m, _ := store.Get("thief"); m.IMDbID != "tt0083190" {
+				t.Errorf("a failed change changed the movie to %+v", m)
+			}
+
+			// a movie keeps its own IMDb ID, and gives it up when it changes
+			// or goes
+			if err := store.Update(Movie{ID: heat, Title: "Heat (1995)", IMDbID: "tt0113277"}); err != nil {
+				t.Error(err)
+			}
+			store.Update(Movie{ID: "thief", Title: "Thief"})
+			store.Delete(heat)
+			for _, imdbID := range []string{"tt0083190", "tt0113277"} {
+				if _, err := store.ByIMDb(imdbID); err != ErrNotFound {
+					t.Errorf("ByIMDb(%s) after it was given up: %v", imdbID, err)
+				}
+			}
+			if err := store.Create(Movie{ID: "heat", Title: "Heat", IMDbID: "tt0113277"}); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TLSOptions configure HTTPS. The certificate and key are PEM files; if
+// ClientCAFile is set, clients authenticate with certificates signed by
+// one of the CAs in it, as ClientAuth says.
+type TLSOptions struct {
+	CertFile     string
+	KeyFile      string
+	ClientCAFile string
+	// ClientAuth defaults to requiring a verified client certificate when
+	// there is a ClientCAFile; tls.VerifyClientCertIfGiven lets clients
+	// without one in too.
+	ClientAuth tls.ClientAuthType
+	// ReloadEvery is how often the files are checked for changes.
+	ReloadEvery time.Duration
+}
+
+// certReloader keeps the TLS configuration built from the files in its
+// options, and builds it again when they change on disk, so renewed
+// certificates are picked up without a restart. A change that doesn't
+// load, such as a certificate written before its key, keeps the old
+// configuration until the files are consistent again.
+type certReloader struct {
+	opts TLSOptions
+
+	mu     sync.RWMutex
+	config *tls.Config
+	stamp  string // the files' sizes and modification times
+}
+
+func NewCertReloader(opts TLSOptions) (*certReloader, error) {
+	if opts.CertFile == "" || opts.KeyFile == "" {
+		return nil, errors.New("tls: a certificate and a key file are required")
+	}
+	if opts.ClientCAFile != "" && opts.ClientAuth == tls.NoClientCert {
+		opts.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	if opts.ReloadEvery == 0 {
+		opts.ReloadEvery = 10 * time.Second
+	}
+	c := &certReloader{opts: opts}
+	if err := c.reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// TLSConfig returns the configuration for an http.Server. Every handshake
+// gets the configuration as it is at the time.
+func (c *certReloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		NextProtos: []string{"h2", "http/1.1"},
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			c.mu.RLock()
+			defer c.mu.RUnlock()
+			return c.config, nil
+		},
+	}
+}
+
+// files returns the files the configuration is built from.
+func (c *certReloader) files() []string {
+	files := []string{c.opts.CertFile, c.opts.KeyFile}
+	if c.opts.ClientCAFile != "" {
+		files = append(files, c.opts.ClientCAFile)
+	}
+	return files
+}
+
+// stampFiles describes the files' state well enough to tell that one of
+// them changed.
+func (c *certReloader) stampFiles() (string, error) {
+	var b strings.Builder
+	for _, name := range c.files() {
+		fi, err := os.Stat(name)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&b, "%s %d %d\n", name, fi.Size(), fi.ModTime().UnixNano())
+	}
+	return b.String(), nil
+}
+
+// reload builds the configuration from the files.
+func (c *certReloader) reload() error {
+	stamp, err := c.stampFiles()
+	if err != nil {
+		return fmt.Errorf("tls: %w", err)
+	}
+	cert, err := tls.LoadX509KeyPair(c.opts.CertFile, c.opts.KeyFile)
+	if err != nil {
+		return err
+	}
+
+	config := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		NextProtos:   []string{"h2", "http/1.1"},
+		Certificates: []tls.Certificate{cert},
+	}
+	if c.opts.ClientCAFile != "" {
+		pem, err := os.ReadFile(c.opts.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("tls: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("tls: no certificates in %s", c.opts.ClientCAFile)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = c.opts.ClientAuth
+	}
+
+	c.mu.Lock()
+	c.config, c.stamp = config, stamp
+	c.mu.Unlock()
+	return nil
+}
+
+// Run checks the files for changes until ctx is done, reloading them when
+// they have.
+func (c *certReloader) Run(ctx context.Context) {
+	tick := time.NewTicker(c.opts.ReloadEvery)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+		}
+
+		stamp, err := c.stampFiles()
+		c.mu.RLock()
+		changed := err == nil && stamp != c.stamp
+		c.mu.RUnlock()
+		if !changed {
+			continue
+		}
+		if err := c.reload(); err != nil {
+			log.Printf("keeping the old certificate: %v", err)
+			continue
+		}
+		log.Printf("reloaded the certificate from %s", c.opts.CertFile)
+	}
+}
+
+// redirectToHTTPS sends clients of the plain HTTP listener to the same URL
+// on httpsAddr, keeping the method and body for anything but GET and
+// HEAD.
+func redirectToHTTPS(httpsAddr string) http.Handler {
+	_, port, _ := net.SplitHostPort(httpsAddr)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		host = strings.Trim(host, "[]") // an IPv6 address without a port
+		if host == "" {
+			http.Error(w, "no host to redirect to", http.StatusBadRequest)
+			return
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+
+		status := http.StatusMovedPermanently
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			status = http.StatusPermanentRedirect
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), status)
+	})
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCert is a certificate made for a test, signed by its CA or, for a
+// CA, by itself.
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	tls  tls.Certificate
+}
+
+var serial int64
+
+func newTestCert(t *testing.T, name string, ca *testCert) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial++
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	parent, signer := tmpl, key
+	if ca == nil {
+		tmpl.IsCA, tmpl.BasicConstraintsValid = true, true
+		tmpl.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		parent, signer = ca.cert, ca.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCert{cert: cert, key: key, tls: tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}}
+}
+
+// write puts the certificate and its key in PEM files in dir.
+func (c *testCert) write(t *testing.T, dir, name string) (certFile, keyFile string) {
+	t.Helper()
+	certFile, keyFile = filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: mustMarshal(t, c.key)})
+	if err := os.WriteFile(certFile, certPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, keyPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+// serveTLS serves a handler that echoes the protocol with the reloader's
+// configuration, and returns its URL.
+func serveTLS(t *testing.T, certs *certReloader) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(r.Proto))
+		}),
+		TLSConfig: certs.TLSConfig(),
+	}
+	go srv.ServeTLS(ln, "", "")
+	t.Cleanup(func() { srv.Close() })
+	return "https://" + ln.Addr().String()
+}
+
+// client trusts ca and, if it isn't nil, presents cert, even when it isn't
+// from a CA the server asks for. Each client makes its own connections, so
+// each sees the server's current certificate.
+func client(ca *testCert, cert *testCert) *http.Client {
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	config := &tls.Config{RootCAs: roots}
+	if cert != nil {
+		config.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return &cert.tls, nil
+		}
+	}
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: config, ForceAttemptHTTP2: true}}
+}
+
+func TestTLSReload(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, "test CA", nil)
+	first := newTestCert(t, "first", ca)
+	certFile, keyFile := first.write(t, dir, "server")
+
+	certs, err := NewCertReloader(TLSOptions{CertFile: certFile, KeyFile: keyFile, ReloadEvery: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go certs.Run(ctx)
+	url := serveTLS(t, certs)
+
+	peer := func() string {
+		resp, err := client(ca, nil).Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.ProtoMajor != 2 {
+			t.Errorf("served %s, want HTTP/2", resp.Proto)
+		}
+		return resp.TLS.PeerCertificates[0].Subject.CommonName
+	}
+	if got := peer(); got != "first" {
+		t.Fatalf("serving %q, want the first certificate", got)
+	}
+
+	// a key that doesn't match the certificate is not taken up
+	second := newTestCert(t, "second", ca)
+	second.write(t, dir, "server")
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: mustMarshal(t, first.key)}), 0o600)
+	time.Sleep(50 * time.Millisecond)
+	if got := peer(); got != "first" {
+		t.Errorf("serving %q after a mismatched key, want the first certificate still", got)
+	}
+
+	second.write(t, dir, "server")
+	deadline := time.Now().Add(2 * time.Second)
+	for peer() != "second" {
+		if time.Now().After(deadline) {
+			t.Fatal("the new certificate was not picked up")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func mustMarshal(t *testing.T, key *ecdsa.PrivateKey) []byte {
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, "test CA", nil)
+	certFile, keyFile := newTestCert(t, "server", ca).write(t, dir, "server")
+	caFile, _ := ca.write(t, dir, "ca")
+
+	for _, c := range []struct {
+		auth          tls.ClientAuthType
+		withoutCert   bool // whether a client without a certificate gets in
+		strangerCerts bool // whether one signed by another CA does
+	}{
+		{tls.RequireAndVerifyClientCert, false, false},
+		{tls.VerifyClientCertIfGiven, true, false},
+	} {
+		certs, err := NewCertReloader(TLSOptions{CertFile: certFile, KeyFile: keyFile, ClientCAFile: caFile, ClientAuth: c.auth})
+		if err != nil {
+			t.Fatal(err)
+		}
+		url := serveTLS(t, certs)
+
+		get := func(cert *testCert) bool {
+			resp, err := client(ca, cert).Get(url)
+			if err != nil {
+				return false
+			}
+			resp.Body.Close()
+			return true
+		}
+		if !get(newTestCert(t, "internal caller", ca)) {
+			t.Errorf("%v: a client certificate from the CA was refused", c.auth)
+		}
+		if got := get(nil); got != c.withoutCert {
+			t.Errorf("%v: without a client certificate got in %v, want %v", c.auth, got, c.withoutCert)
+		}
+		stranger := newTestCert(t, "stranger", newTestCert(t, "other CA", nil))
+		if got := get(stranger); got != c.strangerCerts {
+			t.Errorf("%v: a certificate from another CA got in %v, want %v", c.auth, got, c.strangerCerts)
+		}
+	}
+}
+
+func TestRedirectToHTTPS(t *testing.T) {
+	for _, c := range []struct {
+		httpsAddr, method, target, host string
+		code                            int
+		location                        string
+	}{
+		{":8443", "GET", "/movies?genre=Drama", "example.com:8080", 301, "https://example.com:8443/movies?genre=Drama"},
+		{":443", "GET", "/movies", "example.com", 301, "https://example.com/movies"},
+		{":443", "POST", "/movies", "[::1]:80", 308, "https://[::1]/movies"},
+	} {
+		r := httptest.NewRequest(c.method, c.target, nil)
+		r.Host = c.host
+		w := httptest.NewRecorder()
+		redirectToHTTPS(c.httpsAddr).ServeHTTP(w, r)
+		if w.Code != c.code || w.Header().Get("Location") != c.location {
+			t.Errorf("%s %s on %s: got %d to %q, want %d to %q", c.method, c.target, c.host, w.Code, w.Header().Get("Location"), c.code, c.location)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var (
+	ErrUserNotFound = errors.New("user not found")
+	ErrUserExists   = errors.New("user already exists")
+)
+
+// UserStore holds the film club's users, with the same guarantees as a
+// MovieStore: safe for concurrent use, and every User returned is a copy.
+type UserStore interface {
+	ListUsers() []User
+	GetUser(id string) (User, error)
+	// CreateUser adds u, failing with ErrUserExists if its ID is taken.
+	CreateUser(u User) error
+	// ModifyUser replaces the user with the given ID by what fn makes of
+	// it, atomically and with the same rules as MovieStore.Modify.
+	// Watchlist and history changes go through here.
+	ModifyUser(id string, fn func(User) (User, error)) (User, error)
+	DeleteUser(id string) error
+}
+
+type User struct {
+	ID        string           `json:"id"`
+	Name      string           `json:"name"`
+	Email     string           `json:"email,omitempty"`
+	CreatedAt time.Time        `json:"created_at"`
+	Watchlist []WatchlistEntry `json:"watchlist,omitempty"`
+	History   []Viewing        `json:"history,omitempty"`
+	// LastViewing is the ID of the latest entry added to History.
+	LastViewing int `json:"last_viewing,omitempty"`
+}
+
+// WatchlistEntry is a movie a user wants to see, in the order they keep
+// their watchlist.
+type WatchlistEntry struct {
+	MovieID string    `json:"movie_id"`
+	AddedAt time.Time `json:"added_at"`
+}
+
+// Viewing is one time a user watched a movie, with their rating from 1 to
+// 10, or 0 if they didn't rate it.
+type Viewing struct {
+	ID        int       `json:"id"`
+	MovieID   string    `json:"movie_id"`
+	WatchedAt time.Time `json:"watched_at"`
+	Rating    int       `json:"rating,omitempty"`
+}
+
+// profile is what the users routes show of a User; the watchlist and the
+// history have routes of their own.
+type profile struct {
+	ID             string    `json:"id"`
+	Name           string    `json:"name"`
+	Email          string    `json:"email,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+	WatchlistCount int       `json:"watchlist_count"`
+	HistoryCount   int       `json:"history_count"`
+}
+
+func (u User) profile() profile {
+	return profile{
+		ID:             u.ID,
+		Name:           u.Name,
+		Email:          u.Email,
+		CreatedAt:      u.CreatedAt,
+		WatchlistCount: len(u.Watchlist),
+		HistoryCount:   len(u.History),
+	}
+}
+
+func (u User) clone() User {
+	u.Watchlist = append([]WatchlistEntry(nil), u.Watchlist...)
+	u.History = append([]Viewing(nil), u.History...)
+	return u
+}
+
+var emailPattern = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`)
+
+// Validate checks the profile fields of u.
+func (u *User) Validate() error {
+	u.Name = strings.TrimSpace(u.Name)
+	switch {
+	case u.Name == "":
+		return &requestError{Field: "name", Msg: "is required"}
+	case len(u.Name) > maxName:
+		return &requestError{Field: "name", Msg: fmt.Sprintf("is longer than %d characters", maxName)}
+	}
+	u.Email = strings.TrimSpace(u.Email)
+	if u.Email != "" && !emailPattern.MatchString(u.Email) {
+		return &requestError{Field: "email", Msg: "is not an email address"}
+	}
+	return nil
+}
+
+// onWatchlist returns the position of movieID on u's watchlist, or -1.
+func (u *User) onWatchlist(movieID string) int {
+	for i, e := range u.Watchlist {
+		if e.MovieID == movieID {
+			return i
+		}
+	}
+	return -1
+}
+
+// maxSkew is how far in the future a viewing may be dated, for clocks
+// that are a little ahead.
+const maxSkew = 5 * time.Minute
+
+// Validate checks a viewing a user is adding to their history.
+func (v *Viewing) Validate(now time.Time) error {
+	if v.MovieID == "" {
+		return &requestError{Field: "movie_id", Msg: "is required"}
+	}
+	if v.Rating < 0 || v.Rating > 10 {
+		return &requestError{Field: "rating", Msg: "must be between 1 and 10, or left out"}
+	}
+	if v.WatchedAt.After(now.Add(maxSkew)) {
+		return &requestError{Field: "watched_at", Msg: "is in the future"}
+	}
+	return nil
+}
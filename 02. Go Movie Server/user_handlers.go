@@ -0,0 +1,347 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func (s *server) getUsers(w http.ResponseWriter, r *http.Request) {
+	users := s.users.ListUsers()
+	list := make([]profile, 0, len(users))
+	for _, u := range users {
+		list = append(list, u.profile())
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (s *server) getUser(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	u, err := s.users.GetUser(id)
+	if err != nil {
+		writeStoreError(w, err, id)
+		return
+	}
+	writeJSON(w, http.StatusOK, u.profile())
+}
+
+// userInput is what clients send to create or change a user.
+type userInput struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+func (s *server) createUser(w http.ResponseWriter, r *http.Request) {
+	var in userInput
+	if err := decodeJSON(r, &in); err != nil {
+		writeRequestError(w, err)
+		return
+	}
+	u := User{ID: in.ID, Name: in.Name, Email: in.Email, CreatedAt: time.Now().UTC()}
+	if err := u.Validate(); err != nil {
+		writeRequestError(w, err)
+		return
+	}
+
+	// as with movies, a client supplied ID must be free and a generated one
+	// is skipped if a client took it
+	generated := u.ID == ""
+	for {
+		if generated {
+			u.ID = s.userIDs.NewID()
+		}
+		err := s.users.CreateUser(u)
+		if err == nil {
+			break
+		}
+		if err == ErrUserExists && generated {
+			continue
+		}
+		writeStoreError(w, err, u.ID)
+		return
+	}
+
+	w.Header().Set("Location", "/users/"+url.PathEscape(u.ID))
+	writeJSON(w, http.StatusCreated, u.profile())
+}
+
+// updateUser replaces a user's name and email; the watchlist and history
+// are left alone.
+func (s *server) updateUser(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	var in userInput
+	if err := decodeJSON(r, &in); err != nil {
+		writeRequestError(w, err)
+		return
+	}
+	if in.ID != "" && in.ID != id {
+		writeRequestError(w, &requestError{Field: "id", Msg: "does not match the user in the URL"})
+		return
+	}
+
+	u, err := s.users.ModifyUser(id, func(u User) (User, error) {
+		u.Name, u.Email = in.Name, in.Email
+		return u, u.Validate()
+	})
+	if err != nil {
+		writeStoreError(w, err, id)
+		return
+	}
+	writeJSON(w, http.StatusOK, u.profile())
+}
+
+func (s *server) deleteUser(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	u, err := s.users.GetUser(id)
+	if err != nil {
+		writeStoreError(w, err, id)
+		return
+	}
+	if err := s.users.DeleteUser(id); err != nil {
+		writeStoreError(w, err, id)
+		return
+	}
+	s.touch(rated(u)...)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// watchlistItem is a watchlist entry as the routes show it, with the movie
+// it is for, or none if the movie has been deleted since.
+type watchlistItem struct {
+	WatchlistEntry
+	Movie *Movie `json:"movie"`
+}
+
+func (s *server) watchlist(u User) []watchlistItem {
+	list := make([]watchlistItem, 0, len(u.Watchlist))
+	for _, e := range u.Watchlist {
+		list = append(list, watchlistItem{WatchlistEntry: e, Movie: s.movie(e.MovieID)})
+	}
+	return list
+}
+
+// movie returns the movie with the given ID, or nil if there is none.
+func (s *server) movie(id string) *Movie {
+	m, err := s.store.Get(id)
+	if err != nil {
+		return nil
+	}
+	return &m
+}
+
+// requireMovie checks that a movie a request refers to exists. It is
+// checked before changing the user, since the change can't look into the
+// store, so a movie deleted at the same time can still slip through; the
+// watchlist and history show such movies as null.
+func (s *server) requireMovie(id string) error {
+	if id == "" {
+		return &requestError{Field: "movie_id", Msg: "is required"}
+	}
+	if _, err := s.store.Get(id); err != nil {
+		return &statusError{Status: http.StatusUnprocessableEntity, Field: "movie_id", Msg: "no movie " + id}
+	}
+	return nil
+}
+
+func (s *server) getWatchlist(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	u, err := s.users.GetUser(id)
+	if err != nil {
+		writeStoreError(w, err, id)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.watchlist(u))
+}
+
+// addToWatchlist puts a movie on the watchlist, at the end or at position
+// (counting from 0) if one is given.
+func (s *server) addToWatchlist(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	var in struct {
+		MovieID  string `json:"movie_id"`
+		Position *int   `json:"position"`
+	}
+	if err := decodeJSON(r, &in); err != nil {
+		writeRequestError(w, err)
+		return
+	}
+	if err := s.requireMovie(in.MovieID); err != nil {
+		writeStoreError(w, err, id)
+		return
+	}
+
+	u, err := s.users.ModifyUser(id, func(u User) (User, error) {
+		if u.onWatchlist(in.MovieID) >= 0 {
+			return u, &statusError{Status: http.StatusConflict, Field: "movie_id", Msg: "movie " + in.MovieID + " is on the watchlist already"}
+		}
+		at := len(u.Watchlist)
+		if in.Position != nil {
+			if *in.Position < 0 || *in.Position > at {
+				return u, &requestError{Field: "position", Msg: "must be between 0 and " + strconv.Itoa(at)}
+			}
+			at = *in.Position
+		}
+		e := WatchlistEntry{MovieID: in.MovieID, AddedAt: time.Now().UTC()}
+		u.Watchlist = append(u.Watchlist[:at], append([]WatchlistEntry{e}, u.Watchlist[at:]...)...)
+		return u, nil
+	})
+	if err != nil {
+		writeStoreError(w, err, id)
+		return
+	}
+	writeJSON(w, http.StatusCreated, s.watchlist(u))
+}
+
+// reorderWatchlist puts the watchlist in the order of the movie IDs given,
+// which must be the movies on it, each once.
+func (s *server) reorderWatchlist(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	var in struct {
+		MovieIDs []string `json:"movie_ids"`
+	}
+	if err := decodeJSON(r, &in); err != nil {
+		writeRequestError(w, err)
+		return
+	}
+
+	u, err := s.users.ModifyUser(id, func(u User) (User, error) {
+		if len(in.MovieIDs) != len(u.Watchlist) {
+			return u, &requestError{Field: "movie_ids", Msg: "must list every movie on the watchlist once"}
+		}
+		entries := map[string]WatchlistEntry{}
+		for _, e := range u.Watchlist {
+			entries[e.MovieID] = e
+		}
+		order := make([]WatchlistEntry, 0, len(u.Watchlist))
+		for i, movieID := range in.MovieIDs {
+			e, ok := entries[movieID]
+			if !ok {
+				return u, &requestError{Field: "movie_ids[" + strconv.Itoa(i) + "]", Msg: "movie " + movieID + " is not on the watchlist, or listed twice"}
+			}
+			order = append(order, e)
+			delete(entries, movieID)
+		}
+		u.Watchlist = order
+		return u, nil
+	})
+	if err != nil {
+		writeStoreError(w, err, id)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.watchlist(u))
+}
+
+func (s *server) removeFromWatchlist(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	_, err := s.users.ModifyUser(params["id"], func(u User) (User, error) {
+		at := u.onWatchlist(params["movieId"])
+		if at < 0 {
+			return u, &statusError{Status: http.StatusNotFound, Msg: "movie " + params["movieId"] + " is not on the watchlist"}
+		}
+		u.Watchlist = append(u.Watchlist[:at], u.Watchlist[at+1:]...)
+		return u, nil
+	})
+	if err != nil {
+		writeStoreError(w, err, params["id"])
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// historyItem is a viewing as the routes show it, with its movie like a
+// watchlistItem.
+type historyItem struct {
+	Viewing
+	Movie *Movie `json:"movie"`
+}
+
+// getHistory lists what a user watched, latest first. ?movie_id= limits
+// it to one movie.
+func (s *server) getHistory(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	u, err := s.users.GetUser(id)
+	if err != nil {
+		writeStoreError(w, err, id)
+		return
+	}
+
+	only := r.URL.Query().Get("movie_id")
+	list := []historyItem{}
+	for _, v := range u.History {
+		if only == "" || v.MovieID == only {
+			list = append(list, historyItem{Viewing: v, Movie: s.movie(v.MovieID)})
+		}
+	}
+	sort.SliceStable(list, func(i, j int) bool { return list[i].WatchedAt.After(list[j].WatchedAt) })
+	writeJSON(w, http.StatusOK, list)
+}
+
+// addViewing records that a user watched a movie, now unless watched_at
+// says when, and takes the movie off their watchlist.
+func (s *server) addViewing(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	var v Viewing
+	if err := decodeJSON(r, &v); err != nil {
+		writeRequestError(w, err)
+		return
+	}
+	now := time.Now().UTC()
+	if v.WatchedAt.IsZero() {
+		v.WatchedAt = now
+	}
+	if err := v.Validate(now); err != nil {
+		writeRequestError(w, err)
+		return
+	}
+	if err := s.requireMovie(v.MovieID); err != nil {
+		writeStoreError(w, err, id)
+		return
+	}
+
+	u, err := s.users.ModifyUser(id, func(u User) (User, error) {
+		u.LastViewing++
+		v.ID = u.LastViewing
+		u.History = append(u.History, v)
+		if at := u.onWatchlist(v.MovieID); at >= 0 {
+			u.Watchlist = append(u.Watchlist[:at], u.Watchlist[at+1:]...)
+		}
+		return u, nil
+	})
+	if err != nil {
+		writeStoreError(w, err, id)
+		return
+	}
+	// a new rating moves the user's mean, and so all their ratings
+	if v.Rating != 0 {
+		s.touch(rated(u)...)
+	}
+
+	w.Header().Set("Location", "/users/"+url.PathEscape(id)+"/history/"+strconv.Itoa(v.ID))
+	writeJSON(w, http.StatusCreated, historyItem{Viewing: v, Movie: s.movie(v.MovieID)})
+}
+
+func (s *server) deleteViewing(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	var removed []string
+	_, err := s.users.ModifyUser(params["id"], func(u User) (User, error) {
+		for i, v := range u.History {
+			if strconv.Itoa(v.ID) == params["viewingId"] {
+				removed = rated(u)
+				u.History = append(u.History[:i], u.History[i+1:]...)
+				return u, nil
+			}
+		}
+		return u, &statusError{Status: http.StatusNotFound, Msg: "no viewing " + params["viewingId"] + " in the history"}
+	})
+	if err != nil {
+		writeStoreError(w, err, params["id"])
+		return
+	}
+	s.touch(removed...)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestUsers(t *testing.T) {
+	store := NewMemoryStore()
+	s := &server{store: store, ids: &sequence{}, users: store, userIDs: &sequence{}}
+	for _, id := range []string{"heat", "thief", "ali"} {
+		store.Create(Movie{ID: id, Title: id, Directors: []Director{{Lastname: "Mann"}}})
+	}
+	h := s.routes()
+
+	// watchlist and history give the movie IDs on the watchlist, in order,
+	// and the history as viewing ID, movie and rating, latest first
+	watchlist := func() string {
+		var list []watchlistItem
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/users/1/watchlist", nil))
+		json.Unmarshal(w.Body.Bytes(), &list)
+		var ids []string
+		for _, e := range list {
+			if e.Movie == nil || e.Movie.ID != e.MovieID {
+				t.Errorf("watchlist entry %s has movie %+v", e.MovieID, e.Movie)
+			}
+			ids = append(ids, e.MovieID)
+		}
+		return strings.Join(ids, " ")
+	}
+	history := func() string {
+		var list []historyItem
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/users/1/history", nil))
+		json.Unmarshal(w.Body.Bytes(), &list)
+		var views []string
+		for _, v := range list {
+			data, _ := json.Marshal([]interface{}{v.ID, v.MovieID, v.Rating})
+			views = append(views, string(data))
+		}
+		return strings.Join(views, " ")
+	}
+
+	for _, c := range []struct {
+		method, target, body string
+		code                 int
+		location             string
+		watchlist, history   string // after the request
+	}{
+		{"POST", "/users", `{"name": " Ann ", "email": "ann@example.com"}`, http.StatusCreated, "/users/1", "", ""},
+		{"POST", "/users", `{"id": "1", "name": "Bob"}`, http.StatusConflict, "", "", ""},
+		{"POST", "/users", `{"name": ""}`, http.StatusBadRequest, "", "", ""},
+		{"POST", "/users", `{"name": "Bob", "email": "bob"}`, http.StatusBadRequest, "", "", ""},
+		{"PUT", "/users/1", `{"id": "2", "name": "Ann"}`, http.StatusBadRequest, "", "", ""},
+		{"GET", "/users/9", "", http.StatusNotFound, "", "", ""},
+
+		{"POST", "/users/1/watchlist", `{"movie_id": "heat"}`, http.StatusCreated, "", "heat", ""},
+		{"POST", "/users/1/watchlist", `{"movie_id": "thief"}`, http.StatusCreated, "", "heat thief", ""},
+		{"POST", "/users/1/watchlist", `{"movie_id": "ali", "position": 0}`, http.StatusCreated, "", "ali heat thief", ""},
+		{"POST", "/users/1/watchlist", `{"movie_id": "heat"}`, http.StatusConflict, "", "ali heat thief", ""},
+		{"POST", "/users/1/watchlist", `{"movie_id": "gone"}`, http.StatusUnprocessableEntity, "", "ali heat thief", ""},
+		{"POST", "/users/1/watchlist", `{}`, http.StatusBadRequest, "", "ali heat thief", ""},
+		{"POST", "/users/9/watchlist", `{"movie_id": "heat"}`, http.StatusNotFound, "", "ali heat thief", ""},
+		{"PUT", "/users/1/watchlist", `{"movie_ids": ["thief", "ali", "heat"]}`, http.StatusOK, "", "thief ali heat", ""},
+		{"PUT", "/users/1/watchlist", `{"movie_ids": ["thief", "ali"]}`, http.StatusBadRequest, "", "thief ali heat", ""},
+		{"PUT", "/users/1/watchlist", `{"movie_ids": ["thief", "ali", "ali"]}`, http.StatusBadRequest, "", "thief ali heat", ""},
+		{"DELETE", "/users/1/watchlist/ali", "", http.StatusNoContent, "", "thief heat", ""},
+		{"DELETE", "/users/1/watchlist/ali", "", http.StatusNotFound, "", "thief heat", ""},
+
+		// watching a movie takes it off the watchlist
+		{"POST", "/users/1/history", `{"movie_id": "heat", "watched_at": "2024-01-01T20:00:00Z", "rating": 9}`, http.StatusCreated, "/users/1/history/1",
+			"thief", `[1,"heat",9]`},
+		{"POST", "/users/1/history", `{"movie_id": "ali", "watched_at": "2024-02-01T20:00:00Z"}`, http.StatusCreated, "/users/1/history/2",
+			"thief", `[2,"ali",0] [1,"heat",9]`},
+		{"POST", "/users/1/history", `{"movie_id": "heat", "watched_at": "2023-12-01T20:00:00Z", "rating": 8}`, http.StatusCreated, "/users/1/history/3",
+			"thief", `[2,"ali",0] [1,"heat",9] [3,"heat",8]`},
+		{"POST", "/users/1/history", `{"movie_id": "heat", "rating": 11}`, http.StatusBadRequest, "",
+			"thief", `[2,"ali",0] [1,"heat",9] [3,"heat",8]`},
+		{"POST", "/users/1/history", `{"movie_id": "heat", "watched_at": "2999-01-01T00:00:00Z"}`, http.StatusBadRequest, "",
+			"thief", `[2,"ali",0] [1,"heat",9] [3,"heat",8]`},
+		{"DELETE", "/users/1/history/1", "", http.StatusNoContent, "",
+			"thief", `[2,"ali",0] [3,"heat",8]`},
+		{"DELETE", "/users/1/history/1", "", http.StatusNotFound, "",
+			"thief", `[2,"ali",0] [3,"heat",8]`},
+		// viewing IDs aren't reused
+		{"POST", "/users/1/history", `{"movie_id": "thief", "watched_at": "2024-03-01T20:00:00Z"}`, http.StatusCreated, "/users/1/history/4",
+			"", `[4,"thief",0] [2,"ali",0] [3,"heat",8]`},
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(c.method, c.target, strings.NewReader(c.body)))
+		name := c.method + " " + c.target + " " + c.body
+		if w.Code != c.code {
+			t.Errorf("%s: got %d with %s, want %d", name, w.Code, w.Body, c.code)
+		}
+		if got := w.Header().Get("Location"); got != c.location {
+			t.Errorf("%s: Location %q, want %q", name, got, c.location)
+		}
+		if got := watchlist(); got != c.watchlist {
+			t.Errorf("%s: watchlist %q, want %q", name, got, c.watchlist)
+		}
+		if got := history(); got != c.history {
+			t.Errorf("%s: history %s, want %s", name, got, c.history)
+		}
+	}
+
+	// the profile leaves the lists out, and a change of it keeps them
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("PUT", "/users/1", strings.NewReader(`{"name": "Ann B."}`)))
+	var p map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &p)
+	if w.Code != http.StatusOK || p["name"] != "Ann B." || p["email"] != nil || p["watchlist"] != nil {
+		t.Errorf("PUT /users/1: got %d with %s", w.Code, w.Body)
+	}
+	if u, _ := store.GetUser("1"); len(u.History) != 3 {
+		t.Errorf("changing the profile left %d viewings, want 3", len(u.History))
+	}
+
+	// a deleted movie stays in the history, without the movie
+	store.Delete("thief")
+	var list []historyItem
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/users/1/history?movie_id=thief", nil))
+	json.Unmarshal(w.Body.Bytes(), &list)
+	if len(list) != 1 || list[0].Movie != nil {
+		t.Errorf("history of a deleted movie: %s", w.Body)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("DELETE", "/users/1", nil))
+	if _, err := store.GetUser("1"); w.Code != http.StatusNoContent || err != ErrUserNotFound {
+		t.Errorf("DELETE /users/1: got %d, and the user is still there: %v", w.Code, err)
+	}
+	if n := len(store.ListUsers()); n != 0 {
+		t.Errorf("%d users left", n)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// ErrNoVersion means a movie has no version of the number asked for, or no
+// longer keeps it.
+var ErrNoVersion = errors.New("no such version")
+
+// maxVersions is how many versions are kept per movie; older ones are
+// dropped, though the numbers of the rest don't change.
+const maxVersions = 100
+
+// Version is a movie as a change left it. A movie's versions are numbered
+// from 1, its creation, up by one for each change that made a difference.
+type Version struct {
+	N    int       `json:"version" xml:"number,attr"`
+	Time time.Time `json:"time" xml:"time,attr"` // zero for movies from before history was kept
+	// Op is "create", "update" or "revert"; a revert brings back the
+	// movie of version RevertedTo, as a new version.
+	Op         string `json:"op" xml:"op,attr"`
+	RevertedTo int    `json:"reverted_to,omitempty" xml:"reverted-to,attr,omitempty"`
+	Movie      Movie  `json:"movie" xml:"movie"`
+}
+
+// at returns the version that was current at t, if the movie existed then
+// and that version is still kept.
+func at(versions []Version, t time.Time) (Version, bool) {
+	i := sort.Search(len(versions), func(i int) bool { return versions[i].Time.After(t) })
+	if i == 0 {
+		return Version{}, false
+	}
+	return versions[i-1], true
+}
+
+// find returns version n.
+func find(versions []Version, n int) (Version, bool) {
+	if len(versions) == 0 {
+		return Version{}, false
+	}
+	// versions are numbered without gaps, from the oldest kept one
+	i := n - versions[0].N
+	if i < 0 || i >= len(versions) {
+		return Version{}, false
+	}
+	return versions[i], true
+}
+
+// Change is a field that differs between two versions of a movie. Field
+// is a path into the movie's JSON, such as "year" or "cast[1].role"; From
+// or To is nil where the field is missing, like a cast member added.
+type Change struct {
+	Field string      `json:"field"`
+	From  interface{} `json:"from"`
+	To    interface{} `json:"to"`
+}
+
+// Diff returns the fields that differ from a to b, in the order of the
+// movie's fields.
+func Diff(a, b Movie) ([]Change, error) {
+	from, err := jsonValue(a)
+	if err != nil {
+		return nil, err
+	}
+	to, err := jsonValue(b)
+	if err != nil {
+		return nil, err
+	}
+	var changes []Change
+	fromFields, toFields := from.(map[string]interface{}), to.(map[string]interface{})
+	for _, field := range movieFieldNames {
+		changes = diffValue(changes, field, fromFields[field], toFields[field])
+	}
+	return changes, nil
+}
+
+// jsonValue returns m as encoding/json decodes it into an interface{}.
+func jsonValue(m Movie) (interface{}, error) {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	var v interface{}
+	return v, json.Unmarshal(data, &v)
+}
+
+// diffValue appends the changes from a to b at path: objects and lists are
+// compared member by member, anything else whole.
+func diffValue(changes []Change, path string, a, b interface{}) []Change {
+	switch a := a.(type) {
+	case map[string]interface{}:
+		if b, ok := b.(map[string]interface{}); ok {
+			keys := map[string]bool{}
+			for k := range a {
+				keys[k] = true
+			}
+			for k := range b {
+				keys[k] = true
+			}
+			sorted := make([]string, 0, len(keys))
+			for k := range keys {
+				sorted = append(sorted, k)
+			}
+			sort.Strings(sorted)
+			for _, k := range sorted {
+				changes = diffValue(changes, path+"."+k, a[k], b[k])
+			}
+			return changes
+		}
+	case []interface{}:
+		if b, ok := b.([]interface{}); ok {
+			for i := 0; i < max(len(a), len(b)); i++ {
+				var x, y interface{}
+				if i < len(a) {
+					x = a[i]
+				}
+				if i < len(b) {
+					y = b[i]
+				}
+				changes = diffValue(changes, fmt.Sprintf("%s[%d]", path, i), x, y)
+			}
+			return changes
+		}
+	}
+	if reflect.DeepEqual(a, b) {
+		return changes
+	}
+	return append(changes, Change{Field: path, From: a, To: b})
+}
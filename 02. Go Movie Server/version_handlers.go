@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// versionSummary is a version in the list of a movie's versions, with the
+// fields it changed rather than the whole movie.
+type versionSummary struct {
+	N          int       `json:"version" xml:"number,attr"`
+	Time       time.Time `json:"time" xml:"time,attr"`
+	Op         string    `json:"op" xml:"op,attr"`
+	RevertedTo int       `json:"reverted_to,omitempty" xml:"reverted-to,attr,omitempty"`
+	// Changed are the fields changed since the version before, if that is
+	// still kept.
+	Changed []string `json:"changed,omitempty" xml:"changed>field"`
+}
+
+// versionDiff is what changed from one version of a movie to another.
+type versionDiff struct {
+	From    int      `json:"from"`
+	To      int      `json:"to"`
+	Changes []Change `json:"changes"`
+}
+
+func (s *server) getVersions(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	versions, err := s.store.Versions(id)
+	if err != nil {
+		writeStoreError(w, err, id)
+		return
+	}
+
+	list := make([]versionSummary, len(versions))
+	for i, v := range versions {
+		list[i] = versionSummary{N: v.N, Time: v.Time, Op: v.Op, RevertedTo: v.RevertedTo}
+		if i == 0 {
+			continue
+		}
+		changes, err := Diff(versions[i-1].Movie, v.Movie)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		for _, c := range changes {
+			list[i].Changed = append(list[i].Changed, c.Field)
+		}
+	}
+	respond(w, http.StatusOK, list)
+}
+
+func (s *server) getVersion(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	id := params["id"]
+	versions, err := s.store.Versions(id)
+	if err != nil {
+		writeStoreError(w, err, id)
+		return
+	}
+	v, err := version(versions, id, params["n"], "n")
+	if err != nil {
+		writeRequestError(w, err)
+		return
+	}
+	respond(w, http.StatusOK, v)
+}
+
+// diffVersions answers with the field-level diff from version ?from= to
+// version ?to=. to defaults to the latest version, and from to the one
+// before to, so that a bare request shows what the last change did; before
+// version 1 there is version 0, the movie with nothing but its ID, so that
+// the diff of a new movie shows what it was created with.
+func (s *server) diffVersions(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	versions, err := s.store.Versions(id)
+	if err != nil {
+		writeStoreError(w, err, id)
+		return
+	}
+
+	query := r.URL.Query()
+	to := versions[len(versions)-1]
+	if n := query.Get("to"); n != "" {
+		if to, err = version(versions, id, n, "to"); err != nil {
+			writeRequestError(w, err)
+			return
+		}
+	}
+	var from Version
+	switch n := query.Get("from"); {
+	case n != "":
+		from, err = version(versions, id, n, "from")
+	case to.N == 1:
+		from = Version{Movie: Movie{ID: id}}
+	default:
+		from, err = version(versions, id, strconv.Itoa(to.N-1), "from")
+	}
+	if err != nil {
+		writeRequestError(w, err)
+		return
+	}
+
+	changes, err := Diff(from.Movie, to.Movie)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if changes == nil {
+		changes = []Change{}
+	}
+	respond(w, http.StatusOK, versionDiff{From: from.N, To: to.N, Changes: changes})
+}
+
+// revertMovie makes a movie what it was in an earlier version. The revert
+// is a version of its own, so it can be undone by reverting to the
+// version before it, and redone the same way.
+func (s *server) revertMovie(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	id := params["id"]
+	n, err := strconv.Atoi(params["n"])
+	if err != nil {
+		writeRequestError(w, &requestError{Field: "n", Msg: "must be a version number"})
+		return
+	}
+
+	m, err := s.store.Revert(id, n)
+	if err == ErrNoVersion {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("movie %s has no version %d", id, n))
+		return
+	}
+	if err != nil {
+		writeStoreError(w, err, id)
+		return
+	}
+	s.touch(id)
+
+	respond(w, http.StatusOK, m)
+}
+
+// version returns the version numbered n, as given in param, or an error
+// for the client: 400 for what isn't a number, 404 for a version that
+// doesn't exist or is no longer kept.
+func version(versions []Version, id, n, param string) (Version, error) {
+	number, err := strconv.Atoi(n)
+	if err != nil {
+		return Version{}, &requestError{Field: param, Msg: "must be a version number"}
+	}
+	v, ok := find(versions, number)
+	if !ok {
+		return Version{}, &statusError{Status: http.StatusNotFound, Msg: fmt.Sprintf("movie %s has no version %d", id, number)}
+	}
+	return v, nil
+}
+
+// movieAt answers GET /movies/{id}?at= with the movie as it was at that
+// time.
+func (s *server) movieAt(w http.ResponseWriter, id, when string) {
+	t, err := time.Parse(time.RFC3339, when)
+	if err != nil {
+		writeRequestError(w, &requestError{Field: "at", Msg: "must be a time like 2006-01-02T15:04:05Z"})
+		return
+	}
+	versions, err := s.store.Versions(id)
+	if err != nil {
+		writeStoreError(w, err, id)
+		return
+	}
+	v, ok := at(versions, t)
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("no version of movie %s from %s is kept", id, when))
+		return
+	}
+	respond(w, http.StatusOK, v.Movie)
+}
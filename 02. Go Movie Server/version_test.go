@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDiff(t *testing.T) {
+	a := Movie{ID: "1", Title: "Heat", Year: 1995, Cast: []CastMember{{Name: "Al Pacino", Role: "Hanna"}}}
+	b := Movie{ID: "1", Title: "Heat", Year: 1995, Rating: "R",
+		Cast: []CastMember{{Name: "Al Pacino", Role: "Vincent Hanna"}, {Name: "Robert De Niro"}}}
+	changes, err := Diff(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Change{
+		{Field: "cast[0].role", From: "Hanna", To: "Vincent Hanna"},
+		{Field: "cast[1]", From: nil, To: map[string]interface{}{"name": "Robert De Niro"}},
+		{Field: "rating", From: nil, To: "R"},
+	}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("got %+v, want %+v", changes, want)
+	}
+}
+
+func TestVersions(t *testing.T) {
+	s := &server{store: NewMemoryStore(), ids: &sequence{}}
+	h := s.routes()
+	do := func(method, target, accept, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		r.Header.Set("Accept", accept)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	do("POST", "/movies", "", `{"title": "Heat", "directors": [{"lastname": "Mann"}]}`)
+	// before the first version there is only the ID
+	var diff versionDiff
+	w := do("GET", "/movies/1/diff", "", "")
+	json.Unmarshal(w.Body.Bytes(), &diff)
+	if w.Code != http.StatusOK || diff.From != 0 || diff.To != 1 || len(diff.Changes) != 2 ||
+		diff.Changes[0].Field != "title" || diff.Changes[1].Field != "directors" {
+		t.Errorf("diff of a new movie: got %d %s", w.Code, w.Body)
+	}
+	before := time.Now().UTC().Format(time.RFC3339Nano)
+	time.Sleep(time.Millisecond)
+	do("PATCH", "/movies/1", "", `{"year": 1995}`)
+	do("PATCH", "/movies/1", "", `{"year": 1995}`) // changes nothing, so no version
+	do("PATCH", "/movies/1", "", `{"title": "Heat (1995)", "rating": "R"}`)
+
+	w = do("POST", "/movies/1/revert/2", "", "")
+	var m Movie
+	json.Unmarshal(w.Body.Bytes(), &m)
+	if w.Code != http.StatusOK || m.Title != "Heat" || m.Year != 1995 {
+		t.Errorf("revert to 2: got %d %s", w.Code, w.Body)
+	}
+
+	var list []versionSummary
+	json.Unmarshal(do("GET", "/movies/1/versions", "", "").Body.Bytes(), &list)
+	var got []string
+	for _, v := range list {
+		got = append(got, v.Op+" "+strings.Join(v.Changed, ","))
+	}
+	want := []string{"create ", "update year", "update title,rating", "revert title,rating"}
+	if !reflect.DeepEqual(got, want) || list[3].RevertedTo != 2 {
+		t.Errorf("versions %q, want %q", got, want)
+	}
+
+	w = do("GET", "/movies/1/diff?from=1", "text/csv", "")
+	if want := "field,from,to\nyear,,1995\n"; w.Body.String() != want {
+		t.Errorf("diff from 1 to the latest: got %q, want %q", w.Body, want)
+	}
+	w = do("GET", "/movies/1/versions/3", "application/xml", "")
+	if !strings.Contains(w.Body.String(), `<version number="3"`) || !strings.Contains(w.Body.String(), "<title>Heat (1995)</title>") {
+		t.Errorf("version 3 as XML: %s", w.Body)
+	}
+	json.Unmarshal(do("GET", "/movies/1?at="+before, "", "").Body.Bytes(), &m)
+	if m.Year != 0 {
+		t.Errorf("the movie as it was before the first change has year %d", m.Year)
+	}
+
+	for _, c := range []struct {
+		method, target string
+		code           int
+	}{
+		{"GET", "/movies/1/versions/9", http.StatusNotFound},
+		{"POST", "/movies/1/revert/9", http.StatusNotFound},
+		{"GET", "/movies/1/diff?from=x", http.StatusBadRequest},
+		{"GET", "/movies/1?at=yesterday", http.StatusBadRequest},
+		{"GET", "/movies/1?at=2000-01-01T00:00:00Z", http.StatusNotFound},
+		{"GET", "/movies/2/versions", http.StatusNotFound},
+	} {
+		if w := do(c.method, c.target, "", ""); w.Code != c.code {
+			t.Errorf("%s %s: got %d, want %d", c.method, c.target, w.Code, c.code)
+		}
+	}
+}
+
+func TestFileStoreVersions(t *testing.T) {
+	dir := t.TempDir()
+	open := func(compactEvery int) *fileStore {
+		fs, err := OpenFileStore(dir, FileStoreOptions{Sync: SyncNever, CompactEvery: compactEvery})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fs
+	}
+
+	fs := open(0)
+	fs.Create(Movie{ID: "1", Title: "Heat"})
+	fs.Update(Movie{ID: "1", Title: "Heat", Year: 1995})
+	fs.Revert("1", 1)
+	want, _ := fs.Versions("1")
+	fs.Close()
+
+	// once from the log, then from a snapshot
+	for _, compactEvery := range []int{1, 0} {
+		fs = open(compactEvery)
+		got, err := fs.Versions("1")
+		if err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("reopened with CompactEvery %d: got %+v, want %+v", compactEvery, got, want)
+		}
+		// with CompactEvery 1, this writes the snapshot the next round reads
+		fs.Create(Movie{ID: "2", Title: "Thief"})
+		fs.Close()
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"go-bookstore/pkg/models"
+)
+
+func runBooks(cmd string, args []string) error {
+	fs := flag.NewFlagSet("books "+cmd, flag.ContinueOnError)
+	name := fs.String("name", "", "book name")
+	author := fs.String("author", "", "book author")
+	publication := fs.String("publication", "", "book publication")
+	format := fs.String("format", "", "file format, csv or json; guessed from the file name if empty")
+	out := fs.String("out", "-", "file to write, - for standard output")
+	pos, err := parseArgs(fs, args)
+	if err != nil {
+		return errUsage
+	}
+
+	c, err := store()
+	if err != nil {
+		return err
+	}
+
+	switch cmd {
+	case "list":
+		books, err := c.List()
+		if err != nil {
+			return err
+		}
+		return writeBooks(stdout, *output, books)
+
+	case "get", "delete":
+		id, err := idArg(pos)
+		if err != nil {
+			return err
+		}
+		var b *models.Book
+		if cmd == "get" {
+			b, err = c.Get(id)
+		} else {
+			b, err = c.Delete(id)
+		}
+		if err != nil {
+			return err
+		}
+		return writeBooks(stdout, *output, []models.Book{*b})
+
+	case "create":
+		if *name == "" {
+			return fmt.Errorf("books create: -name is required")
+		}
+		b, err := c.Create(&models.Book{Name: *name, Author: *author, Publication: *publication})
+		if err != nil {
+			return err
+		}
+		return writeBooks(stdout, *output, []models.Book{*b})
+
+	case "update":
+		id, err := idArg(pos)
+		if err != nil {
+			return err
+		}
+		b, err := c.Update(id, &models.Book{Name: *name, Author: *author, Publication: *publication})
+		if err != nil {
+			return err
+		}
+		return writeBooks(stdout, *output, []models.Book{*b})
+
+	case "import":
+		if len(pos) != 1 {
+			return errUsage
+		}
+		return importBooks(c, pos[0], *format)
+
+	case "export":
+		books, err := c.List()
+		if err != nil {
+			return err
+		}
+		f := fileFormat(*out, *format)
+		if *out == "-" {
+			return writeBooks(stdout, f, books)
+		}
+		w, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		if err := writeBooks(w, f, books); err != nil {
+			w.Close()
+			return err
+		}
+		return w.Close()
+	}
+	return errUsage
+}
+
+// parseArgs parses fs from args, allowing flags after positional arguments
+// as in "update 3 -name X", and returns the positional arguments.
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	var pos []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			return pos, nil
+		}
+		pos = append(pos, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+func idArg(pos []string) (int64, error) {
+	if len(pos) != 1 {
+		return 0, errUsage
+	}
+	id, err := strconv.ParseInt(pos[0], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid book id %q", pos[0])
+	}
+	return id, nil
+}
+
+// importBooks creates every book in the file, stopping at the first error.
+// Ids and timestamps in the file are ignored, so an export of one
+// catalogue can be imported into another.
+func importBooks(c catalogue, path, format string) error {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	books, err := readBooks(r, fileFormat(path, format))
+	if err != nil {
+		return err
+	}
+	for i, b := range books {
+		if _, err := c.Create(&models.Book{Name: b.Name, Author: b.Author, Publication: b.Publication, Stock: b.Stock}); err != nil {
+			return fmt.Errorf("book %d (%q): %w; %d imported", i+1, b.Name, err, i)
+		}
+	}
+	fmt.Fprintf(stderr, "imported %d books\n", len(books))
+	return nil
+}
+
+// fileFormat picks csv or json for path, preferring an explicit format and
+// defaulting to JSON.
+func fileFormat(path, format string) string {
+	if format != "" {
+		return format
+	}
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		return "csv"
+	}
+	if path == "-" && *output == "csv" {
+		return "csv"
+	}
+	return "json"
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"go-bookstore/pkg/models"
+)
+
+// catalogue is the set of book operations the CLI needs, implemented on the
+// database and on the REST API.
+type catalogue interface {
+	List() ([]models.Book, error)
+	Get(id int64) (*models.Book, error)
+	Create(b *models.Book) (*models.Book, error)
+	Update(id int64, b *models.Book) (*models.Book, error)
+	Delete(id int64) (*models.Book, error)
+}
+
+var errNotFound = errors.New("book not found")
+
+type dbCatalogue struct {
+	ctx context.Context
+}
+
+func (c dbCatalogue) List() ([]models.Book, error) {
+	return models.GetAllBook(c.ctx), nil
+}
+
+func (c dbCatalogue) Get(id int64) (*models.Book, error) {
+	b, tx := models.GetBookById(c.ctx, id)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	if b.ID == 0 {
+		return nil, errNotFound
+	}
+	return b, nil
+}
+
+func (c dbCatalogue) Create(b *models.Book) (*models.Book, error) {
+	return b.CreateBook(c.ctx)
+}
+
+func (c dbCatalogue) Update(id int64, b *models.Book) (*models.Book, error) {
+	updated, err := models.UpdateBook(c.ctx, id, b)
+	if err != nil {
+		return nil, err
+	}
+	if updated == nil {
+		return nil, errNotFound
+	}
+	return updated, nil
+}
+
+func (c dbCatalogue) Delete(id int64) (*models.Book, error) {
+	b, err := models.DeleteBook(c.ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if b.ID == 0 {
+		return nil, errNotFound
+	}
+	return &b, nil
+}
+
+// restCatalogue talks to the /book/ routes of a running server.
+type restCatalogue struct {
+	base   string
+	tenant string
+	token  string
+	client *http.Client
+}
+
+func newRESTCatalogue(base, tenant, token string) *restCatalogue {
+	return &restCatalogue{
+		base:   strings.TrimSuffix(base, "/"),
+		tenant: tenant,
+		token:  token,
+		client: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (c *restCatalogue) List() ([]models.Book, error) {
+	var books []models.Book
+	return books, c.do("GET", "/book/", nil, &books)
+}
+
+func (c *restCatalogue) Get(id int64) (*models.Book, error) {
+	return c.book("GET", id, nil)
+}
+
+func (c *restCatalogue) Create(b *models.Book) (*models.Book, error) {
+	var created models.Book
+	return &created, c.do("POST", "/book/", b, &created)
+}
+
+func (c *restCatalogue) Update(id int64, b *models.Book) (*models.Book, error) {
+	return c.book("PUT", id, b)
+}
+
+func (c *restCatalogue) Delete(id int64) (*models.Book, error) {
+	return c.book("DELETE", id, nil)
+}
+
+// book calls /book/{id}, which answers a missing book with an empty one.
+func (c *restCatalogue) book(method string, id int64, body interface{}) (*models.Book, error) {
+	var b models.Book
+	if err := c.do(method, fmt.Sprintf("/book/%d", id), body, &b); err != nil {
+		return nil, err
+	}
+	if b.ID == 0 {
+		return nil, errNotFound
+	}
+	return &b, nil
+}
+
+func (c *restCatalogue) do(method, path string, body, out interface{}) error {
+	var r io.Reader
+	if body != nil {
+		buf, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(buf)
+	}
+
+	req, err := http.NewRequest(method, c.base+path, r)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Tenant-ID", c.tenant)
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 1<<10))
+		return fmt.Errorf("%s %s: %s: %s", method, path, res.Status, strings.TrimSpace(string(msg)))
+	}
+	return json.NewDecoder(res.Body).Decode(out)
+}
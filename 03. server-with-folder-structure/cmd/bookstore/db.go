@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"go-bookstore/pkg/models"
+)
+
+var sampleBooks = []models.Book{
+	{Name: "The Go Programming Language", Author: "Donovan, Kernighan", Publication: "Addison-Wesley"},
+	{Name: "The C Programming Language", Author: "Kernighan & Ritchie", Publication: "Prentice Hall"},
+	{Name: "Concurrency in Go", Author: "Katherine Cox-Buday", Publication: "O'Reilly"},
+	{Name: "Designing Data-Intensive Applications", Author: "Martin Kleppmann", Publication: "O'Reilly"},
+	{Name: "Structure and Interpretation of Computer Programs", Author: "Abelson & Sussman", Publication: "MIT Press"},
+}
+
+func runDB(cmd string, args []string) error {
+	fs := flag.NewFlagSet("db "+cmd, flag.ContinueOnError)
+	out := fs.String("out", "", "backup file, bookstore-<time>.json if empty, - for standard output")
+	if pos, err := parseArgs(fs, args); err != nil || len(pos) != 0 {
+		return errUsage
+	}
+
+	switch cmd {
+	case "migrate":
+		// connect migrates the schema, as the server does on start.
+		if err := connect(); err != nil {
+			return err
+		}
+		fmt.Fprintln(stderr, "schema is up to date")
+		return nil
+
+	case "seed":
+		c, err := store()
+		if err != nil {
+			return err
+		}
+		for _, b := range sampleBooks {
+			b := b
+			if _, err := c.Create(&b); err != nil {
+				return err
+			}
+		}
+		fmt.Fprintf(stderr, "added %d books to tenant %s\n", len(sampleBooks), *tenant)
+		return nil
+
+	case "backup":
+		if err := connect(); err != nil {
+			return err
+		}
+		path := *out
+		if path == "" {
+			path = "bookstore-" + time.Now().UTC().Format("20060102T150405Z") + ".json"
+		}
+		if path == "-" {
+			return backup(stdout)
+		}
+
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		if err := backup(f); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+		fmt.Fprintln(stderr, "wrote", path)
+		return nil
+	}
+	return errUsage
+}
+
+// The backup types add the tenant, which the API never shows.
+type (
+	backupBook struct {
+		models.Book
+		TenantID string `json:"tenantId"`
+	}
+	backupReview struct {
+		models.Review
+		TenantID string `json:"tenantId"`
+	}
+	backupWebhook struct {
+		models.Webhook
+		TenantID string `json:"tenantId"`
+	}
+	backupEvent struct {
+		models.OutboxEvent
+		TenantID string `json:"tenantId"`
+	}
+	// The event is in outboxEvents already, under its eventId.
+	backupDelivery struct {
+		models.WebhookDelivery
+		Event    *struct{} `json:"event,omitempty"`
+		TenantID string    `json:"tenantId"`
+	}
+)
+
+type backupFile struct {
+	CreatedAt    time.Time        `json:"createdAt"`
+	Tenants      []models.Tenant  `json:"tenants"`
+	Books        []backupBook     `json:"books"`
+	Reviews      []backupReview   `json:"reviews"`
+	Webhooks     []backupWebhook  `json:"webhooks"`
+	OutboxEvents []backupEvent    `json:"outboxEvents"`
+	Deliveries   []backupDelivery `json:"webhookDeliveries"`
+}
+
+// backup writes every tenant's tenants, books, reviews, webhooks, outbox
+// events and webhook deliveries as one JSON document, so that a restore
+// neither loses nor repeats a delivery. Soft-deleted rows are left out.
+func backup(w io.Writer) error {
+	tx := database.WithContext(models.AllTenants(context.Background()))
+	b := backupFile{CreatedAt: time.Now().UTC()}
+
+	if err := tx.Order("id").Find(&b.Tenants).Error; err != nil {
+		return err
+	}
+
+	var books []models.Book
+	if err := tx.Order("id").Find(&books).Error; err != nil {
+		return err
+	}
+	for _, book := range books {
+		b.Books = append(b.Books, backupBook{Book: book, TenantID: book.TenantID})
+	}
+
+	var reviews []models.Review
+	if err := tx.Order("id").Find(&reviews).Error; err != nil {
+		return err
+	}
+	for _, r := range reviews {
+		b.Reviews = append(b.Reviews, backupReview{Review: r, TenantID: r.TenantID})
+	}
+
+	var hooks []models.Webhook
+	if err := tx.Order("id").Find(&hooks).Error; err != nil {
+		return err
+	}
+	for _, h := range hooks {
+		b.Webhooks = append(b.Webhooks, backupWebhook{Webhook: h, TenantID: h.TenantID})
+	}
+
+	var events []models.OutboxEvent
+	if err := tx.Order("id").Find(&events).Error; err != nil {
+		return err
+	}
+	for _, e := range events {
+		b.OutboxEvents = append(b.OutboxEvents, backupEvent{OutboxEvent: e, TenantID: e.TenantID})
+	}
+
+	var deliveries []models.WebhookDelivery
+	if err := tx.Order("id").Find(&deliveries).Error; err != nil {
+		return err
+	}
+	for _, d := range deliveries {
+		b.Deliveries = append(b.Deliveries, backupDelivery{WebhookDelivery: d, TenantID: d.TenantID})
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(b)
+}
@@ -0,0 +1,119 @@
+// Command bookstore is an admin CLI for the bookstore catalogue. It works
+// either directly on the database, configured like the server through
+// BOOKSTORE_DB_DRIVER and BOOKSTORE_DB_DSN, or through the REST API of a
+// running server given with -server.
+//
+// Usage:
+//
+//	bookstore [flags] books list|get|create|update|delete|import|export ...
+//	bookstore [flags] db migrate|seed|backup ...
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"go-bookstore/pkg/config"
+	"go-bookstore/pkg/models"
+
+	"gorm.io/gorm"
+)
+
+const usage = `usage: bookstore [flags] <command> [args]
+
+Commands:
+  books list                       list books
+  books get <id>                   show one book
+  books create -name N [-author A] [-publication P]
+  books update <id> [-name N] [-author A] [-publication P]
+  books delete <id>
+  books import [-format csv|json] <file|->
+  books export [-format csv|json] [-out file]
+  db migrate                       create or update the schema
+  db seed                          add a few sample books
+  db backup [-out file]            write every tenant's data, outbox and
+                                   webhook deliveries included, as JSON
+
+Flags:
+`
+
+var (
+	server = flag.String("server", os.Getenv("BOOKSTORE_SERVER"), "base URL of a running server; if empty, use the database directly")
+	tenant = flag.String("tenant", models.DefaultTenant, "tenant to act for")
+	output = flag.String("output", "table", "output format: table, json or csv")
+	token  = flag.String("token", os.Getenv("BOOKSTORE_TOKEN"), "bearer token sent to the server")
+)
+
+var errUsage = errors.New("invalid usage")
+
+// Where the commands write their output and progress; tests replace them.
+var (
+	stdout io.Writer = os.Stdout
+	stderr io.Writer = os.Stderr
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if err := run(flag.Args()); err != nil {
+		if err == errUsage {
+			flag.Usage()
+			os.Exit(2)
+		}
+		fmt.Fprintln(os.Stderr, "bookstore:", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string) error {
+	if len(args) < 2 {
+		return errUsage
+	}
+	switch args[0] {
+	case "books":
+		return runBooks(args[1], args[2:])
+	case "db":
+		return runDB(args[1], args[2:])
+	}
+	return errUsage
+}
+
+// database is set by connect.
+var database *gorm.DB
+
+// connect opens and migrates the database for the commands that need it
+// directly.
+func connect() error {
+	if *server != "" {
+		return errors.New("this command needs direct database access; drop -server")
+	}
+	d, err := config.Open(config.Settings())
+	if err != nil {
+		return err
+	}
+	models.Setup(d)
+	database = d
+	return nil
+}
+
+// store returns the catalogue the books commands work on.
+func store() (catalogue, error) {
+	if *server != "" {
+		return newRESTCatalogue(*server, *tenant, *token), nil
+	}
+	if err := connect(); err != nil {
+		return nil, err
+	}
+	if models.GetTenantById(*tenant) == nil {
+		return nil, fmt.Errorf("unknown tenant %q", *tenant)
+	}
+	return dbCatalogue{ctx: models.WithTenant(context.Background(), *tenant)}, nil
+}
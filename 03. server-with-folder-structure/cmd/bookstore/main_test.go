@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"go-bookstore/pkg/config"
+	"go-bookstore/pkg/models"
+	"go-bookstore/pkg/routes"
+)
+
+// useDB points the commands at a new in-memory SQLite database, which lives
+// until the test ends.
+func useDB(t *testing.T) {
+	t.Helper()
+	dsn := fmt.Sprintf("file:%s?mode=memory&cache=shared", url.PathEscape(t.Name()))
+	t.Setenv("BOOKSTORE_DB_DRIVER", "sqlite")
+	t.Setenv("BOOKSTORE_DB_DSN", dsn)
+
+	keep, err := config.Open("sqlite", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		sqlDB, _ := keep.DB()
+		sqlDB.Close()
+	})
+}
+
+// useServer points the commands at the REST API of a test server, backed by
+// a new in-memory database.
+func useServer(t *testing.T) {
+	t.Helper()
+	useDB(t)
+	if err := connect(); err != nil {
+		t.Fatal(err)
+	}
+	r := mux.NewRouter()
+	routes.RegisterBookStoreRoutes(r)
+	srv := httptest.NewServer(r)
+	t.Cleanup(srv.Close)
+
+	*server = srv.URL
+	t.Cleanup(func() { *server = "" })
+}
+
+// cli runs the command line args with JSON output and returns what it
+// wrote to standard output.
+func cli(t *testing.T, args ...string) (string, error) {
+	t.Helper()
+	var out bytes.Buffer
+	stdout, stderr = &out, &bytes.Buffer{}
+	*output = "json"
+	t.Cleanup(func() {
+		stdout, stderr = os.Stdout, os.Stderr
+		*output = "table"
+	})
+	err := run(args)
+	return out.String(), err
+}
+
+// books runs a books command that prints books and returns them.
+func books(t *testing.T, args ...string) []models.Book {
+	t.Helper()
+	out, err := cli(t, append([]string{"books"}, args...)...)
+	if err != nil {
+		t.Fatalf("books %s: %v", strings.Join(args, " "), err)
+	}
+	var bs []models.Book
+	if err := json.Unmarshal([]byte(out), &bs); err != nil {
+		t.Fatalf("books %s printed %q: %v", strings.Join(args, " "), out, err)
+	}
+	return bs
+}
+
+func TestBooks(t *testing.T) {
+	t.Run("db", func(t *testing.T) {
+		useDB(t)
+		testBooks(t)
+	})
+	t.Run("rest", func(t *testing.T) {
+		useServer(t)
+		testBooks(t)
+	})
+}
+
+func testBooks(t *testing.T) {
+	created := books(t, "create", "-name", "Go", "-author", "Pike")
+	if len(created) != 1 || created[0].ID == 0 || created[0].Author != "Pike" {
+		t.Fatalf("create printed %+v", created)
+	}
+	id := fmt.Sprint(created[0].ID)
+
+	if got := books(t, "get", id); got[0].Name != "Go" {
+		t.Errorf("get printed %+v", got)
+	}
+	// flags may follow the id
+	got := books(t, "update", id, "-publication", "Addison-Wesley")
+	if got[0].Name != "Go" || got[0].Publication != "Addison-Wesley" {
+		t.Errorf("update printed %+v", got)
+	}
+	if got := books(t, "list"); len(got) != 1 || got[0].Publication != "Addison-Wesley" {
+		t.Errorf("list printed %+v", got)
+	}
+
+	// an export imports as a copy of each book, in either format
+	for _, name := range []string{"books.csv", "books.json"} {
+		file := filepath.Join(t.TempDir(), name)
+		if _, err := cli(t, "books", "export", "-out", file); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := cli(t, "books", "import", file); err != nil {
+			t.Fatal(err)
+		}
+	}
+	list := books(t, "list")
+	if len(list) != 4 {
+		t.Fatalf("%d books after two exports and imports, want 4", len(list))
+	}
+	for _, b := range list {
+		if b.Name != "Go" || b.Author != "Pike" || b.Publication != "Addison-Wesley" {
+			t.Errorf("imported %+v", b)
+		}
+	}
+
+	if got := books(t, "delete", id); got[0].Name != "Go" {
+		t.Errorf("delete printed %+v", got)
+	}
+	for _, cmd := range []string{"get", "update", "delete"} {
+		if _, err := cli(t, "books", cmd, id, "-name", "X"); err == nil || !strings.Contains(err.Error(), "not found") {
+			t.Errorf("%s of a deleted book: %v", cmd, err)
+		}
+	}
+	if _, err := cli(t, "books", "create", "-author", "Nobody"); err == nil {
+		t.Error("create without -name succeeded")
+	}
+}
+
+func TestBooksUnknownTenant(t *testing.T) {
+	useDB(t)
+	*tenant = "nobody"
+	defer func() { *tenant = models.DefaultTenant }()
+
+	if _, err := cli(t, "books", "list"); err == nil || !strings.Contains(err.Error(), "unknown tenant") {
+		t.Errorf("list for an unknown tenant: %v", err)
+	}
+}
+
+func TestBackup(t *testing.T) {
+	useDB(t)
+	books(t, "create", "-name", "Go")
+	ctx := models.WithTenant(context.Background(), models.DefaultTenant)
+	hook, err := (&models.Webhook{URL: "https://203.0.113.10/hook"}).CreateWebhook(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	delivery := models.WebhookDelivery{WebhookID: hook.ID, EventID: 1, Status: models.DeliveryDead}
+	if err := database.WithContext(ctx).Create(&delivery).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := cli(t, "db", "backup", "-out", "-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b struct {
+		Tenants      []json.RawMessage
+		Books        []json.RawMessage
+		Webhooks     []map[string]interface{}
+		OutboxEvents []map[string]interface{}
+		Deliveries   []map[string]interface{} `json:"webhookDeliveries"`
+	}
+	if err := json.Unmarshal([]byte(out), &b); err != nil {
+		t.Fatal(err)
+	}
+	if len(b.Tenants) != 1 || len(b.Books) != 1 || len(b.Webhooks) != 1 {
+		t.Errorf("backup has %d tenants, %d books and %d webhooks, want 1 of each", len(b.Tenants), len(b.Books), len(b.Webhooks))
+	}
+	if len(b.Webhooks) == 1 && b.Webhooks[0]["secret"] == nil {
+		t.Error("the backup leaves out the webhook's secret")
+	}
+	if len(b.OutboxEvents) != 1 || b.OutboxEvents[0]["type"] != string(models.BookCreated) || b.OutboxEvents[0]["tenantId"] != models.DefaultTenant {
+		t.Errorf("outbox events %v, want the creation of the book", b.OutboxEvents)
+	}
+	if len(b.Deliveries) != 1 || b.Deliveries[0]["status"] != string(models.DeliveryDead) || b.Deliveries[0]["eventId"] != 1.0 {
+		t.Errorf("deliveries %v, want the dead delivery of event 1", b.Deliveries)
+	}
+	if len(b.Deliveries) == 1 && b.Deliveries[0]["event"] != nil {
+		t.Error("a delivery repeats its event")
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"go-bookstore/pkg/models"
+)
+
+var csvHeader = []string{"id", "name", "author", "publication", "stock", "average_rating", "review_count", "created_at", "updated_at"}
+
+// writeBooks prints books as a table, JSON or CSV.
+func writeBooks(w io.Writer, format string, books []models.Book) error {
+	switch format {
+	case "table":
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tNAME\tAUTHOR\tPUBLICATION\tSTOCK\tRATING\tREVIEWS\tUPDATED")
+		for _, b := range books {
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%d\t%.1f\t%d\t%s\n", b.ID, b.Name, b.Author, b.Publication,
+				stock(b), b.AverageRating, b.ReviewCount, b.UpdatedAt.Format(time.DateTime))
+		}
+		return tw.Flush()
+
+	case "json":
+		if books == nil {
+			books = []models.Book{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(books)
+
+	case "csv":
+		cw := csv.NewWriter(w)
+		cw.Write(csvHeader)
+		for _, b := range books {
+			cw.Write([]string{
+				strconv.FormatUint(uint64(b.ID), 10), b.Name, b.Author, b.Publication, strconv.Itoa(stock(b)),
+				strconv.FormatFloat(b.AverageRating, 'f', -1, 64), strconv.Itoa(b.ReviewCount),
+				b.CreatedAt.Format(time.RFC3339), b.UpdatedAt.Format(time.RFC3339),
+			})
+		}
+		cw.Flush()
+		return cw.Error()
+	}
+	return fmt.Errorf("unknown output format %q", format)
+}
+
+func stock(b models.Book) int {
+	if b.Stock == nil {
+		return 0
+	}
+	return *b.Stock
+}
+
+// readBooks parses a JSON array of books or a CSV file with a header row
+// naming at least the name column; author, publication and stock are
+// optional and other columns are ignored.
+func readBooks(r io.Reader, format string) ([]models.Book, error) {
+	switch format {
+	case "json":
+		var books []models.Book
+		if err := json.NewDecoder(r).Decode(&books); err != nil {
+			return nil, fmt.Errorf("reading JSON: %w", err)
+		}
+		return books, nil
+
+	case "csv":
+		rows, err := csv.NewReader(r).ReadAll()
+		if err != nil {
+			return nil, fmt.Errorf("reading CSV: %w", err)
+		}
+		if len(rows) == 0 {
+			return nil, nil
+		}
+		col := map[string]int{}
+		for i, h := range rows[0] {
+			col[strings.ToLower(strings.TrimSpace(h))] = i
+		}
+		if _, ok := col["name"]; !ok {
+			return nil, fmt.Errorf("reading CSV: no name column in header")
+		}
+		field := func(row []string, name string) string {
+			if i, ok := col[name]; ok && i < len(row) {
+				return row[i]
+			}
+			return ""
+		}
+
+		var books []models.Book
+		for i, row := range rows[1:] {
+			b := models.Book{
+				Name:        field(row, "name"),
+				Author:      field(row, "author"),
+				Publication: field(row, "publication"),
+			}
+			if s := field(row, "stock"); s != "" {
+				n, err := strconv.Atoi(s)
+				if err != nil {
+					return nil, fmt.Errorf("reading CSV: row %d: stock %q is not a number", i+2, s)
+				}
+				b.Stock = &n
+			}
+			books = append(books, b)
+		}
+		return books, nil
+	}
+	return nil, fmt.Errorf("unknown file format %q", format)
+}
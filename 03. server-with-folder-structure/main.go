@@ -1,18 +1,57 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"go-bookstore/pkg/config"
+	"go-bookstore/pkg/feed"
+	"go-bookstore/pkg/models"
 	"go-bookstore/pkg/routes"
+	"go-bookstore/pkg/rpc"
+	"go-bookstore/pkg/webhooks"
 	"log"
+	"net"
 	"net/http"
+	"os"
+	"time"
 
 	"github.com/gorilla/mux"
 )
 
+// Where the gRPC server listens unless BOOKSTORE_GRPC_ADDR says otherwise.
+// The gateway under /v1/ dials whatever address that turns out to be.
+const defaultGRPCAddr = "0.0.0.0:9090"
+
 func main() {
+	models.Init()
+
+	go config.GetReplicas().Watch(context.Background(), 5*time.Second)
+	go webhooks.NewDispatcher().Run(context.Background())
+	feed.Start()
+
+	grpcAddr := os.Getenv("BOOKSTORE_GRPC_ADDR")
+	if grpcAddr == "" {
+		grpcAddr = defaultGRPCAddr
+	}
+	lis, err := net.Listen("tcp", grpcAddr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	go func() {
+		fmt.Println("gRPC server running at " + lis.Addr().String())
+		log.Fatal(rpc.Serve(lis))
+	}()
+
 	r := mux.NewRouter()
 	routes.RegisterBookStoreRoutes(r)
 
+	// grpc-gateway: the same BookService as JSON under /v1/.
+	gw, err := rpc.NewGateway(context.Background(), rpc.DialAddr(lis.Addr()))
+	if err != nil {
+		log.Fatal(err)
+	}
+	r.PathPrefix("/v1/").Handler(gw)
+
 	http.Handle("/", r)
 
 	fmt.Println("Server running at http://0.0.0.0:8080")
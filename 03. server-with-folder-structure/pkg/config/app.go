@@ -1,28 +1,89 @@
 package config
 
 import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/glebarez/sqlite"
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
 )
 
 var (
-	db *gorm.DB
+	db       *gorm.DB
+	replicas *ReplicaSet
 )
 
+// Connect opens the database named by BOOKSTORE_DB_DRIVER ("mysql", the
+// default, or "sqlite") and BOOKSTORE_DB_DSN. Without a DSN it uses the
+// MySQL container from docker-compose.yml. BOOKSTORE_DB_REPLICA_DSNS is an
+// optional comma-separated list of read replicas using the same driver.
 func Connect() {
 	// using Docker for mySql 
 	//* docker run --name mysql-container -e MYSQL_ROOT_PASSWORD=rootpassword -e MYSQL_DATABASE=testdb -e MYSQL_USER=testuser -e MYSQL_PASSWORD=testpassword -p 3306:3306 -d mysql:latest
 	//* testuser:testpassword@tcp(127.0.0.1:3306)/testdb?charset=utf8mb4&parseTime=True&loc=Local
 
 
-	dsn := "testuser:testpassword@tcp(db:3306)/testdb?charset=utf8mb4&parseTime=True&loc=Local"
-	d, err := gorm.Open(mysql.Open(dsn), &gorm.Config{})
+	driver, dsn := Settings()
+	d, err := Open(driver, dsn)
 	if err != nil {
 		panic(err)
 	}
 	db = d
+
+	replicas, err = OpenReplicas(driver, ReplicaDSNs())
+	if err != nil {
+		panic(err)
+	}
+}
+
+// ReplicaDSNs returns the replica DSNs from BOOKSTORE_DB_REPLICA_DSNS.
+func ReplicaDSNs() []string {
+	var dsns []string
+	for _, dsn := range strings.Split(os.Getenv("BOOKSTORE_DB_REPLICA_DSNS"), ",") {
+		if dsn = strings.TrimSpace(dsn); dsn != "" {
+			dsns = append(dsns, dsn)
+		}
+	}
+	return dsns
+}
+
+// Settings returns the driver and DSN Connect uses.
+func Settings() (driver, dsn string) {
+	driver = os.Getenv("BOOKSTORE_DB_DRIVER")
+	dsn = os.Getenv("BOOKSTORE_DB_DSN")
+	if driver == "" {
+		driver = "mysql"
+	}
+	if dsn == "" && driver == "mysql" {
+		dsn = "testuser:testpassword@tcp(db:3306)/testdb?charset=utf8mb4&parseTime=True&loc=Local"
+	}
+	return driver, dsn
+}
+
+// Open connects to a database with the given driver, "mysql" or "sqlite".
+// A SQLite DSN can be a file name or, for tests, "file:name?mode=memory".
+func Open(driver, dsn string) (*gorm.DB, error) {
+	return open(driver, dsn, &gorm.Config{})
+}
+
+func open(driver, dsn string, cfg *gorm.Config) (*gorm.DB, error) {
+	switch driver {
+	case "mysql":
+		return gorm.Open(mysql.Open(dsn), cfg)
+	case "sqlite":
+		return gorm.Open(sqlite.Open(dsn), cfg)
+	default:
+		return nil, fmt.Errorf("config: unknown database driver %q", driver)
+	}
 }
 
 func GetDB() *gorm.DB {
 	return db
 }
+
+// GetReplicas returns the read replicas, which may be empty.
+func GetReplicas() *ReplicaSet {
+	return replicas
+}
@@ -0,0 +1,128 @@
+package config
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Replica is one read replica and whether it passed its last health check.
+type Replica struct {
+	Name    string
+	DB      *gorm.DB
+	healthy atomic.Bool
+}
+
+func (r *Replica) Healthy() bool {
+	return r.healthy.Load()
+}
+
+// MarkDown takes r out of rotation until its next successful health check.
+func (r *Replica) MarkDown(reason error) {
+	if r.healthy.Swap(false) {
+		log.Printf("db: replica %s out of rotation: %v", r.Name, reason)
+	}
+}
+
+func (r *Replica) markUp() {
+	if !r.healthy.Swap(true) {
+		log.Printf("db: replica %s in rotation", r.Name)
+	}
+}
+
+// ReplicaSet hands out healthy replicas in turn.
+type ReplicaSet struct {
+	Replicas []*Replica
+	next     atomic.Uint64
+}
+
+// OpenReplicas connects to the given replicas and checks them once. A
+// replica that cannot be reached yet is not an error; it joins the rotation
+// when it passes a health check.
+func OpenReplicas(driver string, dsns []string) (*ReplicaSet, error) {
+	s := &ReplicaSet{}
+	for i, dsn := range dsns {
+		d, err := open(driver, dsn, &gorm.Config{DisableAutomaticPing: true})
+		if err != nil {
+			return nil, err
+		}
+		s.Replicas = append(s.Replicas, &Replica{Name: replicaName(i), DB: d})
+	}
+	s.Check(context.Background())
+	return s, nil
+}
+
+// NewReplicaSet wraps already open databases, all considered healthy.
+func NewReplicaSet(dbs ...*gorm.DB) *ReplicaSet {
+	s := &ReplicaSet{}
+	for i, d := range dbs {
+		r := &Replica{Name: replicaName(i), DB: d}
+		r.healthy.Store(true)
+		s.Replicas = append(s.Replicas, r)
+	}
+	return s
+}
+
+// DSNs may hold passwords, so replicas are logged by position.
+func replicaName(i int) string {
+	return "#" + strconv.Itoa(i)
+}
+
+// Pick returns the next healthy replica, or nil if there is none.
+func (s *ReplicaSet) Pick() *Replica {
+	if s == nil {
+		return nil
+	}
+	n := uint64(len(s.Replicas))
+	for i := uint64(0); i < n; i++ {
+		r := s.Replicas[(s.next.Add(1)-1)%n]
+		if r.Healthy() {
+			return r
+		}
+	}
+	return nil
+}
+
+// Check pings every replica, taking failing ones out of rotation and
+// returning recovered ones to it.
+func (s *ReplicaSet) Check(ctx context.Context) {
+	for _, r := range s.Replicas {
+		ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+		err := ping(ctx, r.DB)
+		cancel()
+		if err != nil {
+			r.MarkDown(err)
+		} else {
+			r.markUp()
+		}
+	}
+}
+
+func ping(ctx context.Context, d *gorm.DB) error {
+	sqlDB, err := d.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.PingContext(ctx)
+}
+
+// Watch runs Check every interval until ctx is cancelled.
+func (s *ReplicaSet) Watch(ctx context.Context, interval time.Duration) {
+	if s == nil || len(s.Replicas) == 0 {
+		return
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			s.Check(ctx)
+		}
+	}
+}
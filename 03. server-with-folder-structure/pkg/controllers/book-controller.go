@@ -14,7 +14,7 @@ import (
 var NewBook models.Book
 
 func GetBook(w http.ResponseWriter, r *http.Request) {
-	allBooks := models.GetAllBook()
+	allBooks := models.GetAllBook(r.Context())
 
 	res, _ := json.Marshal(allBooks)
 	w.Header().Set("Content-Type", "application/json")
@@ -31,7 +31,7 @@ func GetBookById(w http.ResponseWriter, r *http.Request) {
 		fmt.Println("error while parsing")
 	}
 
-	bookDetails, _ := models.GetBookById(ID)
+	bookDetails, _ := models.GetBookById(r.Context(), ID)
 
 	res, _ := json.Marshal(bookDetails)
 	w.Header().Set("Content-Type", "application/json")
@@ -43,7 +43,11 @@ func CreateBook(w http.ResponseWriter, r *http.Request) {
 	newBook := &models.Book{}
 	utils.ParseBody(r, newBook)
 	
-	b := newBook.CreateBook()
+	b, err := newBook.CreateBook(r.Context())
+	if err != nil {
+		modelError(w, err)
+		return
+	}
 
 	res, _ := json.Marshal(b)
 	w.Header().Set("Content-Type", "application/json")
@@ -60,7 +64,11 @@ func DeleteBook(w http.ResponseWriter, r *http.Request) {
 		fmt.Println("Error while parsing")
 	}
 
-	book := models.DeleteBook(ID)
+	book, err := models.DeleteBook(r.Context(), ID)
+	if err != nil {
+		modelError(w, err)
+		return
+	}
 
 	res, _ := json.Marshal(book)
 	w.Header().Set("Content-Type", "application/json")
@@ -80,19 +88,11 @@ func UpdateBook(w http.ResponseWriter, r *http.Request) {
 		fmt.Println("Error while parsing")
 	}
 
-	bookDetails, db := models.GetBookById(ID)
-
-	if updateBook.Name != "" {
-		bookDetails.Name = updateBook.Name
-	}
-	if updateBook.Author != "" {
-		bookDetails.Author = updateBook.Author
-	}
-	if updateBook.Publication != "" {
-		bookDetails.Publication = updateBook.Publication
+	bookDetails, err := models.UpdateBook(r.Context(), ID, updateBook)
+	if err != nil {
+		modelError(w, err)
+		return
 	}
-
-	db.Save(&bookDetails)
 	res, _ := json.Marshal(bookDetails)
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
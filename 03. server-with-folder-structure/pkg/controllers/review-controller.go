@@ -0,0 +1,129 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"go-bookstore/pkg/models"
+	"go-bookstore/pkg/utils"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+func GetBookReviews(w http.ResponseWriter, r *http.Request) {
+	ID, err := strconv.ParseInt(mux.Vars(r)["bookId"], 0, 0)
+	if err != nil {
+		fmt.Println("Error while parsing")
+	}
+
+	limit, offset := pageParams(r)
+	res, _ := json.Marshal(models.GetBookReviews(r.Context(), ID, limit, offset))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(res)
+}
+
+func CreateReview(w http.ResponseWriter, r *http.Request) {
+	review := &models.Review{}
+	utils.ParseBody(r, review)
+
+	ID, err := strconv.ParseInt(mux.Vars(r)["bookId"], 0, 0)
+	if err != nil {
+		fmt.Println("Error while parsing")
+	}
+
+	if err := review.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	created, err := review.CreateReview(r.Context(), ID)
+	if err != nil {
+		modelError(w, err)
+		return
+	}
+
+	res, _ := json.Marshal(created)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(res)
+}
+
+// GetReviewQueue lists reviews awaiting moderation, or those with the
+// status given in ?status=.
+func GetReviewQueue(w http.ResponseWriter, r *http.Request) {
+	status := models.ReviewStatus(r.URL.Query().Get("status"))
+	if status == "" {
+		status = models.ReviewPending
+	}
+	if !validReviewStatus(status) {
+		http.Error(w, "status must be pending, approved or rejected", http.StatusBadRequest)
+		return
+	}
+
+	limit, offset := pageParams(r)
+	res, _ := json.Marshal(models.ReviewQueue(r.Context(), status, limit, offset))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(res)
+}
+
+type moderation struct {
+	Status models.ReviewStatus `json:"status"`
+}
+
+func ModerateReview(w http.ResponseWriter, r *http.Request) {
+	m := &moderation{}
+	utils.ParseBody(r, m)
+
+	ID, err := strconv.ParseInt(mux.Vars(r)["reviewId"], 0, 0)
+	if err != nil {
+		fmt.Println("Error while parsing")
+	}
+
+	if !validReviewStatus(m.Status) {
+		http.Error(w, "status must be pending, approved or rejected", http.StatusBadRequest)
+		return
+	}
+
+	review, err := models.ModerateReview(r.Context(), ID, m.Status)
+	if err != nil {
+		modelError(w, err)
+		return
+	}
+	if review == nil {
+		http.Error(w, "review not found", http.StatusNotFound)
+		return
+	}
+
+	res, _ := json.Marshal(review)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(res)
+}
+
+func validReviewStatus(s models.ReviewStatus) bool {
+	return s == models.ReviewPending || s == models.ReviewApproved || s == models.ReviewRejected
+}
+
+// pageParams reads ?limit= and ?offset=, clamping the limit to maxPageSize.
+func pageParams(r *http.Request) (limit, offset int) {
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = defaultPageSize
+	}
+	if limit > maxPageSize {
+		limit = maxPageSize
+	}
+	offset, err = strconv.Atoi(r.URL.Query().Get("offset"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+	return limit, offset
+}
@@ -0,0 +1,149 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"go-bookstore/pkg/feed"
+	"go-bookstore/pkg/models"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const heartbeatInterval = 15 * time.Second
+
+// StreamBooks pushes catalogue changes as server-sent events. Each event's
+// id can be sent back in a Last-Event-ID header (browsers do this on
+// reconnect) to resume where the client left off. If the gap can no longer
+// be replayed, a "reset" event tells the client to reload the catalogue.
+func StreamBooks(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	since := lastEventID(r.Header.Get("Last-Event-ID"))
+	l, replay, missed := feed.Subscribe(since, streamFilter(r))
+	defer feed.Unsubscribe(l)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	fmt.Fprint(w, "retry: 3000\n\n")
+	if missed {
+		fmt.Fprint(w, "event: reset\ndata: {}\n\n")
+	}
+	for _, e := range replay {
+		writeEvent(w, e)
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+		case e, ok := <-l.Events():
+			if !ok {
+				// Dropped for falling behind; the client will reconnect.
+				return
+			}
+			writeEvent(w, e)
+		}
+		flusher.Flush()
+	}
+}
+
+func writeEvent(w http.ResponseWriter, e feed.Event) {
+	data, _ := json.Marshal(e.BookEvent)
+	fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+}
+
+// The default CheckOrigin refuses handshakes whose Origin header names
+// another host, so that a page elsewhere can't read the feed with the
+// credentials of a browser that visits it. Clients that send no Origin, as
+// non-browser ones usually don't, are let through.
+var upgrader = websocket.Upgrader{}
+
+// StreamBooksWS is the WebSocket form of StreamBooks. Each message is a JSON
+// feed.Event; resume with ?lastEventId=. A {"type":"reset"} message has the
+// same meaning as the SSE reset event.
+func StreamBooksWS(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	since := lastEventID(r.URL.Query().Get("lastEventId"))
+	l, replay, missed := feed.Subscribe(since, streamFilter(r))
+	defer feed.Unsubscribe(l)
+
+	// Reads are only needed to notice the client going away.
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	if missed {
+		if conn.WriteJSON(map[string]string{"type": "reset"}) != nil {
+			return
+		}
+	}
+	for _, e := range replay {
+		if conn.WriteJSON(e) != nil {
+			return
+		}
+	}
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-closed:
+			return
+		case <-heartbeat.C:
+			if conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(5*time.Second)) != nil {
+				return
+			}
+		case e, ok := <-l.Events():
+			if !ok || conn.WriteJSON(e) != nil {
+				return
+			}
+		}
+	}
+}
+
+// lastEventID parses the id a client wants to resume after. Without one the
+// client only gets events from now on.
+func lastEventID(raw string) uint64 {
+	id, err := strconv.ParseUint(raw, 10, 64)
+	if err != nil {
+		return feed.Now
+	}
+	return id
+}
+
+func streamFilter(r *http.Request) feed.Filter {
+	tenant, _ := models.TenantFrom(r.Context())
+	return feed.Filter{
+		Tenant:      tenant,
+		Author:      r.URL.Query().Get("author"),
+		Publication: r.URL.Query().Get("publication"),
+	}
+}
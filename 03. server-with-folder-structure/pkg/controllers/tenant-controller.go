@@ -0,0 +1,119 @@
+package controllers
+
+import (
+	"encoding/json"
+	"go-bookstore/pkg/models"
+	"go-bookstore/pkg/utils"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+func GetTenants(w http.ResponseWriter, r *http.Request) {
+	res, _ := json.Marshal(models.GetAllTenants())
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(res)
+}
+
+func GetTenantById(w http.ResponseWriter, r *http.Request) {
+	tenant := models.GetTenantById(mux.Vars(r)["tenantId"])
+	if tenant == nil {
+		http.Error(w, "tenant not found", http.StatusNotFound)
+		return
+	}
+
+	res, _ := json.Marshal(tenant)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(res)
+}
+
+func CreateTenant(w http.ResponseWriter, r *http.Request) {
+	tenant := &models.Tenant{}
+	utils.ParseBody(r, tenant)
+
+	if tenant.ID == "" {
+		http.Error(w, "id is required", http.StatusBadRequest)
+		return
+	}
+	if models.GetTenantById(tenant.ID) != nil {
+		http.Error(w, "tenant already exists", http.StatusConflict)
+		return
+	}
+
+	created, err := tenant.CreateTenant()
+	if err != nil {
+		modelError(w, err)
+		return
+	}
+
+	res, _ := json.Marshal(created)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(res)
+}
+
+func UpdateTenant(w http.ResponseWriter, r *http.Request) {
+	update := &models.TenantUpdate{}
+	utils.ParseBody(r, update)
+
+	tenant, err := models.UpdateTenant(mux.Vars(r)["tenantId"], update)
+	if err != nil {
+		modelError(w, err)
+		return
+	}
+	if tenant == nil {
+		http.Error(w, "tenant not found", http.StatusNotFound)
+		return
+	}
+
+	res, _ := json.Marshal(tenant)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(res)
+}
+
+func DeleteTenant(w http.ResponseWriter, r *http.Request) {
+	tenant, err := models.DeleteTenant(mux.Vars(r)["tenantId"])
+	if err != nil {
+		modelError(w, err)
+		return
+	}
+	if tenant == nil {
+		http.Error(w, "tenant not found", http.StatusNotFound)
+		return
+	}
+
+	res, _ := json.Marshal(tenant)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(res)
+}
+
+func GetTenantUsage(w http.ResponseWriter, r *http.Request) {
+	usage := models.GetTenantUsage(mux.Vars(r)["tenantId"])
+	if usage == nil {
+		http.Error(w, "tenant not found", http.StatusNotFound)
+		return
+	}
+
+	res, _ := json.Marshal(usage)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(res)
+}
+
+// modelError reports an error returned by the models package.
+func modelError(w http.ResponseWriter, err error) {
+	switch err {
+	case models.ErrQuotaExceeded:
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case models.ErrBookNotFound:
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case models.ErrTenantInUse:
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
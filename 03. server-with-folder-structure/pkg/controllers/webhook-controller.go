@@ -0,0 +1,128 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"go-bookstore/pkg/models"
+	"go-bookstore/pkg/utils"
+	"go-bookstore/pkg/webhooks"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+func GetWebhooks(w http.ResponseWriter, r *http.Request) {
+	hooks := models.GetAllWebhooks(r.Context())
+	for i := range hooks {
+		hooks[i] = hooks[i].Redacted()
+	}
+	res, _ := json.Marshal(hooks)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(res)
+}
+
+func GetWebhookById(w http.ResponseWriter, r *http.Request) {
+	ID, err := strconv.ParseInt(mux.Vars(r)["webhookId"], 0, 0)
+	if err != nil {
+		fmt.Println("error while parsing")
+	}
+
+	hook := models.GetWebhookById(r.Context(), ID)
+	if hook == nil {
+		http.Error(w, "webhook not found", http.StatusNotFound)
+		return
+	}
+
+	res, _ := json.Marshal(hook.Redacted())
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(res)
+}
+
+func CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	hook := &models.Webhook{}
+	utils.ParseBody(r, hook)
+
+	if err := webhooks.CheckURL(r.Context(), hook.URL); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	created, err := hook.CreateWebhook(r.Context())
+	if err != nil {
+		modelError(w, err)
+		return
+	}
+
+	res, _ := json.Marshal(created)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(res)
+}
+
+func UpdateWebhook(w http.ResponseWriter, r *http.Request) {
+	update := &models.Webhook{}
+	utils.ParseBody(r, update)
+
+	ID, err := strconv.ParseInt(mux.Vars(r)["webhookId"], 0, 0)
+	if err != nil {
+		fmt.Println("Error while parsing")
+	}
+
+	if update.URL != "" {
+		if err := webhooks.CheckURL(r.Context(), update.URL); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	hook := models.UpdateWebhook(r.Context(), ID, update)
+	if hook == nil {
+		http.Error(w, "webhook not found", http.StatusNotFound)
+		return
+	}
+
+	res, _ := json.Marshal(hook.Redacted())
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(res)
+}
+
+func DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	ID, err := strconv.ParseInt(mux.Vars(r)["webhookId"], 0, 0)
+	if err != nil {
+		fmt.Println("Error while parsing")
+	}
+
+	res, _ := json.Marshal(models.DeleteWebhook(r.Context(), ID).Redacted())
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(res)
+}
+
+func GetDeadLetters(w http.ResponseWriter, r *http.Request) {
+	res, _ := json.Marshal(models.DeadLetters(r.Context()))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(res)
+}
+
+func RetryDeadLetter(w http.ResponseWriter, r *http.Request) {
+	ID, err := strconv.ParseInt(mux.Vars(r)["deliveryId"], 0, 0)
+	if err != nil {
+		fmt.Println("Error while parsing")
+	}
+
+	delivery := models.RetryDeadLetter(r.Context(), ID)
+	if delivery == nil {
+		http.Error(w, "dead letter not found", http.StatusNotFound)
+		return
+	}
+
+	res, _ := json.Marshal(delivery)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(res)
+}
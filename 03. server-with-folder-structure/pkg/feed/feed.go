@@ -0,0 +1,135 @@
+package feed
+
+import (
+	"strings"
+	"sync"
+
+	"go-bookstore/pkg/models"
+)
+
+// Size of the ring buffer of recent events that reconnecting clients can
+// resume from.
+const bufferSize = 1024
+
+// Now passed to Subscribe skips the replay: only events from now on are sent.
+const Now = ^uint64(0)
+
+// Event is a models.BookEvent numbered in the order the feed saw it. IDs
+// start at 1 and restart with the process.
+type Event struct {
+	ID uint64 `json:"id"`
+	models.BookEvent
+}
+
+// Filter restricts a subscription to the books of one tenant whose author
+// or publication contain the given text, ignoring case. Empty Author and
+// Publication match everything; Tenant always has to match.
+type Filter struct {
+	Tenant      string
+	Author      string
+	Publication string
+}
+
+func (f Filter) Match(b *models.Book) bool {
+	return b.TenantID == f.Tenant && contains(b.Author, f.Author) && contains(b.Publication, f.Publication)
+}
+
+func contains(s, substr string) bool {
+	return substr == "" || strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
+
+var (
+	mu        sync.Mutex
+	ring      [bufferSize]Event
+	lastID    uint64
+	listeners = map[*Listener]struct{}{}
+	startOnce sync.Once
+)
+
+// Start begins numbering and buffering the events published by models. The
+// feed observes them synchronously, so none is lost however busy the writers
+// are. It is safe to call more than once.
+func Start() {
+	startOnce.Do(func() {
+		models.Observe(broadcast)
+	})
+}
+
+func broadcast(be models.BookEvent) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	lastID++
+	e := Event{ID: lastID, BookEvent: be}
+	ring[lastID%bufferSize] = e
+
+	for l := range listeners {
+		if !l.filter.Match(&e.Book) {
+			continue
+		}
+		select {
+		case l.ch <- e:
+		default:
+			// Too far behind: drop the listener. The client reconnects
+			// with Last-Event-ID and catches up from the ring buffer.
+			delete(listeners, l)
+			close(l.ch)
+		}
+	}
+}
+
+// Listener receives live events for one connection.
+type Listener struct {
+	ch     chan Event
+	filter Filter
+}
+
+func (l *Listener) Events() <-chan Event {
+	return l.ch
+}
+
+// Subscribe registers a listener and returns the buffered events after
+// since that match filter, so that nothing is lost between the two. Missed
+// is true when events after since have already left the buffer and the
+// client should reload the catalogue instead of relying on the replay.
+func Subscribe(since uint64, filter Filter) (l *Listener, replay []Event, missed bool) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if since == Now {
+		since = lastID
+	} else if since > lastID {
+		// An ID from before a restart: whatever happened in between is gone.
+		missed = true
+		since = lastID
+	}
+	oldest := uint64(1)
+	if lastID > bufferSize {
+		oldest = lastID - bufferSize + 1
+	}
+	if since+1 < oldest {
+		missed = true
+		since = oldest - 1
+	}
+	for id := since + 1; id <= lastID; id++ {
+		if e := ring[id%bufferSize]; filter.Match(&e.Book) {
+			replay = append(replay, e)
+		}
+	}
+
+	l = &Listener{ch: make(chan Event, 256), filter: filter}
+	listeners[l] = struct{}{}
+	return l, replay, missed
+}
+
+// Unsubscribe stops delivery to l. It is safe to call after the feed has
+// already dropped l.
+func Unsubscribe(l *Listener) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if _, ok := listeners[l]; ok {
+		delete(listeners, l)
+		close(l.ch)
+	}
+}
@@ -0,0 +1,47 @@
+package feed_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"go-bookstore/pkg/config"
+	"go-bookstore/pkg/feed"
+	"go-bookstore/pkg/models"
+)
+
+// Writers never wait for the feed to catch up, but the feed still sees every
+// change, as soon as the change has been made.
+func TestFeedKeepsEveryEvent(t *testing.T) {
+	feed.Start()
+	d, err := config.Open("sqlite", "file:feed?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		sqlDB, _ := d.DB()
+		sqlDB.Close()
+	})
+	models.Setup(d)
+
+	// Much more than the buffer of a channel subscription, all at once.
+	const n = 500
+	ctx := models.WithTenant(context.Background(), models.DefaultTenant)
+	for i := 0; i < n; i++ {
+		b := &models.Book{Name: fmt.Sprint(i), Author: "Feed"}
+		if _, err := b.CreateBook(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	l, replay, missed := feed.Subscribe(0, feed.Filter{Tenant: models.DefaultTenant, Author: "Feed"})
+	defer feed.Unsubscribe(l)
+	if missed || len(replay) != n {
+		t.Fatalf("replay has %d events (missed %v), want %d", len(replay), missed, n)
+	}
+	for i, e := range replay {
+		if e.Book.Name != fmt.Sprint(i) {
+			t.Fatalf("event %d is for book %q, want %q", i, e.Book.Name, fmt.Sprint(i))
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package gql
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+)
+
+type request struct {
+	Query         string                 `json:"query"`
+	Variables     map[string]interface{} `json:"variables"`
+	OperationName string                 `json:"operationName"`
+}
+
+// Handler executes GraphQL queries sent as a JSON POST body or, for queries
+// only, as GET query parameters. A GET is answered with 405 if it would run
+// a mutation, so that a plain link can't change anything.
+func Handler(w http.ResponseWriter, r *http.Request) {
+	var req request
+	if r.Method == http.MethodGet {
+		req.Query = r.URL.Query().Get("query")
+		req.OperationName = r.URL.Query().Get("operationName")
+		if v := r.URL.Query().Get("variables"); v != "" {
+			if err := json.Unmarshal([]byte(v), &req.Variables); err != nil {
+				writeError(w, http.StatusBadRequest, "variables must be a JSON object: "+err.Error())
+				return
+			}
+		}
+		if isMutation(req.Query, req.OperationName) {
+			w.Header().Set("Allow", http.MethodPost)
+			writeError(w, http.StatusMethodNotAllowed, "mutations must be sent with POST")
+			return
+		}
+	} else if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid GraphQL request body", http.StatusBadRequest)
+		return
+	}
+
+	result := graphql.Do(graphql.Params{
+		Schema:         schema,
+		RequestString:  req.Query,
+		VariableValues: req.Variables,
+		OperationName:  req.OperationName,
+		Context:        withLoaders(r.Context()),
+	})
+	write(w, http.StatusOK, result)
+}
+
+// isMutation reports whether query has a mutation among the operations
+// that operationName may select: the one of that name, or any of them when
+// it is empty. A query that doesn't parse runs nothing, so it isn't one.
+func isMutation(query, operationName string) bool {
+	doc, err := parser.Parse(parser.ParseParams{Source: query})
+	if err != nil {
+		return false
+	}
+	for _, def := range doc.Definitions {
+		op, ok := def.(*ast.OperationDefinition)
+		if !ok || op.GetOperation() != ast.OperationTypeMutation {
+			continue
+		}
+		if operationName == "" || op.GetName() != nil && op.GetName().Value == operationName {
+			return true
+		}
+	}
+	return false
+}
+
+// writeError answers with a GraphQL result holding only the error.
+func writeError(w http.ResponseWriter, status int, msg string) {
+	write(w, status, &graphql.Result{Errors: []gqlerrors.FormattedError{gqlerrors.NewFormattedError(msg)}})
+}
+
+func write(w http.ResponseWriter, status int, result *graphql.Result) {
+	res, _ := json.Marshal(result)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(res)
+}
@@ -0,0 +1,71 @@
+package gql
+
+import (
+	"context"
+	"sync"
+
+	"go-bookstore/pkg/models"
+)
+
+// loader batches lookups made while one level of a query is being resolved.
+// Resolvers call load, which only records the key and returns a thunk;
+// graphql-go resolves every sibling field before it calls the thunks, so the
+// first thunk to run fetches all recorded keys with a single query.
+type loader[K comparable, V any] struct {
+	fetch func([]K) map[K]V
+
+	mu      sync.Mutex
+	pending []K
+	queued  map[K]bool
+	results map[K]V
+}
+
+func newLoader[K comparable, V any](fetch func([]K) map[K]V) *loader[K, V] {
+	return &loader[K, V]{fetch: fetch, queued: map[K]bool{}, results: map[K]V{}}
+}
+
+func (l *loader[K, V]) load(key K) func() (interface{}, error) {
+	l.mu.Lock()
+	if _, ok := l.results[key]; !ok && !l.queued[key] {
+		l.pending = append(l.pending, key)
+		l.queued[key] = true
+	}
+	l.mu.Unlock()
+
+	return func() (interface{}, error) {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		if len(l.pending) > 0 {
+			keys := l.pending
+			l.pending = nil
+			fetched := l.fetch(keys)
+			for _, k := range keys {
+				// Store misses too, so they are not fetched again.
+				l.results[k] = fetched[k]
+				delete(l.queued, k)
+			}
+		}
+		return l.results[key], nil
+	}
+}
+
+// loaders are created per request so nothing is cached between requests, and
+// fetch with the request's context so lookups stay within its tenant.
+type loaders struct {
+	authorBooks *loader[string, []models.Book]
+}
+
+type loadersKey struct{}
+
+func withLoaders(ctx context.Context) context.Context {
+	return context.WithValue(ctx, loadersKey{}, &loaders{
+		authorBooks: newLoader(func(names []string) map[string][]models.Book {
+			return models.GetBooksByAuthors(ctx, names)
+		}),
+	})
+}
+
+func loadersFrom(ctx context.Context) *loaders {
+	return ctx.Value(loadersKey{}).(*loaders)
+}
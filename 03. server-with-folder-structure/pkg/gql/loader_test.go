@@ -0,0 +1,72 @@
+package gql
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"go-bookstore/pkg/config"
+	"go-bookstore/pkg/models"
+
+	"github.com/graphql-go/graphql"
+	"gorm.io/gorm"
+)
+
+// The authors of every book on a page, and their books, are looked up with
+// one query however many books there are.
+func TestAuthorsAreBatched(t *testing.T) {
+	d, err := config.Open("sqlite", "file:loader?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		sqlDB, _ := d.DB()
+		sqlDB.Close()
+	})
+	models.Setup(d)
+
+	const n = 10
+	ctx := models.WithTenant(context.Background(), models.DefaultTenant)
+	for i := 0; i < n; i++ {
+		b := &models.Book{Name: fmt.Sprint("Book ", i), Author: fmt.Sprintf("Author %d, Editor", i)}
+		if _, err := b.CreateBook(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	queries := 0
+	d.Callback().Query().Before("gorm:query").Register("test:count", func(tx *gorm.DB) {
+		if tx.Statement.Table == "books" {
+			queries++
+		}
+	})
+	run := func(query string) *graphql.Result {
+		t.Helper()
+		queries = 0
+		res := graphql.Do(graphql.Params{Schema: schema, RequestString: query, Context: withLoaders(ctx)})
+		if len(res.Errors) > 0 {
+			t.Fatal(res.Errors)
+		}
+		return res
+	}
+
+	run(`{ books { items { name } } }`)
+	page := queries
+	res := run(`{ books { items { name authors { name books { name } } } } }`)
+	if got := queries - page; got != 1 {
+		t.Errorf("the authors of %d books took %d queries, want 1", n, got)
+	}
+
+	items := res.Data.(map[string]interface{})["books"].(map[string]interface{})["items"].([]interface{})
+	for i, item := range items {
+		authors := item.(map[string]interface{})["authors"].([]interface{})
+		if len(authors) != 2 {
+			t.Fatalf("book %d has authors %v", i, authors)
+		}
+		own := authors[0].(map[string]interface{})["books"].([]interface{})
+		editor := authors[1].(map[string]interface{})["books"].([]interface{})
+		if len(own) != 1 || len(editor) != n {
+			t.Errorf("book %d: its author has %d books and the editor %d, want 1 and %d", i, len(own), len(editor), n)
+		}
+	}
+}
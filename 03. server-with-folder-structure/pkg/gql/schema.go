@@ -0,0 +1,290 @@
+package gql
+
+import (
+	"go-bookstore/pkg/models"
+
+	"github.com/graphql-go/graphql"
+)
+
+// author is not stored anywhere; it is derived from the Author field of the
+// books that name it.
+type author struct {
+	Name string
+}
+
+type bookPage struct {
+	TotalCount  int64
+	Items       []models.Book
+	HasNextPage bool
+}
+
+var authorType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Author",
+	Fields: graphql.Fields{
+		"name": &graphql.Field{
+			Type: graphql.NewNonNull(graphql.String),
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return p.Source.(author).Name, nil
+			},
+		},
+		"books": &graphql.Field{
+			Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(bookType))),
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return loadersFrom(p.Context).authorBooks.load(p.Source.(author).Name), nil
+			},
+		},
+	},
+})
+
+var bookType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Book",
+	Fields: graphql.Fields{
+		"id": &graphql.Field{
+			Type: graphql.NewNonNull(graphql.Int),
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return book(p).ID, nil
+			},
+		},
+		"name": &graphql.Field{
+			Type: graphql.NewNonNull(graphql.String),
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return book(p).Name, nil
+			},
+		},
+		"author": &graphql.Field{
+			Type:        graphql.NewNonNull(graphql.String),
+			Description: "The author field as stored, e.g. \"Kernighan, Ritchie\".",
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return book(p).Author, nil
+			},
+		},
+		"publication": &graphql.Field{
+			Type: graphql.NewNonNull(graphql.String),
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return book(p).Publication, nil
+			},
+		},
+		"stock": &graphql.Field{
+			Type:        graphql.NewNonNull(graphql.Int),
+			Description: "Copies in stock.",
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				if stock := book(p).Stock; stock != nil {
+					return *stock, nil
+				}
+				return 0, nil
+			},
+		},
+		"averageRating": &graphql.Field{
+			Type:        graphql.NewNonNull(graphql.Float),
+			Description: "Average of the approved reviews, 0 if there are none.",
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return book(p).AverageRating, nil
+			},
+		},
+		"reviewCount": &graphql.Field{
+			Type: graphql.NewNonNull(graphql.Int),
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return book(p).ReviewCount, nil
+			},
+		},
+		"createdAt": &graphql.Field{
+			Type: graphql.DateTime,
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return book(p).CreatedAt, nil
+			},
+		},
+		"updatedAt": &graphql.Field{
+			Type: graphql.DateTime,
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return book(p).UpdatedAt, nil
+			},
+		},
+	},
+})
+
+var schema graphql.Schema
+
+// Book and Author refer to each other, which Go rejects as an initialization
+// cycle, so the Book side of the link is added here, before the schema is
+// built.
+func init() {
+	bookType.AddFieldConfig("authors", &graphql.Field{
+		Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(authorType))),
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			authors := []author{}
+			for _, name := range book(p).Authors() {
+				authors = append(authors, author{Name: name})
+			}
+			return authors, nil
+		},
+	})
+
+	var err error
+	schema, err = graphql.NewSchema(graphql.SchemaConfig{
+		Query:    queryType,
+		Mutation: mutationType,
+	})
+	if err != nil {
+		panic(err)
+	}
+}
+
+var bookPageType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "BookPage",
+	Fields: graphql.Fields{
+		"totalCount": &graphql.Field{
+			Type: graphql.NewNonNull(graphql.Int),
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return p.Source.(bookPage).TotalCount, nil
+			},
+		},
+		"items": &graphql.Field{
+			Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(bookType))),
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return p.Source.(bookPage).Items, nil
+			},
+		},
+		"hasNextPage": &graphql.Field{
+			Type: graphql.NewNonNull(graphql.Boolean),
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return p.Source.(bookPage).HasNextPage, nil
+			},
+		},
+	},
+})
+
+var bookFilterType = graphql.NewInputObject(graphql.InputObjectConfig{
+	Name:        "BookFilter",
+	Description: "Each field matches as a case-insensitive substring.",
+	Fields: graphql.InputObjectConfigFieldMap{
+		"name":        &graphql.InputObjectFieldConfig{Type: graphql.String},
+		"author":      &graphql.InputObjectFieldConfig{Type: graphql.String},
+		"publication": &graphql.InputObjectFieldConfig{Type: graphql.String},
+	},
+})
+
+var bookInputType = graphql.NewInputObject(graphql.InputObjectConfig{
+	Name:        "BookInput",
+	Description: "On update, omitted or empty fields are left unchanged, except that a stock of 0 is stored.",
+	Fields: graphql.InputObjectConfigFieldMap{
+		"name":        &graphql.InputObjectFieldConfig{Type: graphql.String},
+		"author":      &graphql.InputObjectFieldConfig{Type: graphql.String},
+		"publication": &graphql.InputObjectFieldConfig{Type: graphql.String},
+		"stock":       &graphql.InputObjectFieldConfig{Type: graphql.Int},
+	},
+})
+
+const maxPageSize = 100
+
+var queryType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Query",
+	Fields: graphql.Fields{
+		"book": &graphql.Field{
+			Type: bookType,
+			Args: graphql.FieldConfigArgument{
+				"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
+			},
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				b, _ := models.GetBookById(p.Context, int64(p.Args["id"].(int)))
+				if b.ID == 0 {
+					return nil, nil
+				}
+				return b, nil
+			},
+		},
+		"books": &graphql.Field{
+			Type: graphql.NewNonNull(bookPageType),
+			Args: graphql.FieldConfigArgument{
+				"filter": &graphql.ArgumentConfig{Type: bookFilterType},
+				"limit":  &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: 20},
+				"offset": &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: 0},
+			},
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				f := models.BookFilter{Limit: p.Args["limit"].(int), Offset: p.Args["offset"].(int)}
+				if f.Limit <= 0 || f.Limit > maxPageSize {
+					f.Limit = maxPageSize
+				}
+				if f.Offset < 0 {
+					f.Offset = 0
+				}
+				if filter, ok := p.Args["filter"].(map[string]interface{}); ok {
+					f.Name, _ = filter["name"].(string)
+					f.Author, _ = filter["author"].(string)
+					f.Publication, _ = filter["publication"].(string)
+				}
+
+				items, total := models.FindBooks(p.Context, f)
+				return bookPage{
+					TotalCount:  total,
+					Items:       items,
+					HasNextPage: int64(f.Offset+len(items)) < total,
+				}, nil
+			},
+		},
+	},
+})
+
+var mutationType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Mutation",
+	Fields: graphql.Fields{
+		"createBook": &graphql.Field{
+			Type: graphql.NewNonNull(bookType),
+			Args: graphql.FieldConfigArgument{
+				"input": &graphql.ArgumentConfig{Type: graphql.NewNonNull(bookInputType)},
+			},
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return bookInput(p).CreateBook(p.Context)
+			},
+		},
+		"updateBook": &graphql.Field{
+			Type: bookType,
+			Args: graphql.FieldConfigArgument{
+				"id":    &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
+				"input": &graphql.ArgumentConfig{Type: graphql.NewNonNull(bookInputType)},
+			},
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				b, err := models.UpdateBook(p.Context, int64(p.Args["id"].(int)), bookInput(p))
+				if b == nil {
+					return nil, err
+				}
+				return b, nil
+			},
+		},
+		"deleteBook": &graphql.Field{
+			Type:        bookType,
+			Description: "Returns the deleted book, or null if there was none.",
+			Args: graphql.FieldConfigArgument{
+				"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
+			},
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				b, err := models.DeleteBook(p.Context, int64(p.Args["id"].(int)))
+				if err != nil || b.ID == 0 {
+					return nil, err
+				}
+				return b, nil
+			},
+		},
+	},
+})
+
+func book(p graphql.ResolveParams) *models.Book {
+	switch b := p.Source.(type) {
+	case *models.Book:
+		return b
+	case models.Book:
+		return &b
+	}
+	return &models.Book{}
+}
+
+func bookInput(p graphql.ResolveParams) *models.Book {
+	in := p.Args["input"].(map[string]interface{})
+	b := &models.Book{}
+	b.Name, _ = in["name"].(string)
+	b.Author, _ = in["author"].(string)
+	b.Publication, _ = in["publication"].(string)
+	if stock, ok := in["stock"].(int); ok {
+		b.Stock = &stock
+	}
+	return b
+}
@@ -0,0 +1,33 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// AdminOnly lets through requests bearing the BOOKSTORE_ADMIN_TOKEN. When
+// that is not set the routes it guards are disabled.
+func AdminOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		want := os.Getenv("BOOKSTORE_ADMIN_TOKEN")
+		if want == "" {
+			http.Error(w, "admin API disabled", http.StatusForbidden)
+			return
+		}
+
+		if !isAdmin(r.Header.Get("Authorization")) {
+			http.Error(w, "admin token required", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isAdmin reports whether authorization bears the BOOKSTORE_ADMIN_TOKEN.
+func isAdmin(authorization string) bool {
+	want := os.Getenv("BOOKSTORE_ADMIN_TOKEN")
+	got, ok := strings.CutPrefix(authorization, "Bearer ")
+	return want != "" && ok && subtle.ConstantTimeCompare([]byte(got), []byte(want)) == 1
+}
@@ -0,0 +1,61 @@
+package middleware
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"go-bookstore/pkg/models"
+)
+
+// StickyCookie holds the time until which a client's reads go to the
+// primary database, in Unix milliseconds.
+const StickyCookie = "bookstore_primary_until"
+
+const defaultStickyWindow = 5 * time.Second
+
+// ReadYourWrites sends a client's reads to the primary for a while after it
+// writes, so it does not read stale data from a lagging replica. The window
+// is BOOKSTORE_STICKY_WINDOW (a duration such as "5s"), 5 seconds by
+// default. Any request that is not a GET, HEAD or OPTIONS counts as a write.
+func ReadYourWrites(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		now := time.Now()
+		window := stickyWindow()
+
+		sticky := false
+		if c, err := r.Cookie(StickyCookie); err == nil {
+			until, err := strconv.ParseInt(c.Value, 10, 64)
+			// A forged cookie can only ever pin a client for one window.
+			sticky = err == nil && now.Before(time.UnixMilli(until)) && time.UnixMilli(until).Sub(now) <= window
+		}
+
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+		default:
+			sticky = true
+			until := now.Add(window)
+			http.SetCookie(w, &http.Cookie{
+				Name:     StickyCookie,
+				Value:    strconv.FormatInt(until.UnixMilli(), 10),
+				Path:     "/",
+				Expires:  until,
+				HttpOnly: true,
+				SameSite: http.SameSiteLaxMode,
+			})
+		}
+
+		if sticky {
+			r = r.WithContext(models.WithPrimary(r.Context()))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func stickyWindow() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv("BOOKSTORE_STICKY_WINDOW")); err == nil && d >= 0 {
+		return d
+	}
+	return defaultStickyWindow
+}
@@ -0,0 +1,165 @@
+package middleware
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"go-bookstore/pkg/models"
+)
+
+// TenantHeader names the tenant a request is for.
+const TenantHeader = "X-Tenant-ID"
+
+var (
+	ErrNoToken        = errors.New("bearer token required")
+	ErrBadToken       = errors.New("invalid bearer token")
+	ErrTenantMismatch = errors.New("tenant header does not match token")
+	ErrUnknownTenant  = errors.New("unknown tenant")
+)
+
+// Tenant scopes the request context to the tenant resolved by ResolveTenant.
+func Tenant(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := ResolveTenant(r.Header, r.Host)
+		if err != nil {
+			http.Error(w, err.Error(), TenantErrorStatus(err))
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(models.WithTenant(r.Context(), id)))
+	})
+}
+
+// ResolveTenant works out which tenant a request is for.
+//
+// When BOOKSTORE_JWT_SECRET is set, the request has to prove it: the tenant
+// is the "tenant" claim of an HS256 JWT bearer token signed with that
+// secret, and nothing else. An X-Tenant-ID header must agree with the
+// claim. The one exception is a bearer of BOOKSTORE_ADMIN_TOKEN, who may
+// act for any tenant and names it as below.
+//
+// Without a secret, as in development, the tenant is taken from:
+//
+//   - the X-Tenant-ID header;
+//   - the subdomain of host under BOOKSTORE_BASE_DOMAIN, if that is set;
+//   - models.DefaultTenant.
+//
+// The tenant must exist.
+func ResolveTenant(h http.Header, host string) (string, error) {
+	var id string
+	if secret := os.Getenv("BOOKSTORE_JWT_SECRET"); secret != "" && !isAdmin(h.Get("Authorization")) {
+		claimed, err := tokenTenant(secret, h.Get("Authorization"))
+		if err != nil {
+			return "", err
+		}
+		if header := h.Get(TenantHeader); header != "" && header != claimed {
+			return "", ErrTenantMismatch
+		}
+		id = claimed
+	} else {
+		id = h.Get(TenantHeader)
+		if id == "" {
+			id = subdomain(host)
+		}
+		if id == "" {
+			id = models.DefaultTenant
+		}
+	}
+
+	if models.GetTenantById(id) == nil {
+		return "", ErrUnknownTenant
+	}
+	return id, nil
+}
+
+// TenantErrorStatus maps an error from ResolveTenant to an HTTP status.
+func TenantErrorStatus(err error) int {
+	switch err {
+	case ErrNoToken, ErrBadToken:
+		return http.StatusUnauthorized
+	case ErrTenantMismatch:
+		return http.StatusForbidden
+	default:
+		return http.StatusNotFound
+	}
+}
+
+func subdomain(host string) string {
+	base := os.Getenv("BOOKSTORE_BASE_DOMAIN")
+	if base == "" {
+		return ""
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(host)
+	label := strings.TrimSuffix(host, "."+strings.ToLower(base))
+	if label == host || strings.Contains(label, ".") {
+		return ""
+	}
+	return label
+}
+
+type claims struct {
+	Tenant string `json:"tenant"`
+	Exp    int64  `json:"exp"`
+}
+
+// tokenTenant returns the tenant claim of a bearer JWT signed with secret.
+// A request without one fails with ErrNoToken, and a token that doesn't
+// check out or names no tenant with ErrBadToken.
+func tokenTenant(secret, authorization string) (string, error) {
+	token, ok := strings.CutPrefix(authorization, "Bearer ")
+	if !ok {
+		return "", ErrNoToken
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", ErrBadToken
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if decodeSegment(parts[0], &header) != nil || header.Alg != "HS256" {
+		return "", ErrBadToken
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", ErrBadToken
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return "", ErrBadToken
+	}
+
+	var c claims
+	if decodeSegment(parts[1], &c) != nil {
+		return "", ErrBadToken
+	}
+	if c.Exp != 0 && time.Now().Unix() >= c.Exp {
+		return "", ErrBadToken
+	}
+	if c.Tenant == "" {
+		return "", ErrBadToken
+	}
+	return c.Tenant, nil
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	raw, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, v)
+}
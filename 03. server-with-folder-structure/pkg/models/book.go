@@ -1,6 +1,9 @@
 package models
 
 import (
+	"context"
+	"strings"
+
 	"gorm.io/gorm"
 	"go-bookstore/pkg/config"
 )
@@ -9,36 +12,201 @@ var db *gorm.DB
 
 type Book struct {
 	gorm.Model
+	TenantID string `gorm:"size:64;not null;index;default:'default'" json:"-"`
 	Name string `gorm:"" json:"name"`
 	Author string `json:"author"`
 	Publication string `json:"publication"`
+	// Copies in stock. A pointer so that an update can set it to 0; CreateBook
+	// stores 0 when it is nil.
+	Stock *int `gorm:"not null;default:0" json:"stock"`
+	// Maintained from the approved reviews; see ModerateReview.
+	AverageRating float64 `json:"averageRating"`
+	ReviewCount int `json:"reviewCount"`
 }
 
-func init() {
+// Init connects to the database and migrates the schema. It is called from
+// main rather than from a package init so that the package can be imported
+// (by the routes, the OpenAPI generator, tests) without a running MySQL.
+func Init() {
 	config.Connect()
-	db = config.GetDB()
-	db.AutoMigrate(&Book{})
+	Setup(config.GetDB())
+	SetReplicas(config.GetReplicas())
+}
+
+// Setup makes the package use d, migrating its schema. Tests call it with
+// a fresh database instead of Init.
+func Setup(d *gorm.DB) {
+	db = d
+	registerTenantScope(db)
+	db.AutoMigrate(&Tenant{}, &Book{}, &OutboxEvent{}, &Webhook{}, &WebhookDelivery{}, &Review{})
+	ensureDefaultTenant()
 }
 
-func (b *Book) CreateBook() *Book {
-	db.Create(&b)
-	return b
+// CreateBook stores b for the tenant in ctx, failing with ErrQuotaExceeded
+// when the tenant already has as many books as it is allowed.
+func (b *Book) CreateBook(ctx context.Context) (*Book, error) {
+	b.AverageRating, b.ReviewCount = 0, 0
+	if b.Stock == nil {
+		b.Stock = new(int)
+	}
+	err := mutate(ctx, BookCreated, b, func(tx *gorm.DB) error {
+		tenant, err := lockTenant(tx)
+		if err != nil {
+			return err
+		}
+		if tenant.MaxBooks > 0 {
+			var count int64
+			tx.Model(&Book{}).Count(&count)
+			if count >= int64(tenant.MaxBooks) {
+				return ErrQuotaExceeded
+			}
+		}
+		return tx.Create(b).Error
+	})
+	return b, err
 }
 
-func GetAllBook() []Book {
+// GetAllBook and GetBookById read from a replica when there is one; see
+// SetReplicas.
+func GetAllBook(ctx context.Context) []Book {
 	var Books []Book
-	db.Find(&Books)
+	read(ctx, func(d *gorm.DB) *gorm.DB {
+		Books = nil
+		return d.Find(&Books)
+	})
 	return Books
 }
 
-func GetBookById(Id int64) (*Book, *gorm.DB) {
+func GetBookById(ctx context.Context, Id int64) (*Book, *gorm.DB) {
 	var getBook Book
-	db := db.Where("ID=?", Id).Find(&getBook)
+	db := read(ctx, func(d *gorm.DB) *gorm.DB {
+		getBook = Book{}
+		return d.Where("ID=?", Id).Find(&getBook)
+	})
 	return &getBook, db
 }
 
-func DeleteBook(Id int64) Book {
+// DeleteBook returns the book as it was before deletion, or a zero Book if
+// there was none.
+func DeleteBook(ctx context.Context, Id int64) (Book, error) {
 	var book Book
-	db.Where("ID=?", Id).Delete(&book)
-	return book
-}
\ No newline at end of file
+	db.WithContext(ctx).Where("ID=?", Id).Find(&book)
+	if book.ID == 0 {
+		return book, nil
+	}
+	err := mutate(ctx, BookDeleted, &book, func(tx *gorm.DB) error {
+		return tx.Delete(&book).Error
+	})
+	return book, err
+}
+
+// UpdateBook copies the non-empty fields of update onto the stored book. It
+// returns nil when no book has the given id.
+func UpdateBook(ctx context.Context, Id int64, update *Book) (*Book, error) {
+	bookDetails, _ := GetBookById(WithPrimary(ctx), Id)
+	if bookDetails.ID == 0 {
+		return nil, nil
+	}
+
+	if update.Name != "" {
+		bookDetails.Name = update.Name
+	}
+	if update.Author != "" {
+		bookDetails.Author = update.Author
+	}
+	if update.Publication != "" {
+		bookDetails.Publication = update.Publication
+	}
+	if update.Stock != nil {
+		bookDetails.Stock = update.Stock
+	}
+
+	err := mutate(ctx, BookUpdated, bookDetails, func(tx *gorm.DB) error {
+		return tx.Omit("AverageRating", "ReviewCount").Save(bookDetails).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return bookDetails, nil
+}
+
+// BookFilter narrows FindBooks. String fields match as case-insensitive
+// substrings; a zero Limit means no limit.
+type BookFilter struct {
+	Name        string
+	Author      string
+	Publication string
+	Limit       int
+	Offset      int
+}
+
+// FindBooks returns one page of the books matching f, ordered by id, along
+// with the total number of matches.
+func FindBooks(ctx context.Context, f BookFilter) ([]Book, int64) {
+	q := db.WithContext(ctx).Model(&Book{})
+	if f.Name != "" {
+		q = q.Where("LOWER(name) LIKE ?", "%"+strings.ToLower(f.Name)+"%")
+	}
+	if f.Author != "" {
+		q = q.Where("LOWER(author) LIKE ?", "%"+strings.ToLower(f.Author)+"%")
+	}
+	if f.Publication != "" {
+		q = q.Where("LOWER(publication) LIKE ?", "%"+strings.ToLower(f.Publication)+"%")
+	}
+
+	var total int64
+	q.Count(&total)
+
+	var Books []Book
+	q = q.Order("id").Offset(f.Offset)
+	if f.Limit > 0 {
+		q = q.Limit(f.Limit)
+	}
+	q.Find(&Books)
+	return Books, total
+}
+
+// Authors splits the Author field into individual names, so "Kernighan,
+// Ritchie" and "Kernighan & Ritchie" both give two authors.
+func (b *Book) Authors() []string {
+	var names []string
+	for _, name := range strings.FieldsFunc(b.Author, func(r rune) bool { return r == ',' || r == '&' }) {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// GetBooksByAuthors loads the books of several authors in a single query,
+// keyed by author name.
+func GetBooksByAuthors(ctx context.Context, names []string) map[string][]Book {
+	res := make(map[string][]Book, len(names))
+	if len(names) == 0 {
+		return res
+	}
+	for _, name := range names {
+		res[name] = []Book{}
+	}
+
+	// the ORs go in a group of their own, apart from the tenant scope
+	byAuthor := db.Where("author LIKE ?", "%"+names[0]+"%")
+	for _, name := range names[1:] {
+		byAuthor = byAuthor.Or("author LIKE ?", "%"+name+"%")
+	}
+	var Books []Book
+	db.WithContext(ctx).Model(&Book{}).Where(byAuthor).Order("id").Find(&Books)
+
+	wanted := make(map[string]bool, len(names))
+	for _, name := range names {
+		wanted[name] = true
+	}
+	for _, book := range Books {
+		for _, name := range book.Authors() {
+			if wanted[name] {
+				res[name] = append(res[name], book)
+			}
+		}
+	}
+	return res
+}
@@ -0,0 +1,526 @@
+package models_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"go-bookstore/pkg/config"
+	"go-bookstore/pkg/models"
+
+	"gorm.io/gorm"
+)
+
+// backends are the databases the models package must work on. Each opens a
+// fresh, empty database for one test; MySQL only runs when
+// BOOKSTORE_TEST_MYSQL_DSN points at a database the test may wipe.
+var backends = []struct {
+	name string
+	open func(t *testing.T) *gorm.DB
+}{
+	{"sqlite", openSQLite},
+	{"mysql", openMySQL},
+}
+
+func openSQLite(t *testing.T) *gorm.DB {
+	return openSQLiteNamed(t, t.Name())
+}
+
+func openSQLiteNamed(t *testing.T, name string) *gorm.DB {
+	dsn := fmt.Sprintf("file:%s?mode=memory&cache=shared", url.PathEscape(name))
+	d, err := config.Open("sqlite", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The in-memory database lives until its last connection is closed.
+	t.Cleanup(func() {
+		sqlDB, _ := d.DB()
+		sqlDB.Close()
+	})
+	return d
+}
+
+func openMySQL(t *testing.T) *gorm.DB {
+	dsn := os.Getenv("BOOKSTORE_TEST_MYSQL_DSN")
+	if dsn == "" {
+		t.Skip("BOOKSTORE_TEST_MYSQL_DSN not set")
+	}
+	d, err := config.Open("mysql", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = d.Migrator().DropTable(&models.Review{}, &models.WebhookDelivery{}, &models.Webhook{},
+		&models.OutboxEvent{}, &models.Book{}, &models.Tenant{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+// TestStorageContract runs every contract case on every backend.
+func TestStorageContract(t *testing.T) {
+	cases := []struct {
+		name string
+		run  func(t *testing.T)
+	}{
+		{"BookLifecycle", testBookLifecycle},
+		{"TenantIsolation", testTenantIsolation},
+		{"NoTenant", testNoTenant},
+		{"Quotas", testQuotas},
+		{"FindBooks", testFindBooks},
+		{"BooksByAuthors", testBooksByAuthors},
+		{"BooksByAuthorsIsolation", testBooksByAuthorsIsolation},
+		{"Outbox", testOutbox},
+		{"DeadLetters", testDeadLetters},
+		{"DeletedWebhook", testDeletedWebhook},
+		{"Reviews", testReviews},
+		{"Tenants", testTenants},
+	}
+
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			for _, c := range cases {
+				t.Run(c.name, func(t *testing.T) {
+					models.Setup(b.open(t))
+					c.run(t)
+				})
+			}
+		})
+	}
+}
+
+func tenantCtx(id string) context.Context {
+	return models.WithTenant(context.Background(), id)
+}
+
+func createTenant(t *testing.T, tenant models.Tenant) {
+	t.Helper()
+	if _, err := tenant.CreateTenant(); err != nil {
+		t.Fatalf("creating tenant %s: %v", tenant.ID, err)
+	}
+}
+
+func createBook(t *testing.T, ctx context.Context, name, author string) *models.Book {
+	t.Helper()
+	b, err := (&models.Book{Name: name, Author: author, Publication: "Pub"}).CreateBook(ctx)
+	if err != nil {
+		t.Fatalf("creating %q: %v", name, err)
+	}
+	return b
+}
+
+func testBookLifecycle(t *testing.T) {
+	ctx := tenantCtx(models.DefaultTenant)
+
+	b := createBook(t, ctx, "Go", "Pike")
+	if b.ID == 0 {
+		t.Fatal("created book has no id")
+	}
+
+	got, _ := models.GetBookById(ctx, int64(b.ID))
+	if got.Name != "Go" || got.Author != "Pike" || got.Publication != "Pub" {
+		t.Errorf("GetBookById = %+v", got)
+	}
+
+	updated, err := models.UpdateBook(ctx, int64(b.ID), &models.Book{Name: "Go 2"})
+	if err != nil || updated == nil || updated.Name != "Go 2" || updated.Author != "Pike" {
+		t.Errorf("UpdateBook = %+v, %v, want only the name changed", updated, err)
+	}
+	if missing, err := models.UpdateBook(ctx, 999, &models.Book{Name: "x"}); missing != nil || err != nil {
+		t.Errorf("UpdateBook of a missing book = %+v, %v, want nil", missing, err)
+	}
+
+	deleted, err := models.DeleteBook(ctx, int64(b.ID))
+	if err != nil || deleted.ID != b.ID || deleted.Name != "Go 2" {
+		t.Errorf("DeleteBook = %+v, %v, want the book as it was", deleted, err)
+	}
+	if got, _ := models.GetBookById(ctx, int64(b.ID)); got.ID != 0 {
+		t.Error("book still found after delete")
+	}
+	if n := len(models.GetAllBook(ctx)); n != 0 {
+		t.Errorf("GetAllBook after delete returned %d books", n)
+	}
+}
+
+func testTenantIsolation(t *testing.T) {
+	createTenant(t, models.Tenant{ID: "acme", Name: "Acme"})
+	acme, def := tenantCtx("acme"), tenantCtx(models.DefaultTenant)
+
+	theirs := createBook(t, acme, "Acme book", "A")
+	createBook(t, def, "Default book", "D")
+
+	if books := models.GetAllBook(def); len(books) != 1 || books[0].Name != "Default book" {
+		t.Errorf("default tenant sees %+v", books)
+	}
+	if got, _ := models.GetBookById(def, int64(theirs.ID)); got.ID != 0 {
+		t.Error("default tenant can read acme's book")
+	}
+	if updated, _ := models.UpdateBook(def, int64(theirs.ID), &models.Book{Name: "stolen"}); updated != nil {
+		t.Error("default tenant can update acme's book")
+	}
+	if deleted, _ := models.DeleteBook(def, int64(theirs.ID)); deleted.ID != 0 {
+		t.Error("default tenant can delete acme's book")
+	}
+	if got, _ := models.GetBookById(acme, int64(theirs.ID)); got.Name != "Acme book" {
+		t.Errorf("acme's book = %+v after the other tenant's attempts", got)
+	}
+}
+
+func testNoTenant(t *testing.T) {
+	createBook(t, tenantCtx(models.DefaultTenant), "Go", "Pike")
+
+	if _, tx := models.GetBookById(context.Background(), 1); tx.Error != models.ErrNoTenant {
+		t.Errorf("query without tenant: error = %v, want ErrNoTenant", tx.Error)
+	}
+	if books := models.GetAllBook(context.Background()); len(books) != 0 {
+		t.Errorf("query without tenant returned %d books", len(books))
+	}
+	if _, err := (&models.Book{Name: "x"}).CreateBook(context.Background()); err == nil {
+		t.Error("create without tenant succeeded")
+	}
+}
+
+func testQuotas(t *testing.T) {
+	createTenant(t, models.Tenant{ID: "small", MaxBooks: 1, MaxWebhooks: 1})
+	ctx := tenantCtx("small")
+
+	createBook(t, ctx, "One", "A")
+	if _, err := (&models.Book{Name: "Two"}).CreateBook(ctx); err != models.ErrQuotaExceeded {
+		t.Errorf("second book: error = %v, want ErrQuotaExceeded", err)
+	}
+	if n := len(models.GetAllBook(ctx)); n != 1 {
+		t.Errorf("tenant has %d books, want 1", n)
+	}
+
+	if _, err := (&models.Webhook{URL: "http://a.example/"}).CreateWebhook(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (&models.Webhook{URL: "http://b.example/"}).CreateWebhook(ctx); err != models.ErrQuotaExceeded {
+		t.Errorf("second webhook: error = %v, want ErrQuotaExceeded", err)
+	}
+}
+
+func testFindBooks(t *testing.T) {
+	ctx := tenantCtx(models.DefaultTenant)
+	for _, name := range []string{"Go in Action", "The Go Programming Language", "Rust in Action"} {
+		createBook(t, ctx, name, "Someone")
+	}
+
+	books, total := models.FindBooks(ctx, models.BookFilter{Name: "go"})
+	if total != 2 || len(books) != 2 {
+		t.Errorf("filter by name: %d of %d, want 2 of 2", len(books), total)
+	}
+
+	books, total = models.FindBooks(ctx, models.BookFilter{Limit: 2, Offset: 2})
+	if total != 3 || len(books) != 1 || books[0].Name != "Rust in Action" {
+		t.Errorf("second page = %+v (total %d)", books, total)
+	}
+}
+
+func testBooksByAuthors(t *testing.T) {
+	ctx := tenantCtx(models.DefaultTenant)
+	createBook(t, ctx, "K&R", "Kernighan, Ritchie")
+	createBook(t, ctx, "AWK", "Aho & Kernighan")
+
+	res := models.GetBooksByAuthors(ctx, []string{"Kernighan", "Ritchie", "Nobody"})
+	if len(res["Kernighan"]) != 2 || len(res["Ritchie"]) != 1 {
+		t.Errorf("books by author = %+v", res)
+	}
+	if books, ok := res["Nobody"]; !ok || books == nil || len(books) != 0 {
+		t.Errorf("missing author should map to an empty slice, got %#v", books)
+	}
+}
+
+func testBooksByAuthorsIsolation(t *testing.T) {
+	createTenant(t, models.Tenant{ID: "acme", Name: "Acme"})
+	acme, def := tenantCtx("acme"), tenantCtx(models.DefaultTenant)
+	createBook(t, acme, "Acme book", "Aho")
+	createBook(t, def, "Default book", "Kernighan")
+
+	// with several names the lookup is a chain of ORs, which must all stay
+	// inside the tenant
+	res := models.GetBooksByAuthors(def, []string{"Aho", "Kernighan", "Ritchie"})
+	if len(res["Aho"]) != 0 {
+		t.Errorf("default tenant sees acme's books: %+v", res["Aho"])
+	}
+	if len(res["Kernighan"]) != 1 {
+		t.Errorf("default tenant's own books = %+v", res["Kernighan"])
+	}
+}
+
+// The scope must hold whatever conditions a caller builds, not only those
+// of the package's own queries.
+func TestTenantScopeGroupsConditions(t *testing.T) {
+	d := openSQLite(t)
+	models.Setup(d)
+	createTenant(t, models.Tenant{ID: "acme", Name: "Acme"})
+	createBook(t, tenantCtx("acme"), "Acme book", "Aho")
+	createBook(t, tenantCtx(models.DefaultTenant), "Default book", "Kernighan")
+
+	var books []models.Book
+	d.WithContext(tenantCtx(models.DefaultTenant)).Where("author = ?", "Aho").Or("author = ?", "Kernighan").Find(&books)
+	if len(books) != 1 || books[0].Name != "Default book" {
+		t.Errorf("an OR query in the default tenant found %+v", books)
+	}
+}
+
+// A change whose outbox event can't be written is rolled back and its error
+// returned.
+func TestMutationErrors(t *testing.T) {
+	d := openSQLite(t)
+	models.Setup(d)
+	ctx := tenantCtx(models.DefaultTenant)
+	b := createBook(t, ctx, "Go", "Pike")
+
+	outboxDown := errors.New("outbox down")
+	d.Callback().Create().Before("gorm:create").Register("test:outbox_down", func(tx *gorm.DB) {
+		if tx.Statement.Table == "outbox_events" {
+			tx.AddError(outboxDown)
+		}
+	})
+
+	if updated, err := models.UpdateBook(ctx, int64(b.ID), &models.Book{Name: "Go 2"}); updated != nil || !errors.Is(err, outboxDown) {
+		t.Errorf("UpdateBook = %+v, %v, want %v", updated, err, outboxDown)
+	}
+	if _, err := models.DeleteBook(ctx, int64(b.ID)); !errors.Is(err, outboxDown) {
+		t.Errorf("DeleteBook: %v, want %v", err, outboxDown)
+	}
+	if got, _ := models.GetBookById(ctx, int64(b.ID)); got.Name != "Go" {
+		t.Errorf("the book is %+v after the failed changes", got)
+	}
+}
+
+func testOutbox(t *testing.T) {
+	createTenant(t, models.Tenant{ID: "acme"})
+	ctx, acme := tenantCtx(models.DefaultTenant), tenantCtx("acme")
+
+	mine, _ := (&models.Webhook{URL: "http://mine.example/"}).CreateWebhook(ctx)
+	createOnly, _ := (&models.Webhook{URL: "http://c.example/", Events: []models.EventType{models.BookCreated}}).CreateWebhook(ctx)
+	if _, err := (&models.Webhook{URL: "http://acme.example/"}).CreateWebhook(acme); err != nil {
+		t.Fatal(err)
+	}
+
+	b := createBook(t, ctx, "Go", "Pike")
+	models.UpdateBook(ctx, int64(b.ID), &models.Book{Name: "Go 2"})
+	models.DeleteBook(ctx, int64(b.ID))
+
+	all := models.AllTenants(context.Background())
+	events := models.PendingOutboxEvents(all, 10)
+	want := []models.EventType{models.BookCreated, models.BookUpdated, models.BookDeleted}
+	if len(events) != len(want) {
+		t.Fatalf("outbox has %d events, want %d", len(events), len(want))
+	}
+	for i, e := range events {
+		if e.Type != want[i] || e.BookID != b.ID {
+			t.Errorf("event %d = %s for book %d, want %s for book %d", i, e.Type, e.BookID, want[i], b.ID)
+		}
+		if err := models.DispatchOutboxEvent(all, &e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := len(models.PendingOutboxEvents(all, 10)); n != 0 {
+		t.Errorf("%d events still pending after dispatch", n)
+	}
+
+	perHook := map[uint]int{}
+	for _, d := range models.DueDeliveries(all, time.Now().Add(time.Second), 100) {
+		perHook[d.WebhookID]++
+	}
+	if perHook[mine.ID] != 3 || perHook[createOnly.ID] != 1 || len(perHook) != 2 {
+		t.Errorf("deliveries per webhook = %v, want 3 to %d and 1 to %d only", perHook, mine.ID, createOnly.ID)
+	}
+}
+
+func testDeadLetters(t *testing.T) {
+	ctx := tenantCtx(models.DefaultTenant)
+	all := models.AllTenants(context.Background())
+
+	if _, err := (&models.Webhook{URL: "http://a.example/"}).CreateWebhook(ctx); err != nil {
+		t.Fatal(err)
+	}
+	createBook(t, ctx, "Go", "Pike")
+	for _, e := range models.PendingOutboxEvents(all, 10) {
+		models.DispatchOutboxEvent(all, &e)
+	}
+
+	due := models.DueDeliveries(all, time.Now().Add(time.Second), 10)
+	if len(due) != 1 {
+		t.Fatalf("%d deliveries due, want 1", len(due))
+	}
+	due[0].MarkFailed(all, "boom", time.Now(), true)
+
+	dead := models.DeadLetters(ctx)
+	if len(dead) != 1 || dead[0].LastError != "boom" || dead[0].Attempts != 1 {
+		t.Fatalf("dead letters = %+v", dead)
+	}
+
+	retried := models.RetryDeadLetter(ctx, int64(dead[0].ID))
+	if retried == nil || retried.Status != models.DeliveryPending || retried.Attempts != 0 {
+		t.Errorf("retried delivery = %+v", retried)
+	}
+	if models.RetryDeadLetter(ctx, int64(dead[0].ID)) != nil {
+		t.Error("a pending delivery can be retried again")
+	}
+}
+
+// A deleted webhook gets no new deliveries, and its pending ones are
+// cancelled rather than retried against an empty URL.
+func testDeletedWebhook(t *testing.T) {
+	ctx := tenantCtx(models.DefaultTenant)
+	all := models.AllTenants(context.Background())
+	dispatch := func() {
+		for _, e := range models.PendingOutboxEvents(all, 10) {
+			if err := models.DispatchOutboxEvent(all, &e); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	kept, _ := (&models.Webhook{URL: "http://kept.example/"}).CreateWebhook(ctx)
+	gone, _ := (&models.Webhook{URL: "http://gone.example/"}).CreateWebhook(ctx)
+	b := createBook(t, ctx, "Go", "Pike")
+	dispatch()
+	models.DeleteWebhook(ctx, int64(gone.ID))
+	models.UpdateBook(ctx, int64(b.ID), &models.Book{Name: "Go 2"})
+	dispatch()
+
+	due := models.DueDeliveries(all, time.Now().Add(time.Second), 10)
+	if len(due) != 2 {
+		t.Fatalf("%d deliveries due, want the 2 to the kept webhook", len(due))
+	}
+	for _, d := range due {
+		if d.WebhookID != kept.ID || d.Webhook.URL != kept.URL {
+			t.Errorf("delivery %d goes to webhook %d at %q", d.ID, d.WebhookID, d.Webhook.URL)
+		}
+	}
+	if due := models.DueDeliveries(all, time.Now().Add(time.Second), 10); len(due) != 2 {
+		t.Errorf("%d deliveries due the second time, want 2", len(due))
+	}
+	if dead := models.DeadLetters(ctx); len(dead) != 0 {
+		t.Errorf("cancelled deliveries are dead letters: %+v", dead)
+	}
+}
+
+func testReviews(t *testing.T) {
+	ctx := tenantCtx(models.DefaultTenant)
+	b := createBook(t, ctx, "Go", "Pike")
+
+	if _, err := (&models.Review{Rating: 5, Author: "x"}).CreateReview(ctx, 999); err != models.ErrBookNotFound {
+		t.Errorf("review of a missing book: error = %v", err)
+	}
+
+	var ids []int64
+	for _, rating := range []int{5, 2, 1} {
+		r, err := (&models.Review{Rating: rating, Author: "x", Status: models.ReviewApproved}).CreateReview(ctx, int64(b.ID))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Status != models.ReviewPending {
+			t.Errorf("new review has status %s, want pending", r.Status)
+		}
+		ids = append(ids, int64(r.ID))
+	}
+	if page := models.GetBookReviews(ctx, int64(b.ID), 10, 0); page.TotalCount != 0 {
+		t.Errorf("pending reviews are listed: %+v", page)
+	}
+
+	models.ModerateReview(ctx, ids[0], models.ReviewApproved)
+	models.ModerateReview(ctx, ids[1], models.ReviewApproved)
+	models.ModerateReview(ctx, ids[2], models.ReviewRejected)
+
+	got, _ := models.GetBookById(ctx, int64(b.ID))
+	if got.AverageRating != 3.5 || got.ReviewCount != 2 {
+		t.Errorf("rating = %v over %d reviews, want 3.5 over 2", got.AverageRating, got.ReviewCount)
+	}
+
+	models.ModerateReview(ctx, ids[1], models.ReviewRejected)
+	got, _ = models.GetBookById(ctx, int64(b.ID))
+	if got.AverageRating != 5 || got.ReviewCount != 1 {
+		t.Errorf("after rejecting one: rating = %v over %d reviews, want 5 over 1", got.AverageRating, got.ReviewCount)
+	}
+
+	if page := models.ReviewQueue(ctx, models.ReviewRejected, 10, 0); page.TotalCount != 2 {
+		t.Errorf("rejected queue has %d reviews, want 2", page.TotalCount)
+	}
+	if r, err := models.ModerateReview(ctx, 999, models.ReviewApproved); r != nil || err != nil {
+		t.Errorf("moderating a missing review = %v, %v", r, err)
+	}
+}
+
+func testTenants(t *testing.T) {
+	createTenant(t, models.Tenant{ID: "acme", Name: "Acme", MaxBooks: 5})
+	ctx := tenantCtx("acme")
+	b := createBook(t, ctx, "Go", "Pike")
+	(&models.Webhook{URL: "http://a.example/"}).CreateWebhook(ctx)
+
+	usage := models.GetTenantUsage("acme")
+	if usage == nil || usage.Books != 1 || usage.Webhooks != 1 || usage.Tenant.MaxBooks != 5 {
+		t.Errorf("usage = %+v", usage)
+	}
+
+	// an update changes only what it sends
+	maxWebhooks := 2
+	for _, c := range []struct {
+		update models.TenantUpdate
+		want   models.Tenant
+	}{
+		{models.TenantUpdate{Name: "Acme Inc"}, models.Tenant{Name: "Acme Inc", MaxBooks: 5}},
+		{models.TenantUpdate{MaxWebhooks: &maxWebhooks}, models.Tenant{Name: "Acme Inc", MaxBooks: 5, MaxWebhooks: 2}},
+		{models.TenantUpdate{MaxBooks: new(int)}, models.Tenant{Name: "Acme Inc", MaxWebhooks: 2}},
+	} {
+		got, err := models.UpdateTenant("acme", &c.update)
+		if err != nil || got == nil || got.Name != c.want.Name || got.MaxBooks != c.want.MaxBooks || got.MaxWebhooks != c.want.MaxWebhooks {
+			t.Errorf("UpdateTenant(%+v) = %+v, %v, want %+v", c.update, got, err, c.want)
+		}
+	}
+	if got, err := models.UpdateTenant("nobody", &models.TenantUpdate{Name: "x"}); got != nil || err != nil {
+		t.Errorf("UpdateTenant of a missing tenant = %+v, %v", got, err)
+	}
+
+	if _, err := models.DeleteTenant("acme"); err != models.ErrTenantInUse {
+		t.Errorf("deleting a tenant with books: error = %v, want ErrTenantInUse", err)
+	}
+	models.DeleteBook(ctx, int64(b.ID))
+	if deleted, err := models.DeleteTenant("acme"); err != nil || deleted == nil {
+		t.Errorf("DeleteTenant = %v, %v", deleted, err)
+	}
+	if models.GetTenantById("acme") != nil {
+		t.Error("tenant still exists after delete")
+	}
+
+	if ids := tenantIDs(models.GetAllTenants()); len(ids) != 1 || ids[0] != models.DefaultTenant {
+		t.Errorf("tenants = %v, want only the default tenant", ids)
+	}
+}
+
+func tenantIDs(tenants []models.Tenant) []string {
+	var ids []string
+	for _, tenant := range tenants {
+		ids = append(ids, tenant.ID)
+	}
+	return ids
+}
+
+// A subscriber that stops reading has its channel closed once the buffer is
+// full, so it can tell that it missed changes.
+func TestSlowSubscriberIsDropped(t *testing.T) {
+	models.Setup(openSQLite(t))
+	events, cancel := models.Subscribe()
+	defer cancel()
+
+	ctx := tenantCtx(models.DefaultTenant)
+	for i := 0; i < 100; i++ {
+		createBook(t, ctx, fmt.Sprint(i), "Slow")
+	}
+	n := 0
+	for range events {
+		n++
+	}
+	if n == 0 || n >= 100 {
+		t.Errorf("the subscriber got %d events before its channel was closed", n)
+	}
+}
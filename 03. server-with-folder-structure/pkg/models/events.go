@@ -0,0 +1,73 @@
+package models
+
+import "sync"
+
+type EventType string
+
+const (
+	BookCreated EventType = "book.created"
+	BookUpdated EventType = "book.updated"
+	BookDeleted EventType = "book.deleted"
+)
+
+// BookEvent describes one change to the catalogue. For deletions Book holds
+// the book as it was just before it was deleted.
+type BookEvent struct {
+	Type EventType `json:"type"`
+	Book Book      `json:"book"`
+}
+
+var (
+	subsMu    sync.Mutex
+	subs      = map[chan BookEvent]struct{}{}
+	observers []func(BookEvent)
+)
+
+// Observe registers fn to be called with every change made through this
+// package, in order, before the change's method returns. fn runs while
+// writers wait on it, so it must be quick and must not block.
+func Observe(fn func(BookEvent)) {
+	subsMu.Lock()
+	observers = append(observers, fn)
+	subsMu.Unlock()
+}
+
+// Subscribe returns a channel that receives every change made through this
+// package, and a function that cancels the subscription. A subscriber that
+// falls more than a buffer's worth behind is dropped rather than stalling
+// writers: its channel is closed without the subscription being cancelled,
+// and it has to resynchronise.
+func Subscribe() (<-chan BookEvent, func()) {
+	ch := make(chan BookEvent, 64)
+
+	subsMu.Lock()
+	subs[ch] = struct{}{}
+	subsMu.Unlock()
+
+	return ch, func() {
+		subsMu.Lock()
+		if _, ok := subs[ch]; ok {
+			delete(subs, ch)
+			close(ch)
+		}
+		subsMu.Unlock()
+	}
+}
+
+func publish(t EventType, b Book) {
+	subsMu.Lock()
+	defer subsMu.Unlock()
+
+	e := BookEvent{Type: t, Book: b}
+	for _, fn := range observers {
+		fn(e)
+	}
+	for ch := range subs {
+		select {
+		case ch <- e:
+		default:
+			delete(subs, ch)
+			close(ch)
+		}
+	}
+}
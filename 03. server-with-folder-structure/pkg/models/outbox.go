@@ -0,0 +1,80 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// OutboxEvent is a book change waiting to be handed to the webhook
+// dispatcher. It is written in the same transaction as the change itself, so
+// an event exists if and only if the change was committed.
+type OutboxEvent struct {
+	ID           uint       `gorm:"primaryKey" json:"id"`
+	TenantID     string     `gorm:"size:64;not null;index;default:'default'" json:"-"`
+	Type         EventType  `gorm:"size:32" json:"type"`
+	BookID       uint       `json:"bookId"`
+	Payload      string     `gorm:"type:text" json:"payload"`
+	CreatedAt    time.Time  `json:"createdAt"`
+	DispatchedAt *time.Time `gorm:"index" json:"dispatchedAt"`
+}
+
+// mutate runs fn and the outbox write for the resulting event in one
+// transaction, then notifies in-process subscribers once it has committed.
+func mutate(ctx context.Context, t EventType, b *Book, fn func(tx *gorm.DB) error) error {
+	err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := fn(tx); err != nil {
+			return err
+		}
+		payload, err := json.Marshal(BookEvent{Type: t, Book: *b})
+		if err != nil {
+			return err
+		}
+		return tx.Create(&OutboxEvent{Type: t, BookID: b.ID, Payload: string(payload)}).Error
+	})
+	if err == nil {
+		publish(t, *b)
+	}
+	return err
+}
+
+// PendingOutboxEvents returns up to limit events not yet handed to the
+// dispatcher, oldest first. The dispatcher calls it with AllTenants.
+func PendingOutboxEvents(ctx context.Context, limit int) []OutboxEvent {
+	var events []OutboxEvent
+	db.WithContext(ctx).Where("dispatched_at IS NULL").Order("id").Limit(limit).Find(&events)
+	return events
+}
+
+// DispatchOutboxEvent creates a delivery of e for every active webhook of
+// e's tenant subscribed to its type and marks e as dispatched, all in one
+// transaction.
+func DispatchOutboxEvent(ctx context.Context, e *OutboxEvent) error {
+	return db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var hooks []Webhook
+		if err := tx.Where("tenant_id = ? AND active = ?", e.TenantID, true).Find(&hooks).Error; err != nil {
+			return err
+		}
+
+		now := time.Now()
+		for _, h := range hooks {
+			if !h.Subscribed(e.Type) {
+				continue
+			}
+			d := &WebhookDelivery{
+				TenantID:      e.TenantID,
+				WebhookID:     h.ID,
+				EventID:       e.ID,
+				Status:        DeliveryPending,
+				NextAttemptAt: now,
+			}
+			if err := tx.Create(d).Error; err != nil {
+				return err
+			}
+		}
+
+		return tx.Model(e).Update("dispatched_at", now).Error
+	})
+}
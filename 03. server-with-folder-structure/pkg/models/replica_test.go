@@ -0,0 +1,46 @@
+package models_test
+
+import (
+	"errors"
+	"testing"
+
+	"go-bookstore/pkg/config"
+	"go-bookstore/pkg/models"
+)
+
+// The replica here is a separate, empty database, so a read that finds the
+// book must have gone to the primary.
+func TestReadReplicaRouting(t *testing.T) {
+	replica := openSQLiteNamed(t, t.Name()+"-replica")
+	models.Setup(replica)
+	models.Setup(openSQLite(t))
+
+	set := config.NewReplicaSet(replica)
+	models.SetReplicas(set)
+	t.Cleanup(func() { models.SetReplicas(nil) })
+
+	ctx := tenantCtx(models.DefaultTenant)
+	b := createBook(t, ctx, "Go", "Pike")
+
+	if got, _ := models.GetBookById(ctx, int64(b.ID)); got.ID != 0 {
+		t.Error("read without stickiness went to the primary")
+	}
+	if got, _ := models.GetBookById(models.WithPrimary(ctx), int64(b.ID)); got.ID != b.ID {
+		t.Error("sticky read did not go to the primary")
+	}
+	if updated, _ := models.UpdateBook(ctx, int64(b.ID), &models.Book{Name: "Go 2"}); updated == nil {
+		t.Error("UpdateBook looked the book up on the replica")
+	}
+
+	set.Replicas[0].MarkDown(errors.New("test"))
+	if got, _ := models.GetBookById(ctx, int64(b.ID)); got.Name != "Go 2" {
+		t.Error("read did not fall back to the primary with no healthy replica")
+	}
+
+	sqlDB, _ := replica.DB()
+	sqlDB.Close()
+	set.Check(ctx)
+	if set.Replicas[0].Healthy() || set.Pick() != nil {
+		t.Error("closed replica is still in rotation")
+	}
+}
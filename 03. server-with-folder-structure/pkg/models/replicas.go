@@ -0,0 +1,48 @@
+package models
+
+import (
+	"context"
+	"errors"
+
+	"go-bookstore/pkg/config"
+
+	"gorm.io/gorm"
+)
+
+var replicas *config.ReplicaSet
+
+// SetReplicas sends the catalogue reads in GetAllBook and GetBookById to
+// the healthy replicas of s in turn. Writes, and everything else, stay on
+// the primary. Pass nil to read from the primary only.
+func SetReplicas(s *config.ReplicaSet) {
+	if s != nil {
+		for _, r := range s.Replicas {
+			registerTenantScope(r.DB)
+		}
+	}
+	replicas = s
+}
+
+type primaryKey struct{}
+
+// WithPrimary makes the reads done with ctx go to the primary, so that a
+// client that has just written sees its own writes despite replica lag.
+func WithPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryKey{}, true)
+}
+
+// read runs query on a replica unless ctx asks for the primary. If the
+// replica fails, it is taken out of rotation and the query is retried on
+// the primary.
+func read(ctx context.Context, query func(d *gorm.DB) *gorm.DB) *gorm.DB {
+	if ctx.Value(primaryKey{}) == nil {
+		if r := replicas.Pick(); r != nil {
+			tx := query(r.DB.WithContext(ctx))
+			if tx.Error == nil || errors.Is(tx.Error, ErrNoTenant) || errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+				return tx
+			}
+			r.MarkDown(tx.Error)
+		}
+	}
+	return query(db.WithContext(ctx))
+}
@@ -0,0 +1,142 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type ReviewStatus string
+
+const (
+	ReviewPending  ReviewStatus = "pending"
+	ReviewApproved ReviewStatus = "approved"
+	ReviewRejected ReviewStatus = "rejected"
+)
+
+var ErrBookNotFound = errors.New("book not found")
+
+// Review is a customer's rating of a book. New reviews wait in the
+// moderation queue; only approved ones are listed with the book and count
+// towards its rating.
+type Review struct {
+	ID        uint         `gorm:"primaryKey" json:"id"`
+	TenantID  string       `gorm:"size:64;not null;index;default:'default'" json:"-"`
+	BookID    uint         `gorm:"index" json:"bookId"`
+	Rating    int          `json:"rating"`
+	Text      string       `gorm:"type:text" json:"text"`
+	Author    string       `json:"author"`
+	Status    ReviewStatus `gorm:"size:16;index" json:"status"`
+	CreatedAt time.Time    `json:"createdAt"`
+	UpdatedAt time.Time    `json:"updatedAt"`
+}
+
+// ReviewPage is one page of reviews and the number of reviews on all pages.
+type ReviewPage struct {
+	Items      []Review `json:"items"`
+	TotalCount int64    `json:"totalCount"`
+	Limit      int      `json:"limit"`
+	Offset     int      `json:"offset"`
+}
+
+// Validate reports the first problem with a submitted review.
+func (r *Review) Validate() error {
+	if r.Rating < 1 || r.Rating > 5 {
+		return fmt.Errorf("rating must be between 1 and 5")
+	}
+	if strings.TrimSpace(r.Author) == "" {
+		return fmt.Errorf("author is required")
+	}
+	if len(r.Text) > 10000 {
+		return fmt.Errorf("text must be at most 10000 characters")
+	}
+	return nil
+}
+
+// CreateReview stores r as a pending review of the given book.
+func (r *Review) CreateReview(ctx context.Context, bookID int64) (*Review, error) {
+	book, _ := GetBookById(WithPrimary(ctx), bookID)
+	if book.ID == 0 {
+		return nil, ErrBookNotFound
+	}
+
+	r.ID = 0
+	r.BookID = book.ID
+	r.Status = ReviewPending
+	return r, db.WithContext(ctx).Create(r).Error
+}
+
+// GetBookReviews returns a page of the approved reviews of a book, newest
+// first.
+func GetBookReviews(ctx context.Context, bookID int64, limit, offset int) ReviewPage {
+	q := db.WithContext(ctx).Model(&Review{}).Where("book_id = ? AND status = ?", bookID, ReviewApproved)
+	return reviewPage(q, "id DESC", limit, offset)
+}
+
+// ReviewQueue returns a page of the reviews with the given status, oldest
+// first, for moderators.
+func ReviewQueue(ctx context.Context, status ReviewStatus, limit, offset int) ReviewPage {
+	q := db.WithContext(ctx).Model(&Review{}).Where("status = ?", status)
+	return reviewPage(q, "id", limit, offset)
+}
+
+func reviewPage(q *gorm.DB, order string, limit, offset int) ReviewPage {
+	page := ReviewPage{Items: []Review{}, Limit: limit, Offset: offset}
+	q.Count(&page.TotalCount)
+	q.Order(order).Limit(limit).Offset(offset).Find(&page.Items)
+	return page
+}
+
+// ModerateReview sets the status of a review and, in the same transaction,
+// recomputes the average rating and review count of its book. It returns
+// nil when there is no review with the given id.
+func ModerateReview(ctx context.Context, Id int64, status ReviewStatus) (*Review, error) {
+	var review Review
+	err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		tx.Where("id = ?", Id).Find(&review)
+		if review.ID == 0 {
+			return nil
+		}
+
+		if err := tx.Model(&review).Update("status", status).Error; err != nil {
+			return err
+		}
+		return updateRating(tx, review.BookID)
+	})
+	if err != nil || review.ID == 0 {
+		return nil, err
+	}
+	return &review, nil
+}
+
+// updateRating locks the book row, so concurrent moderations of its reviews
+// are applied one after another, and stores the aggregate of its approved
+// reviews.
+func updateRating(tx *gorm.DB, bookID uint) error {
+	var book Book
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", bookID).First(&book).Error; err != nil {
+		return err
+	}
+
+	var agg struct {
+		Average float64
+		Count   int
+	}
+	err := tx.Model(&Review{}).
+		Select("COALESCE(AVG(rating), 0) AS average, COUNT(*) AS count").
+		Where("book_id = ? AND status = ?", bookID, ReviewApproved).
+		Scan(&agg).Error
+	if err != nil {
+		return err
+	}
+
+	return tx.Model(&book).UpdateColumns(map[string]interface{}{
+		"average_rating": agg.Average,
+		"review_count":   agg.Count,
+	}).Error
+}
@@ -0,0 +1,228 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// DefaultTenant owns the books that existed before tenants were introduced,
+// and is used for requests that do not name a tenant.
+const DefaultTenant = "default"
+
+var (
+	ErrNoTenant      = errors.New("no tenant in context")
+	ErrQuotaExceeded = errors.New("tenant quota exceeded")
+	ErrTenantInUse   = errors.New("tenant still owns books")
+)
+
+// Tenant is one shop hosted on this deployment. A zero quota is unlimited.
+type Tenant struct {
+	ID          string    `gorm:"primaryKey;size:64" json:"id"`
+	Name        string    `json:"name"`
+	MaxBooks    int       `json:"maxBooks"`
+	MaxWebhooks int       `json:"maxWebhooks"`
+	CreatedAt   time.Time `json:"createdAt"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+}
+
+// TenantUpdate is the body of a tenant update. Fields left out, or null,
+// keep their stored value; a quota of 0 makes it unlimited.
+type TenantUpdate struct {
+	Name        string `json:"name"`
+	MaxBooks    *int   `json:"maxBooks"`
+	MaxWebhooks *int   `json:"maxWebhooks"`
+}
+
+// TenantUsage compares what a tenant has stored against its quotas.
+type TenantUsage struct {
+	Tenant   Tenant `json:"tenant"`
+	Books    int64  `json:"books"`
+	Webhooks int64  `json:"webhooks"`
+}
+
+type tenantKey struct{}
+
+// allTenants marks a context that may read and write every tenant's rows.
+// Only background jobs such as the webhook dispatcher should use it.
+const allTenants = "\x00all"
+
+// WithTenant scopes every query made with ctx to the given tenant.
+func WithTenant(ctx context.Context, tenantID string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenantID)
+}
+
+// AllTenants lifts tenant scoping for queries made with ctx.
+func AllTenants(ctx context.Context) context.Context {
+	return context.WithValue(ctx, tenantKey{}, allTenants)
+}
+
+// TenantFrom returns the tenant ctx is scoped to.
+func TenantFrom(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(tenantKey{}).(string)
+	if !ok || id == allTenants {
+		return "", false
+	}
+	return id, true
+}
+
+// registerTenantScope installs callbacks that confine every query on a model
+// with a TenantID field to the tenant in the statement's context, and stamp
+// that tenant on every row created. A statement on such a model without a
+// tenant in its context fails with ErrNoTenant rather than seeing all rows.
+// Registering twice on the same database is a no-op.
+func registerTenantScope(d *gorm.DB) {
+	if d.Callback().Query().Get("tenant:query") != nil {
+		return
+	}
+	d.Callback().Create().Before("gorm:create").Register("tenant:create", stampTenant)
+	d.Callback().Query().Before("gorm:query").Register("tenant:query", scopeTenant)
+	d.Callback().Update().Before("gorm:update").Register("tenant:update", scopeTenant)
+	d.Callback().Delete().Before("gorm:delete").Register("tenant:delete", scopeTenant)
+	d.Callback().Row().Before("gorm:row").Register("tenant:row", scopeTenant)
+}
+
+func scopeTenant(tx *gorm.DB) {
+	if tx.Statement.Schema == nil {
+		return
+	}
+	field := tx.Statement.Schema.LookUpField("TenantID")
+	if field == nil {
+		return
+	}
+
+	id, _ := tx.Statement.Context.Value(tenantKey{}).(string)
+	switch id {
+	case "":
+		tx.AddError(ErrNoTenant)
+	case allTenants:
+	default:
+		scope := clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: field.DBName}, Value: id}
+		c, ok := tx.Statement.Clauses["WHERE"]
+		where, _ := c.Expression.(clause.Where)
+		if !ok || len(where.Exprs) == 0 {
+			tx.Statement.AddClause(clause.Where{Exprs: []clause.Expression{scope}})
+			return
+		}
+		// Group the conditions already there, so that an OR among them
+		// can't reach past the tenant: "a OR b AND tenant" would.
+		where.Exprs = []clause.Expression{clause.And(where.Exprs...), scope}
+		c.Expression = where
+		tx.Statement.Clauses["WHERE"] = c
+	}
+}
+
+func stampTenant(tx *gorm.DB) {
+	if tx.Statement.Schema == nil {
+		return
+	}
+	field := tx.Statement.Schema.LookUpField("TenantID")
+	if field == nil {
+		return
+	}
+
+	id, _ := tx.Statement.Context.Value(tenantKey{}).(string)
+	switch id {
+	case "":
+		tx.AddError(ErrNoTenant)
+	case allTenants:
+		// Background jobs set TenantID themselves.
+	default:
+		tx.Statement.SetColumn(field.Name, id, true)
+	}
+}
+
+func GetAllTenants() []Tenant {
+	var tenants []Tenant
+	db.Order("id").Find(&tenants)
+	return tenants
+}
+
+func GetTenantById(Id string) *Tenant {
+	var tenant Tenant
+	db.Where("id = ?", Id).Find(&tenant)
+	if tenant.ID == "" {
+		return nil
+	}
+	return &tenant
+}
+
+func (t *Tenant) CreateTenant() (*Tenant, error) {
+	return t, db.Create(t).Error
+}
+
+// UpdateTenant copies the fields that are set in update onto the stored
+// tenant. It returns nil when no tenant has the given id.
+func UpdateTenant(Id string, update *TenantUpdate) (*Tenant, error) {
+	tenant := GetTenantById(Id)
+	if tenant == nil {
+		return nil, nil
+	}
+
+	if update.Name != "" {
+		tenant.Name = update.Name
+	}
+	if update.MaxBooks != nil {
+		tenant.MaxBooks = *update.MaxBooks
+	}
+	if update.MaxWebhooks != nil {
+		tenant.MaxWebhooks = *update.MaxWebhooks
+	}
+
+	if err := db.Save(tenant).Error; err != nil {
+		return nil, err
+	}
+	return tenant, nil
+}
+
+// DeleteTenant removes a tenant that no longer owns any books.
+func DeleteTenant(Id string) (*Tenant, error) {
+	tenant := GetTenantById(Id)
+	if tenant == nil {
+		return nil, nil
+	}
+
+	var books int64
+	db.WithContext(WithTenant(context.Background(), Id)).Model(&Book{}).Count(&books)
+	if books > 0 {
+		return nil, ErrTenantInUse
+	}
+
+	db.Delete(tenant)
+	return tenant, nil
+}
+
+func GetTenantUsage(Id string) *TenantUsage {
+	tenant := GetTenantById(Id)
+	if tenant == nil {
+		return nil
+	}
+
+	ctx := WithTenant(context.Background(), Id)
+	usage := &TenantUsage{Tenant: *tenant}
+	db.WithContext(ctx).Model(&Book{}).Count(&usage.Books)
+	db.WithContext(ctx).Model(&Webhook{}).Count(&usage.Webhooks)
+	return usage
+}
+
+// lockTenant loads the context's tenant inside tx and locks its row, so
+// that concurrent creates for one tenant are checked against its quota one
+// at a time.
+func lockTenant(tx *gorm.DB) (*Tenant, error) {
+	id, ok := TenantFrom(tx.Statement.Context)
+	if !ok {
+		return nil, ErrNoTenant
+	}
+
+	var tenant Tenant
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", id).First(&tenant).Error
+	return &tenant, err
+}
+
+// ensureDefaultTenant creates DefaultTenant if it does not exist yet.
+func ensureDefaultTenant() {
+	db.Clauses(clause.OnConflict{DoNothing: true}).Create(&Tenant{ID: DefaultTenant, Name: "Default"})
+}
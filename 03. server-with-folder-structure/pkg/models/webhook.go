@@ -0,0 +1,236 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Webhook is a subscription to book change events. Events lists the event
+// types to deliver; an empty list means all of them. Active is a pointer so
+// that requests can leave it out; it defaults to true. Secret is only sent
+// back in the response that created the webhook; see Redacted.
+type Webhook struct {
+	gorm.Model
+	TenantID string      `gorm:"size:64;not null;index;default:'default'" json:"-"`
+	URL      string      `json:"url"`
+	Secret   string      `json:"secret,omitempty"`
+	Events   []EventType `gorm:"serializer:json" json:"events"`
+	Active   *bool       `json:"active"`
+}
+
+type DeliveryStatus string
+
+const (
+	DeliveryPending   DeliveryStatus = "pending"
+	DeliveryDelivered DeliveryStatus = "delivered"
+	DeliveryDead      DeliveryStatus = "dead"
+	// DeliveryCancelled deliveries were pending when their webhook was
+	// deleted.
+	DeliveryCancelled DeliveryStatus = "cancelled"
+)
+
+// WebhookDelivery tracks one event on its way to one webhook. Deliveries
+// that run out of attempts stay in the table with status "dead"; together
+// they form the dead-letter list.
+type WebhookDelivery struct {
+	ID            uint           `gorm:"primaryKey" json:"id"`
+	TenantID      string         `gorm:"size:64;not null;index;default:'default'" json:"-"`
+	WebhookID     uint           `gorm:"index" json:"webhookId"`
+	Webhook       Webhook        `json:"-"`
+	EventID       uint           `json:"eventId"`
+	Event         OutboxEvent    `json:"event"`
+	Status        DeliveryStatus `gorm:"size:16;index" json:"status"`
+	Attempts      int            `json:"attempts"`
+	NextAttemptAt time.Time      `gorm:"index" json:"nextAttemptAt"`
+	LastError     string         `json:"lastError"`
+	CreatedAt     time.Time      `json:"createdAt"`
+	UpdatedAt     time.Time      `json:"updatedAt"`
+}
+
+// Redacted returns h without its signing secret, for every response but
+// the one to the request that created it.
+func (h Webhook) Redacted() Webhook {
+	h.Secret = ""
+	return h
+}
+
+func (h *Webhook) Subscribed(t EventType) bool {
+	if len(h.Events) == 0 {
+		return true
+	}
+	for _, e := range h.Events {
+		if e == t {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateWebhook stores h for the tenant in ctx, generating a signing secret
+// if none was given. It fails with ErrQuotaExceeded when the tenant already
+// has as many webhooks as it is allowed.
+func (h *Webhook) CreateWebhook(ctx context.Context) (*Webhook, error) {
+	if h.Active == nil {
+		active := true
+		h.Active = &active
+	}
+	if h.Secret == "" {
+		buf := make([]byte, 32)
+		rand.Read(buf)
+		h.Secret = hex.EncodeToString(buf)
+	}
+	err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		tenant, err := lockTenant(tx)
+		if err != nil {
+			return err
+		}
+		if tenant.MaxWebhooks > 0 {
+			var count int64
+			tx.Model(&Webhook{}).Count(&count)
+			if count >= int64(tenant.MaxWebhooks) {
+				return ErrQuotaExceeded
+			}
+		}
+		return tx.Create(h).Error
+	})
+	return h, err
+}
+
+func GetAllWebhooks(ctx context.Context) []Webhook {
+	var hooks []Webhook
+	db.WithContext(ctx).Find(&hooks)
+	return hooks
+}
+
+func GetWebhookById(ctx context.Context, Id int64) *Webhook {
+	var hook Webhook
+	db.WithContext(ctx).Where("ID=?", Id).Find(&hook)
+	if hook.ID == 0 {
+		return nil
+	}
+	return &hook
+}
+
+// UpdateWebhook copies the fields that are set in update onto the stored
+// webhook. It returns nil when no webhook has the given id.
+func UpdateWebhook(ctx context.Context, Id int64, update *Webhook) *Webhook {
+	hook := GetWebhookById(ctx, Id)
+	if hook == nil {
+		return nil
+	}
+
+	if update.URL != "" {
+		hook.URL = update.URL
+	}
+	if update.Secret != "" {
+		hook.Secret = update.Secret
+	}
+	if update.Events != nil {
+		hook.Events = update.Events
+	}
+	if update.Active != nil {
+		hook.Active = update.Active
+	}
+
+	db.WithContext(ctx).Save(hook)
+	return hook
+}
+
+func DeleteWebhook(ctx context.Context, Id int64) Webhook {
+	var hook Webhook
+	db.WithContext(ctx).Where("ID=?", Id).Find(&hook)
+	if hook.ID != 0 {
+		db.WithContext(ctx).Delete(&hook)
+	}
+	return hook
+}
+
+// DueDeliveries returns up to limit pending deliveries whose next attempt is
+// due, with their webhook and event loaded. Deliveries to webhooks deleted
+// since are cancelled instead of returned. The dispatcher calls it with
+// AllTenants.
+func DueDeliveries(ctx context.Context, now time.Time, limit int) []WebhookDelivery {
+	var deliveries []WebhookDelivery
+	db.WithContext(ctx).Preload("Webhook").Preload("Event").
+		Where("status = ? AND next_attempt_at <= ?", DeliveryPending, now).
+		Order("next_attempt_at").Limit(limit).Find(&deliveries)
+
+	// Preload leaves out soft-deleted webhooks, so theirs come back empty.
+	due := deliveries[:0]
+	for _, d := range deliveries {
+		if d.Webhook.ID == 0 {
+			d.cancel(ctx, "webhook deleted")
+			continue
+		}
+		due = append(due, d)
+	}
+	return due
+}
+
+func (d *WebhookDelivery) cancel(ctx context.Context, reason string) {
+	d.Status = DeliveryCancelled
+	d.LastError = reason
+	db.WithContext(ctx).Model(&WebhookDelivery{ID: d.ID}).Updates(map[string]interface{}{
+		"status":     d.Status,
+		"last_error": d.LastError,
+	})
+}
+
+func (d *WebhookDelivery) MarkDelivered(ctx context.Context) {
+	d.Attempts++
+	d.Status = DeliveryDelivered
+	d.LastError = ""
+	db.WithContext(ctx).Model(&WebhookDelivery{ID: d.ID}).Updates(map[string]interface{}{
+		"attempts":   d.Attempts,
+		"status":     d.Status,
+		"last_error": d.LastError,
+	})
+}
+
+// MarkFailed records a failed attempt and schedules the next one at next, or
+// moves the delivery to the dead-letter list when dead is true.
+func (d *WebhookDelivery) MarkFailed(ctx context.Context, reason string, next time.Time, dead bool) {
+	d.Attempts++
+	d.LastError = reason
+	d.NextAttemptAt = next
+	if dead {
+		d.Status = DeliveryDead
+	}
+	db.WithContext(ctx).Model(&WebhookDelivery{ID: d.ID}).Updates(map[string]interface{}{
+		"attempts":        d.Attempts,
+		"status":          d.Status,
+		"last_error":      d.LastError,
+		"next_attempt_at": d.NextAttemptAt,
+	})
+}
+
+// DeadLetters returns the deliveries that gave up, newest first.
+func DeadLetters(ctx context.Context) []WebhookDelivery {
+	var deliveries []WebhookDelivery
+	db.WithContext(ctx).Preload("Event").Where("status = ?", DeliveryDead).Order("updated_at DESC").Find(&deliveries)
+	return deliveries
+}
+
+// RetryDeadLetter puts a dead delivery back in the queue with a fresh set of
+// attempts. It returns nil when there is no dead delivery with the given id.
+func RetryDeadLetter(ctx context.Context, Id int64) *WebhookDelivery {
+	var d WebhookDelivery
+	db.WithContext(ctx).Preload("Event").Where("id = ? AND status = ?", Id, DeliveryDead).Find(&d)
+	if d.ID == 0 {
+		return nil
+	}
+
+	d.Status = DeliveryPending
+	d.Attempts = 0
+	d.NextAttemptAt = time.Now()
+	db.WithContext(ctx).Model(&WebhookDelivery{ID: d.ID}).Updates(map[string]interface{}{
+		"attempts":        d.Attempts,
+		"status":          d.Status,
+		"next_attempt_at": d.NextAttemptAt,
+	})
+	return &d
+}
@@ -0,0 +1,44 @@
+package openapi
+
+import (
+	"embed"
+	"mime"
+	"net/http"
+	"path"
+
+	"github.com/gorilla/mux"
+)
+
+//go:generate ./fetch-swagger-ui.sh
+
+//go:embed static/docs.html
+var docsPage []byte
+
+// The swagger-ui-dist files the docs page loads, vendored so that it works
+// offline and runs no code from a CDN.
+//
+//go:embed static/swagger-ui
+var swaggerUI embed.FS
+
+// DocsHandler serves a Swagger UI page that renders /openapi.json.
+func DocsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(docsPage)
+}
+
+// AssetHandler serves the Swagger UI script or stylesheet named by the
+// file route variable.
+func AssetHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["file"]
+	ext := path.Ext(name)
+	data, err := swaggerUI.ReadFile("static/swagger-ui/" + name)
+	if err != nil || (ext != ".js" && ext != ".css") {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", mime.TypeByExtension(ext))
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
@@ -0,0 +1,16 @@
+#!/bin/sh
+# Vendors the swagger-ui-dist release named in static/swagger-ui/VERSION
+# into static/swagger-ui, where DocsHandler and AssetHandler serve it from
+# the binary. Run through go generate ./pkg/openapi after changing VERSION,
+# and commit the result.
+set -eu
+cd "$(dirname "$0")/static/swagger-ui"
+
+version=$(cat VERSION)
+tmp=$(mktemp -d)
+trap 'rm -rf "$tmp"' EXIT
+
+curl -fsSL "https://registry.npmjs.org/swagger-ui-dist/-/swagger-ui-dist-$version.tgz" | tar -xz -C "$tmp"
+for f in swagger-ui.css swagger-ui-bundle.js LICENSE; do
+	cp "$tmp/package/$f" .
+done
@@ -0,0 +1,260 @@
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// Operation documents one method on one route. Request and Response are
+// sample values (e.g. models.Book{} or []models.Book{}) whose types are
+// reflected into JSON schemas; leave them nil when there is no body.
+// Response goes with Status; Errors describes every other status the
+// operation can answer with.
+type Operation struct {
+	Summary     string
+	Tags        []string
+	Request     interface{}
+	Response    interface{}
+	Status      int               // status of a successful response, 200 if zero
+	Errors      map[int]string    // description of each other status
+	ContentType string            // response content type, application/json if empty
+	PathTypes   map[string]string // schema type of each path variable, string if missing
+	Query       []Param
+	Headers     []Param
+}
+
+// Seen is a response that a route was observed to give, for Check.
+type Seen struct {
+	Method string
+	Path   string // the route's path template
+	Status int
+}
+
+// Param documents an optional query or header parameter.
+type Param struct {
+	Name        string
+	Type        string
+	Description string
+}
+
+// Operations maps "METHOD /path/template" to its documentation. The path is
+// the mux path template exactly as it was registered.
+type Operations map[string]Operation
+
+type Document struct {
+	OpenAPI    string                           `json:"openapi"`
+	Info       Info                             `json:"info"`
+	Paths      map[string]map[string]*operation `json:"paths"`
+	Components Components                       `json:"components"`
+}
+
+type Info struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type Components struct {
+	Schemas map[string]*Schema `json:"schemas"`
+}
+
+type operation struct {
+	Summary     string               `json:"summary,omitempty"`
+	OperationID string               `json:"operationId"`
+	Tags        []string             `json:"tags,omitempty"`
+	Parameters  []parameter          `json:"parameters,omitempty"`
+	RequestBody *body                `json:"requestBody,omitempty"`
+	Responses   map[string]*response `json:"responses"`
+}
+
+type parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required"`
+	Schema      *Schema `json:"schema"`
+}
+
+type body struct {
+	Required bool                 `json:"required"`
+	Content  map[string]mediaType `json:"content"`
+}
+
+type response struct {
+	Description string               `json:"description"`
+	Content     map[string]mediaType `json:"content,omitempty"`
+}
+
+type mediaType struct {
+	Schema *Schema `json:"schema,omitempty"`
+}
+
+var pathVar = regexp.MustCompile(`\{([^}:]+)(?::[^}]*)?\}`)
+
+// Build generates an OpenAPI 3.1 document from the routes registered on
+// router. Routes without an entry in ops are left out; use Check to find them.
+func Build(router *mux.Router, info Info, ops Operations) *Document {
+	doc := &Document{
+		OpenAPI:    "3.1.0",
+		Info:       info,
+		Paths:      map[string]map[string]*operation{},
+		Components: Components{Schemas: map[string]*Schema{}},
+	}
+	gen := &generator{schemas: doc.Components.Schemas}
+
+	walkRoutes(router, func(method, path string) {
+		op, ok := ops[method+" "+path]
+		if !ok {
+			return
+		}
+		if doc.Paths[path] == nil {
+			doc.Paths[path] = map[string]*operation{}
+		}
+		doc.Paths[path][strings.ToLower(method)] = gen.operation(method, path, op)
+	})
+	return doc
+}
+
+// Check reports every route that has no documentation, every documented
+// operation that no longer has a route and every status in seen that its
+// operation doesn't document.
+func Check(router *mux.Router, ops Operations, seen ...Seen) error {
+	var problems []string
+	routed := map[string]bool{}
+	walkRoutes(router, func(method, path string) {
+		key := method + " " + path
+		routed[key] = true
+		if _, ok := ops[key]; !ok {
+			problems = append(problems, "undocumented route "+key)
+		}
+	})
+	for key := range ops {
+		if !routed[key] {
+			problems = append(problems, "documented operation has no route: "+key)
+		}
+	}
+	reported := map[Seen]bool{}
+	for _, s := range seen {
+		op, ok := ops[s.Method+" "+s.Path]
+		if !ok || op.status() == s.Status || op.Errors[s.Status] != "" || reported[s] {
+			continue
+		}
+		reported[s] = true
+		problems = append(problems, fmt.Sprintf("undocumented status %d of %s %s", s.Status, s.Method, s.Path))
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	sort.Strings(problems)
+	return fmt.Errorf("openapi: spec and routes have drifted:\n\t%s", strings.Join(problems, "\n\t"))
+}
+
+// Handler serves the document as JSON. The router is walked on every request
+// so the spec always reflects what is actually registered.
+func Handler(router *mux.Router, info Info, ops Operations) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		res, err := json.Marshal(Build(router, info, ops))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(res)
+	}
+}
+
+func walkRoutes(router *mux.Router, fn func(method, path string)) {
+	router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return nil
+		}
+		for _, m := range methods {
+			fn(m, path)
+		}
+		return nil
+	})
+}
+
+type generator struct {
+	schemas map[string]*Schema
+}
+
+func (g *generator) operation(method, path string, op Operation) *operation {
+	o := &operation{
+		Summary:     op.Summary,
+		OperationID: operationID(method, path),
+		Tags:        op.Tags,
+		Responses:   map[string]*response{},
+	}
+
+	for _, m := range pathVar.FindAllStringSubmatch(path, -1) {
+		typ := op.PathTypes[m[1]]
+		if typ == "" {
+			typ = "string"
+		}
+		o.Parameters = append(o.Parameters, parameter{
+			Name:     m[1],
+			In:       "path",
+			Required: true,
+			Schema:   &Schema{Type: typ},
+		})
+	}
+	for _, p := range op.Query {
+		o.Parameters = append(o.Parameters, parameter{Name: p.Name, In: "query", Description: p.Description, Schema: &Schema{Type: p.Type}})
+	}
+	for _, p := range op.Headers {
+		o.Parameters = append(o.Parameters, parameter{Name: p.Name, In: "header", Description: p.Description, Schema: &Schema{Type: p.Type}})
+	}
+
+	if op.Request != nil {
+		o.RequestBody = &body{
+			Required: true,
+			Content:  map[string]mediaType{"application/json": {Schema: g.schemaFor(op.Request)}},
+		}
+	}
+
+	res := &response{Description: http.StatusText(op.status())}
+	if op.Response != nil {
+		ct := op.ContentType
+		if ct == "" {
+			ct = "application/json"
+		}
+		res.Content = map[string]mediaType{ct: {Schema: g.schemaFor(op.Response)}}
+	}
+	o.Responses[strconv.Itoa(op.status())] = res
+	for status, desc := range op.Errors {
+		o.Responses[strconv.Itoa(status)] = &response{Description: desc}
+	}
+	return o
+}
+
+func (op Operation) status() int {
+	if op.Status == 0 {
+		return http.StatusOK
+	}
+	return op.Status
+}
+
+// operationID turns "GET /book/{bookId}" into "getBookBookId".
+func operationID(method, path string) string {
+	var b strings.Builder
+	b.WriteString(strings.ToLower(method))
+	for _, part := range strings.FieldsFunc(pathVar.ReplaceAllString(path, "$1"), func(r rune) bool {
+		return r == '/' || r == '.' || r == '-' || r == '_'
+	}) {
+		b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+	}
+	return b.String()
+}
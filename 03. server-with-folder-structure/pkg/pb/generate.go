@@ -0,0 +1,4 @@
+// Package pb holds the Go code generated from the .proto files in /proto.
+package pb
+
+//go:generate protoc -I ../../proto -I ../../third_party/googleapis --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative --grpc-gateway_out=. --grpc-gateway_opt=paths=source_relative bookstore/v1/book.proto
@@ -0,0 +1,327 @@
+package routes
+
+import (
+	"net/http"
+
+	"go-bookstore/pkg/models"
+	"go-bookstore/pkg/openapi"
+)
+
+var apiInfo = openapi.Info{Title: "Bookstore API", Version: "1.0.0"}
+
+// bookStoreSpec documents every route registered in RegisterBookStoreRoutes.
+// The keys must match the registered method and path template exactly; the
+// routes test fails when the two drift apart.
+var bookStoreSpec = openapi.Operations{
+	"POST /book/": {
+		Summary:  "Create a book",
+		Tags:     []string{"books"},
+		Request:  models.Book{},
+		Response: models.Book{},
+		Errors:   errs(tenantErrors, quotaError, storeError),
+		Headers:  tenantHeaders,
+	},
+	"GET /book/": {
+		Summary:  "List all books",
+		Tags:     []string{"books"},
+		Response: []models.Book{},
+		Errors:   tenantErrors,
+		Headers:  tenantHeaders,
+	},
+	"GET /book/stream": {
+		Summary:     "Server-sent events for every book change; resume with Last-Event-ID",
+		Tags:        []string{"books"},
+		Response:    "",
+		ContentType: "text/event-stream",
+		Errors:      tenantErrors,
+		Query:       streamFilters,
+		Headers: append([]openapi.Param{
+			{Name: "Last-Event-ID", Type: "integer", Description: "Replay the buffered events after this one"},
+		}, tenantHeaders...),
+	},
+	"GET /book/ws": {
+		Summary: "WebSocket feed of book changes, one JSON event per message",
+		Tags:    []string{"books"},
+		Status:  http.StatusSwitchingProtocols,
+		Errors: errs(tenantErrors, map[int]string{
+			400: "Not a WebSocket handshake",
+			403: "The Origin header names another host",
+		}),
+		Query: append([]openapi.Param{
+			{Name: "lastEventId", Type: "integer", Description: "Replay the buffered events after this one"},
+		}, streamFilters...),
+		Headers: tenantHeaders,
+	},
+	"GET /book/{bookId}": {
+		Summary:   "Get a book by id",
+		Tags:      []string{"books"},
+		Response:  models.Book{},
+		Errors:    tenantErrors,
+		PathTypes: map[string]string{"bookId": "integer"},
+		Headers:   tenantHeaders,
+	},
+	"PUT /book/{bookId}": {
+		Summary:   "Update the non-empty fields of a book, and its stock if given",
+		Tags:      []string{"books"},
+		Request:   models.Book{},
+		Response:  models.Book{},
+		Errors:    errs(tenantErrors, storeError),
+		PathTypes: map[string]string{"bookId": "integer"},
+		Headers:   tenantHeaders,
+	},
+	"DELETE /book/{bookId}": {
+		Summary:   "Delete a book",
+		Tags:      []string{"books"},
+		Response:  models.Book{},
+		Errors:    errs(tenantErrors, storeError),
+		PathTypes: map[string]string{"bookId": "integer"},
+		Headers:   tenantHeaders,
+	},
+	"GET /book/{bookId}/reviews": {
+		Summary:   "List the approved reviews of a book, newest first",
+		Tags:      []string{"reviews"},
+		Response:  models.ReviewPage{},
+		Errors:    tenantErrors,
+		PathTypes: map[string]string{"bookId": "integer"},
+		Query:     pageParams,
+		Headers:   tenantHeaders,
+	},
+	"POST /book/{bookId}/reviews": {
+		Summary:  "Submit a review (rating 1-5) for moderation",
+		Tags:     []string{"reviews"},
+		Request:  models.Review{},
+		Response: models.Review{},
+		Errors: errs(tenantErrors, map[int]string{
+			400: "Rating not between 1 and 5, or no author",
+			404: "No such book",
+		}, storeError),
+		PathTypes: map[string]string{"bookId": "integer"},
+		Headers:   tenantHeaders,
+	},
+	"GET /reviews/": {
+		Summary:  "Moderation queue: reviews with the given status, pending by default (admin)",
+		Tags:     []string{"reviews"},
+		Response: models.ReviewPage{},
+		Errors:   errs(tenantErrors, adminErrors, map[int]string{400: "Unknown status"}),
+		Query: append([]openapi.Param{
+			{Name: "status", Type: "string", Description: "pending, approved or rejected"},
+		}, pageParams...),
+		Headers: append(tenantHeaders, adminHeaders...),
+	},
+	"PUT /reviews/{reviewId}": {
+		Summary:  "Approve or reject a review and update the book's rating (admin)",
+		Tags:     []string{"reviews"},
+		Request:  reviewModeration{},
+		Response: models.Review{},
+		Errors: errs(tenantErrors, adminErrors, map[int]string{
+			400: "Unknown status",
+			404: "No such review",
+		}, storeError),
+		PathTypes: map[string]string{"reviewId": "integer"},
+		Headers:   append(tenantHeaders, adminHeaders...),
+	},
+	"POST /webhooks/": {
+		Summary:  "Subscribe a URL to book.created, book.updated and book.deleted events",
+		Tags:     []string{"webhooks"},
+		Request:  models.Webhook{},
+		Response: models.Webhook{},
+		Errors:   errs(tenantErrors, urlError, quotaError, storeError),
+		Headers:  tenantHeaders,
+	},
+	"GET /webhooks/": {
+		Summary:  "List webhook subscriptions",
+		Tags:     []string{"webhooks"},
+		Response: []models.Webhook{},
+		Errors:   tenantErrors,
+		Headers:  tenantHeaders,
+	},
+	"GET /webhooks/dead-letters": {
+		Summary:  "List deliveries that ran out of retries",
+		Tags:     []string{"webhooks"},
+		Response: []models.WebhookDelivery{},
+		Errors:   tenantErrors,
+		Headers:  tenantHeaders,
+	},
+	"POST /webhooks/dead-letters/{deliveryId}/retry": {
+		Summary:   "Queue a dead-lettered delivery again",
+		Tags:      []string{"webhooks"},
+		Response:  models.WebhookDelivery{},
+		Errors:    errs(tenantErrors, map[int]string{404: "No such dead letter"}),
+		PathTypes: map[string]string{"deliveryId": "integer"},
+		Headers:   tenantHeaders,
+	},
+	"GET /webhooks/{webhookId}": {
+		Summary:   "Get a webhook subscription",
+		Tags:      []string{"webhooks"},
+		Response:  models.Webhook{},
+		Errors:    errs(tenantErrors, webhookNotFound),
+		PathTypes: map[string]string{"webhookId": "integer"},
+		Headers:   tenantHeaders,
+	},
+	"PUT /webhooks/{webhookId}": {
+		Summary:   "Update the fields of a webhook subscription that are set",
+		Tags:      []string{"webhooks"},
+		Request:   models.Webhook{},
+		Response:  models.Webhook{},
+		Errors:    errs(tenantErrors, urlError, webhookNotFound),
+		PathTypes: map[string]string{"webhookId": "integer"},
+		Headers:   tenantHeaders,
+	},
+	"DELETE /webhooks/{webhookId}": {
+		Summary:   "Delete a webhook subscription",
+		Tags:      []string{"webhooks"},
+		Response:  models.Webhook{},
+		Errors:    tenantErrors,
+		PathTypes: map[string]string{"webhookId": "integer"},
+		Headers:   tenantHeaders,
+	},
+	"GET /graphql": {
+		Summary:  "Run a GraphQL query given in the query, variables and operationName parameters",
+		Tags:     []string{"graphql"},
+		Response: graphQLResponse{},
+		Errors: errs(tenantErrors, map[int]string{
+			400: "Variables that are not a JSON object",
+			405: "The query runs a mutation",
+		}),
+		Headers: tenantHeaders,
+	},
+	"POST /graphql": {
+		Summary:  "Run a GraphQL query or mutation",
+		Tags:     []string{"graphql"},
+		Request:  graphQLRequest{},
+		Response: graphQLResponse{},
+		Errors:   errs(tenantErrors, map[int]string{400: "Not a GraphQL request"}),
+		Headers:  tenantHeaders,
+	},
+	"POST /tenants/": {
+		Summary:  "Create a tenant (admin)",
+		Tags:     []string{"tenants"},
+		Request:  models.Tenant{},
+		Response: models.Tenant{},
+		Errors: errs(adminErrors, map[int]string{
+			400: "No id",
+			409: "A tenant with this id exists",
+		}, storeError),
+		Headers: adminHeaders,
+	},
+	"GET /tenants/": {
+		Summary:  "List tenants (admin)",
+		Tags:     []string{"tenants"},
+		Response: []models.Tenant{},
+		Errors:   adminErrors,
+		Headers:  adminHeaders,
+	},
+	"GET /tenants/{tenantId}": {
+		Summary:  "Get a tenant (admin)",
+		Tags:     []string{"tenants"},
+		Response: models.Tenant{},
+		Errors:   errs(adminErrors, tenantNotFound),
+		Headers:  adminHeaders,
+	},
+	"PUT /tenants/{tenantId}": {
+		Summary:  "Rename a tenant or change its quotas; a zero quota is unlimited (admin)",
+		Tags:     []string{"tenants"},
+		Request:  models.TenantUpdate{},
+		Response: models.Tenant{},
+		Errors:   errs(adminErrors, tenantNotFound, storeError),
+		Headers:  adminHeaders,
+	},
+	"DELETE /tenants/{tenantId}": {
+		Summary:  "Delete a tenant that has no books left (admin)",
+		Tags:     []string{"tenants"},
+		Response: models.Tenant{},
+		Errors:   errs(adminErrors, tenantNotFound, map[int]string{409: "The tenant still owns books"}, storeError),
+		Headers:  adminHeaders,
+	},
+	"GET /tenants/{tenantId}/usage": {
+		Summary:  "Compare a tenant's books and webhooks with its quotas (admin)",
+		Tags:     []string{"tenants"},
+		Response: models.TenantUsage{},
+		Errors:   errs(adminErrors, tenantNotFound),
+		Headers:  adminHeaders,
+	},
+	"GET /openapi.json": {
+		Summary:  "This OpenAPI document",
+		Tags:     []string{"docs"},
+		Response: map[string]interface{}{},
+	},
+	"GET /docs": {
+		Summary:     "Swagger UI for this API",
+		Tags:        []string{"docs"},
+		Response:    "",
+		ContentType: "text/html",
+	},
+	"GET /docs/swagger-ui/{file}": {
+		Summary:     "A script (text/javascript) or stylesheet (text/css) of the Swagger UI",
+		Tags:        []string{"docs"},
+		Response:    "",
+		ContentType: "text/css",
+		Errors:      map[int]string{404: "No such file"},
+	},
+}
+
+var tenantHeaders = []openapi.Param{
+	{Name: "X-Tenant-ID", Type: "string", Description: "Tenant to act for. Where bearer tokens are required it must agree with the token's tenant claim, and only the admin token may name any tenant. Otherwise, without it, the subdomain or \"default\" is used"},
+}
+
+// Statuses that several operations share. errs combines them.
+var (
+	tenantErrors = map[int]string{
+		401: "Bearer token missing or invalid where tokens are required",
+		403: "X-Tenant-ID does not match the token's tenant",
+		404: "Unknown tenant",
+	}
+	adminErrors = map[int]string{
+		401: "Admin token missing or wrong",
+		403: "Admin API disabled: BOOKSTORE_ADMIN_TOKEN is not set",
+	}
+	quotaError      = map[int]string{403: "The tenant's quota is used up"}
+	urlError        = map[int]string{400: "URL not http(s) or not public"}
+	storeError      = map[int]string{500: "The change could not be stored"}
+	webhookNotFound = map[int]string{404: "No such webhook"}
+	tenantNotFound  = map[int]string{404: "No such tenant"}
+)
+
+// errs merges sets of statuses, joining the descriptions of a status that
+// is in more than one.
+func errs(sets ...map[int]string) map[int]string {
+	merged := map[int]string{}
+	for _, set := range sets {
+		for status, desc := range set {
+			if merged[status] != "" {
+				desc = merged[status] + ". " + desc
+			}
+			merged[status] = desc
+		}
+	}
+	return merged
+}
+
+var adminHeaders = []openapi.Param{
+	{Name: "Authorization", Type: "string", Description: "Bearer BOOKSTORE_ADMIN_TOKEN"},
+}
+
+var pageParams = []openapi.Param{
+	{Name: "limit", Type: "integer", Description: "Page size, 20 by default and at most 100"},
+	{Name: "offset", Type: "integer", Description: "Number of items to skip"},
+}
+
+var streamFilters = []openapi.Param{
+	{Name: "author", Type: "string", Description: "Only books whose author contains this text"},
+	{Name: "publication", Type: "string", Description: "Only books whose publication contains this text"},
+}
+
+type reviewModeration struct {
+	Status models.ReviewStatus `json:"status"`
+}
+
+type graphQLRequest struct {
+	Query         string                 `json:"query"`
+	Variables     map[string]interface{} `json:"variables"`
+	OperationName string                 `json:"operationName"`
+}
+
+type graphQLResponse struct {
+	Data   map[string]interface{}   `json:"data"`
+	Errors []map[string]interface{} `json:"errors"`
+}
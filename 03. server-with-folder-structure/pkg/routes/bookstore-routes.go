@@ -1,14 +1,56 @@
 package routes
 
 import (
+	"net/http"
+
 	"github.com/gorilla/mux"
 	"go-bookstore/pkg/controllers"
+	"go-bookstore/pkg/gql"
+	"go-bookstore/pkg/middleware"
+	"go-bookstore/pkg/openapi"
 )
 
-var RegisterBookStoreRoutes = func (router *mux.Router)  {
-	router.HandleFunc("/book/", controllers.CreateBook).Methods("POST")
-	router.HandleFunc("/book/", controllers.GetBook).Methods("GET")
-	router.HandleFunc("/book/{bookId}", controllers.GetBookById).Methods("GET")
-	router.HandleFunc("/book/{bookId}", controllers.UpdateBook).Methods("PUT")
-	router.HandleFunc("/book/{bookId}", controllers.DeleteBook).Methods("DELETE")
+var RegisterBookStoreRoutes = func(router *mux.Router) {
+	// Everything under api is scoped to the tenant the request is for, and
+	// reads follow the client's own writes to the primary database.
+	api := router.NewRoute().Subrouter()
+	api.Use(middleware.Tenant, middleware.ReadYourWrites)
+
+	api.HandleFunc("/book/", controllers.CreateBook).Methods("POST")
+	api.HandleFunc("/book/", controllers.GetBook).Methods("GET")
+	api.HandleFunc("/book/stream", controllers.StreamBooks).Methods("GET")
+	api.HandleFunc("/book/ws", controllers.StreamBooksWS).Methods("GET")
+	api.HandleFunc("/book/{bookId}", controllers.GetBookById).Methods("GET")
+	api.HandleFunc("/book/{bookId}", controllers.UpdateBook).Methods("PUT")
+	api.HandleFunc("/book/{bookId}", controllers.DeleteBook).Methods("DELETE")
+	api.HandleFunc("/book/{bookId}/reviews", controllers.GetBookReviews).Methods("GET")
+	api.HandleFunc("/book/{bookId}/reviews", controllers.CreateReview).Methods("POST")
+
+	// The moderation queue is per tenant but needs the admin token.
+	api.Handle("/reviews/", middleware.AdminOnly(http.HandlerFunc(controllers.GetReviewQueue))).Methods("GET")
+	api.Handle("/reviews/{reviewId}", middleware.AdminOnly(http.HandlerFunc(controllers.ModerateReview))).Methods("PUT")
+
+	api.HandleFunc("/webhooks/", controllers.CreateWebhook).Methods("POST")
+	api.HandleFunc("/webhooks/", controllers.GetWebhooks).Methods("GET")
+	api.HandleFunc("/webhooks/dead-letters", controllers.GetDeadLetters).Methods("GET")
+	api.HandleFunc("/webhooks/dead-letters/{deliveryId}/retry", controllers.RetryDeadLetter).Methods("POST")
+	api.HandleFunc("/webhooks/{webhookId}", controllers.GetWebhookById).Methods("GET")
+	api.HandleFunc("/webhooks/{webhookId}", controllers.UpdateWebhook).Methods("PUT")
+	api.HandleFunc("/webhooks/{webhookId}", controllers.DeleteWebhook).Methods("DELETE")
+
+	api.HandleFunc("/graphql", gql.Handler).Methods("GET", "POST")
+
+	admin := router.PathPrefix("/tenants").Subrouter()
+	admin.Use(middleware.AdminOnly)
+
+	admin.HandleFunc("/", controllers.CreateTenant).Methods("POST")
+	admin.HandleFunc("/", controllers.GetTenants).Methods("GET")
+	admin.HandleFunc("/{tenantId}", controllers.GetTenantById).Methods("GET")
+	admin.HandleFunc("/{tenantId}", controllers.UpdateTenant).Methods("PUT")
+	admin.HandleFunc("/{tenantId}", controllers.DeleteTenant).Methods("DELETE")
+	admin.HandleFunc("/{tenantId}/usage", controllers.GetTenantUsage).Methods("GET")
+
+	router.HandleFunc("/openapi.json", openapi.Handler(router, apiInfo, bookStoreSpec)).Methods("GET")
+	router.HandleFunc("/docs", openapi.DocsHandler).Methods("GET")
+	router.HandleFunc("/docs/swagger-ui/{file}", openapi.AssetHandler).Methods("GET")
 }
@@ -0,0 +1,75 @@
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"go-bookstore/pkg/models"
+	"go-bookstore/pkg/openapi"
+)
+
+func TestOpenAPISpecMatchesRoutes(t *testing.T) {
+	r := mux.NewRouter()
+	RegisterBookStoreRoutes(r)
+
+	if err := openapi.Check(r, bookStoreSpec); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOpenAPIBookSchemaMatchesJSON(t *testing.T) {
+	r := mux.NewRouter()
+	RegisterBookStoreRoutes(r)
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /openapi.json: status %d", rec.Code)
+	}
+
+	var doc openapi.Document
+	if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("decoding spec: %v", err)
+	}
+	if doc.OpenAPI != "3.1.0" {
+		t.Errorf("openapi version = %q, want 3.1.0", doc.OpenAPI)
+	}
+	book, ok := doc.Components.Schemas["Book"]
+	if !ok {
+		t.Fatal("spec has no Book schema")
+	}
+
+	// The properties must be exactly the keys the handlers put on the wire.
+	raw, _ := json.Marshal(models.Book{})
+	var wire map[string]interface{}
+	json.Unmarshal(raw, &wire)
+
+	if got, want := keys(book.Properties), keys(wire); !equal(got, want) {
+		t.Errorf("Book schema properties = %v, JSON keys = %v", got, want)
+	}
+}
+
+func keys[V any](m map[string]V) []string {
+	var ks []string
+	for k := range m {
+		ks = append(ks, k)
+	}
+	sort.Strings(ks)
+	return ks
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
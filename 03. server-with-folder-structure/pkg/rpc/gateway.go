@@ -0,0 +1,48 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"go-bookstore/pkg/middleware"
+	pb "go-bookstore/pkg/pb/bookstore/v1"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// NewGateway returns a grpc-gateway handler that serves BookService as JSON
+// under /v1/, forwarding each call to the gRPC server at grpcAddr. The
+// X-Tenant-ID header is passed on as metadata along with the defaults.
+func NewGateway(ctx context.Context, grpcAddr string) (http.Handler, error) {
+	mux := runtime.NewServeMux(runtime.WithIncomingHeaderMatcher(func(key string) (string, bool) {
+		if key == http.CanonicalHeaderKey(middleware.TenantHeader) {
+			return strings.ToLower(key), true
+		}
+		return runtime.DefaultHeaderMatcher(key)
+	}))
+	opts := []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+	if err := pb.RegisterBookServiceHandlerFromEndpoint(ctx, mux, grpcAddr, opts); err != nil {
+		return nil, err
+	}
+	return mux, nil
+}
+
+// DialAddr returns the address at which this process reaches a listener on
+// addr: a wildcard host such as 0.0.0.0 or [::] becomes the loopback
+// address, so the gateway can follow wherever the gRPC server listens.
+func DialAddr(addr net.Addr) string {
+	tcp, ok := addr.(*net.TCPAddr)
+	if !ok || !tcp.IP.IsUnspecified() {
+		return addr.String()
+	}
+	loopback := net.IPv4(127, 0, 0, 1)
+	if tcp.IP.To4() == nil {
+		loopback = net.IPv6loopback
+	}
+	return net.JoinHostPort(loopback.String(), fmt.Sprint(tcp.Port))
+}
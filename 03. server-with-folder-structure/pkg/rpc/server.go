@@ -0,0 +1,195 @@
+package rpc
+
+import (
+	"context"
+	"encoding/base64"
+	"net"
+	"strconv"
+
+	"go-bookstore/pkg/models"
+	pb "go-bookstore/pkg/pb/bookstore/v1"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// bookServer implements BookService on top of the same model functions the
+// REST controllers use.
+type bookServer struct {
+	pb.UnimplementedBookServiceServer
+}
+
+// Serve runs the gRPC server from NewServer on lis until the listener
+// fails.
+func Serve(lis net.Listener) error {
+	return NewServer().Serve(lis)
+}
+
+// NewServer returns a gRPC server with BookService, reflection and the
+// standard health service. BookService calls are scoped to the tenant named
+// in their metadata.
+func NewServer() *grpc.Server {
+	s := grpc.NewServer(
+		grpc.UnaryInterceptor(unaryTenant),
+		grpc.StreamInterceptor(streamTenant),
+	)
+	pb.RegisterBookServiceServer(s, &bookServer{})
+
+	hs := health.NewServer()
+	hs.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+	hs.SetServingStatus(pb.BookService_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(s, hs)
+
+	reflection.Register(s)
+	return s
+}
+
+func (s *bookServer) GetBook(ctx context.Context, req *pb.GetBookRequest) (*pb.Book, error) {
+	book, _ := models.GetBookById(ctx, req.GetId())
+	if book.ID == 0 {
+		return nil, status.Errorf(codes.NotFound, "book %d not found", req.GetId())
+	}
+	return toProto(book), nil
+}
+
+func (s *bookServer) ListBooks(ctx context.Context, req *pb.ListBooksRequest) (*pb.ListBooksResponse, error) {
+	size := int(req.GetPageSize())
+	if size <= 0 {
+		size = defaultPageSize
+	}
+	if size > maxPageSize {
+		size = maxPageSize
+	}
+	offset, err := decodePageToken(req.GetPageToken())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid page_token")
+	}
+
+	books, total := models.FindBooks(ctx, models.BookFilter{Limit: size, Offset: offset})
+
+	res := &pb.ListBooksResponse{TotalSize: total}
+	for i := range books {
+		res.Books = append(res.Books, toProto(&books[i]))
+	}
+	if next := offset + len(books); int64(next) < total {
+		res.NextPageToken = encodePageToken(next)
+	}
+	return res, nil
+}
+
+func (s *bookServer) CreateBook(ctx context.Context, req *pb.CreateBookRequest) (*pb.Book, error) {
+	if req.GetBook() == nil {
+		return nil, status.Error(codes.InvalidArgument, "book is required")
+	}
+	book, err := fromProto(req.GetBook()).CreateBook(ctx)
+	if err == models.ErrQuotaExceeded {
+		return nil, status.Error(codes.ResourceExhausted, err.Error())
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return toProto(book), nil
+}
+
+func (s *bookServer) UpdateBook(ctx context.Context, req *pb.UpdateBookRequest) (*pb.Book, error) {
+	if req.GetBook() == nil {
+		return nil, status.Error(codes.InvalidArgument, "book is required")
+	}
+	book, err := models.UpdateBook(ctx, req.GetId(), fromProto(req.GetBook()))
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if book == nil {
+		return nil, status.Errorf(codes.NotFound, "book %d not found", req.GetId())
+	}
+	return toProto(book), nil
+}
+
+func (s *bookServer) DeleteBook(ctx context.Context, req *pb.DeleteBookRequest) (*pb.Book, error) {
+	book, err := models.DeleteBook(ctx, req.GetId())
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if book.ID == 0 {
+		return nil, status.Errorf(codes.NotFound, "book %d not found", req.GetId())
+	}
+	return toProto(&book), nil
+}
+
+func (s *bookServer) WatchBooks(req *pb.WatchBooksRequest, stream pb.BookService_WatchBooksServer) error {
+	tenant, _ := models.TenantFrom(stream.Context())
+	events, cancel := models.Subscribe()
+	defer cancel()
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case e, ok := <-events:
+			if !ok {
+				return status.Error(codes.Aborted, "watch fell behind the changes; reload the books and watch again")
+			}
+			if e.Book.TenantID != tenant {
+				continue
+			}
+			if err := stream.Send(&pb.BookEvent{Type: eventTypes[e.Type], Book: toProto(&e.Book)}); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+var eventTypes = map[models.EventType]pb.BookEvent_Type{
+	models.BookCreated: pb.BookEvent_CREATED,
+	models.BookUpdated: pb.BookEvent_UPDATED,
+	models.BookDeleted: pb.BookEvent_DELETED,
+}
+
+func toProto(b *models.Book) *pb.Book {
+	return &pb.Book{
+		Id:          int64(b.ID),
+		Name:        b.Name,
+		Author:      b.Author,
+		Publication: b.Publication,
+		CreateTime:  timestamppb.New(b.CreatedAt),
+		UpdateTime:  timestamppb.New(b.UpdatedAt),
+	}
+}
+
+func fromProto(b *pb.Book) *models.Book {
+	return &models.Book{
+		Name:        b.GetName(),
+		Author:      b.GetAuthor(),
+		Publication: b.GetPublication(),
+	}
+}
+
+// Page tokens are opaque to clients but are just an encoded offset.
+func encodePageToken(offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(offset)))
+}
+
+func decodePageToken(token string) (int, error) {
+	if token == "" {
+		return 0, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return 0, err
+	}
+	offset, err := strconv.Atoi(string(raw))
+	if err != nil || offset < 0 {
+		return 0, strconv.ErrSyntax
+	}
+	return offset, nil
+}
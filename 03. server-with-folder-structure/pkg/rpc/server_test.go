@@ -0,0 +1,252 @@
+package rpc_test
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"go-bookstore/pkg/config"
+	"go-bookstore/pkg/models"
+	pb "go-bookstore/pkg/pb/bookstore/v1"
+	"go-bookstore/pkg/rpc"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// newTestConn serves rpc.NewServer in memory, backed by a new in-memory
+// database with the tenants default and acme, and returns a connection to
+// it.
+func newTestConn(t *testing.T) *grpc.ClientConn {
+	t.Helper()
+	dsn := fmt.Sprintf("file:%s?mode=memory&cache=shared", url.PathEscape(t.Name()))
+	d, err := config.Open("sqlite", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		sqlDB, _ := d.DB()
+		sqlDB.Close()
+	})
+	models.Setup(d)
+	if _, err := (&models.Tenant{ID: "acme", Name: "Acme"}).CreateTenant(); err != nil {
+		t.Fatal(err)
+	}
+
+	lis := bufconn.Listen(1 << 20)
+	s := rpc.NewServer()
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func tenantCtx(id string) context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), "x-tenant-id", id)
+}
+
+func create(t *testing.T, c pb.BookServiceClient, ctx context.Context, name, author string) *pb.Book {
+	t.Helper()
+	b, err := c.CreateBook(ctx, &pb.CreateBookRequest{Book: &pb.Book{Name: name, Author: author, Publication: "Pub"}})
+	if err != nil {
+		t.Fatalf("creating %q: %v", name, err)
+	}
+	return b
+}
+
+func wantCode(t *testing.T, what string, err error, code codes.Code) {
+	t.Helper()
+	if status.Code(err) != code {
+		t.Errorf("%s: %v, want %s", what, err, code)
+	}
+}
+
+func TestBookLifecycle(t *testing.T) {
+	c := pb.NewBookServiceClient(newTestConn(t))
+	ctx := tenantCtx(models.DefaultTenant)
+
+	b := create(t, c, ctx, "Go", "Pike")
+	if b.Id == 0 || b.CreateTime == nil || b.UpdateTime == nil {
+		t.Errorf("created %+v", b)
+	}
+	_, err := c.CreateBook(ctx, &pb.CreateBookRequest{})
+	wantCode(t, "create without a book", err, codes.InvalidArgument)
+
+	got, err := c.GetBook(ctx, &pb.GetBookRequest{Id: b.Id})
+	if err != nil || got.Name != "Go" || got.Author != "Pike" {
+		t.Errorf("get: %+v, %v", got, err)
+	}
+	_, err = c.GetBook(ctx, &pb.GetBookRequest{Id: b.Id + 1})
+	wantCode(t, "get a missing book", err, codes.NotFound)
+
+	// fields left empty are kept
+	got, err = c.UpdateBook(ctx, &pb.UpdateBookRequest{Id: b.Id, Book: &pb.Book{Name: "The Go Programming Language"}})
+	if err != nil || got.Name != "The Go Programming Language" || got.Author != "Pike" {
+		t.Errorf("update: %+v, %v", got, err)
+	}
+	_, err = c.UpdateBook(ctx, &pb.UpdateBookRequest{Id: b.Id + 1, Book: &pb.Book{Name: "x"}})
+	wantCode(t, "update a missing book", err, codes.NotFound)
+	_, err = c.UpdateBook(ctx, &pb.UpdateBookRequest{Id: b.Id})
+	wantCode(t, "update without a book", err, codes.InvalidArgument)
+
+	got, err = c.DeleteBook(ctx, &pb.DeleteBookRequest{Id: b.Id})
+	if err != nil || got.Id != b.Id {
+		t.Errorf("delete: %+v, %v", got, err)
+	}
+	_, err = c.GetBook(ctx, &pb.GetBookRequest{Id: b.Id})
+	wantCode(t, "get a deleted book", err, codes.NotFound)
+	_, err = c.DeleteBook(ctx, &pb.DeleteBookRequest{Id: b.Id})
+	wantCode(t, "delete a deleted book", err, codes.NotFound)
+}
+
+func TestListBooksPaging(t *testing.T) {
+	c := pb.NewBookServiceClient(newTestConn(t))
+	ctx := tenantCtx(models.DefaultTenant)
+	for i := 0; i < 5; i++ {
+		create(t, c, ctx, fmt.Sprint("Book ", i), "Author")
+	}
+
+	var names []string
+	req := &pb.ListBooksRequest{PageSize: 2}
+	for pages := 0; ; pages++ {
+		if pages == 3 {
+			t.Fatal("still more pages after 3 of 2 books out of 5")
+		}
+		res, err := c.ListBooks(ctx, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.TotalSize != 5 {
+			t.Errorf("total_size = %d, want 5", res.TotalSize)
+		}
+		for _, b := range res.Books {
+			names = append(names, b.Name)
+		}
+		if res.NextPageToken == "" {
+			break
+		}
+		req.PageToken = res.NextPageToken
+	}
+	if fmt.Sprint(names) != "[Book 0 Book 1 Book 2 Book 3 Book 4]" {
+		t.Errorf("pages held %v", names)
+	}
+
+	_, err := c.ListBooks(ctx, &pb.ListBooksRequest{PageToken: "not a token"})
+	wantCode(t, "list with a bad page token", err, codes.InvalidArgument)
+}
+
+// Calls are scoped to the tenant in their x-tenant-id metadata, as REST
+// requests are to the X-Tenant-ID header.
+func TestTenantMetadata(t *testing.T) {
+	c := pb.NewBookServiceClient(newTestConn(t))
+	b := create(t, c, tenantCtx("acme"), "Acme book", "Aho")
+	create(t, c, context.Background(), "Default book", "Kernighan")
+
+	_, err := c.GetBook(tenantCtx(models.DefaultTenant), &pb.GetBookRequest{Id: b.Id})
+	wantCode(t, "get another tenant's book", err, codes.NotFound)
+	_, err = c.DeleteBook(context.Background(), &pb.DeleteBookRequest{Id: b.Id})
+	wantCode(t, "delete another tenant's book", err, codes.NotFound)
+
+	res, err := c.ListBooks(tenantCtx("acme"), &pb.ListBooksRequest{})
+	if err != nil || len(res.Books) != 1 || res.Books[0].Name != "Acme book" {
+		t.Errorf("acme's books: %v, %v", res, err)
+	}
+
+	_, err = c.ListBooks(tenantCtx("nobody"), &pb.ListBooksRequest{})
+	wantCode(t, "list for an unknown tenant", err, codes.NotFound)
+}
+
+func TestWatchBooks(t *testing.T) {
+	c := pb.NewBookServiceClient(newTestConn(t))
+	ctx, cancel := context.WithTimeout(tenantCtx(models.DefaultTenant), 5*time.Second)
+	defer cancel()
+
+	stream, err := c.WatchBooks(ctx, &pb.WatchBooksRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The server subscribes some time after the call starts, so keep
+	// changing books until events arrive. Each round changes a book of
+	// acme first, which the watch must not see.
+	done, stopped := make(chan struct{}), make(chan struct{})
+	defer func() {
+		close(done)
+		<-stopped
+	}()
+	go func() {
+		defer close(stopped)
+		for {
+			c.CreateBook(tenantCtx("acme"), &pb.CreateBookRequest{Book: &pb.Book{Name: "Acme book"}})
+			if b, err := c.CreateBook(ctx, &pb.CreateBookRequest{Book: &pb.Book{Name: "Go"}}); err == nil {
+				c.UpdateBook(ctx, &pb.UpdateBookRequest{Id: b.Id, Book: &pb.Book{Author: "Kernighan"}})
+				c.DeleteBook(ctx, &pb.DeleteBookRequest{Id: b.Id})
+			}
+			select {
+			case <-done:
+				return
+			case <-time.After(20 * time.Millisecond):
+			}
+		}
+	}()
+
+	var types []pb.BookEvent_Type
+	for len(types) < 3 {
+		e, err := stream.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e.Book.Name != "Go" {
+			t.Fatalf("the watch got %s of %q from another tenant", e.Type, e.Book.Name)
+		}
+		// start from the first creation seen
+		if len(types) > 0 || e.Type == pb.BookEvent_CREATED {
+			types = append(types, e.Type)
+		}
+	}
+	if fmt.Sprint(types) != "[CREATED UPDATED DELETED]" {
+		t.Errorf("events %v, want a creation, an update and a deletion", types)
+	}
+}
+
+func TestHealth(t *testing.T) {
+	c := healthpb.NewHealthClient(newTestConn(t))
+	for _, service := range []string{"", pb.BookService_ServiceDesc.ServiceName} {
+		res, err := c.Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+		if err != nil || res.Status != healthpb.HealthCheckResponse_SERVING {
+			t.Errorf("health of %q: %v, %v", service, res, err)
+		}
+	}
+}
+
+func TestDialAddr(t *testing.T) {
+	for addr, want := range map[string]string{
+		"0.0.0.0:9090":   "127.0.0.1:9090",
+		"[::]:9090":      "[::1]:9090",
+		"10.0.0.5:9090":  "10.0.0.5:9090",
+		"127.0.0.1:9091": "127.0.0.1:9091",
+	} {
+		tcp, err := net.ResolveTCPAddr("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := rpc.DialAddr(tcp); got != want {
+			t.Errorf("DialAddr(%s) = %s, want %s", addr, got, want)
+		}
+	}
+}
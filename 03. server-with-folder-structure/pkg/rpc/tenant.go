@@ -0,0 +1,87 @@
+package rpc
+
+import (
+	"context"
+	"net/http"
+	"net/textproto"
+	"strings"
+
+	"go-bookstore/pkg/middleware"
+	"go-bookstore/pkg/models"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// tenantContext resolves the tenant of a call the same way the REST API
+// does, reading the x-tenant-id and authorization metadata instead of
+// headers. Calls through the gateway carry the original Host as
+// x-forwarded-host.
+func tenantContext(ctx context.Context) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	h := http.Header{}
+	for k, v := range md {
+		h[textproto.CanonicalMIMEHeaderKey(k)] = v
+	}
+	host := first(md, "x-forwarded-host")
+	if host == "" {
+		host = first(md, ":authority")
+	}
+
+	id, err := middleware.ResolveTenant(h, host)
+	if err != nil {
+		return nil, status.Error(tenantCode(err), err.Error())
+	}
+	return models.WithTenant(ctx, id), nil
+}
+
+func first(md metadata.MD, key string) string {
+	if v := md.Get(key); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+func tenantCode(err error) codes.Code {
+	switch err {
+	case middleware.ErrNoToken, middleware.ErrBadToken:
+		return codes.Unauthenticated
+	case middleware.ErrTenantMismatch:
+		return codes.PermissionDenied
+	default:
+		return codes.NotFound
+	}
+}
+
+func unaryTenant(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if !strings.HasPrefix(info.FullMethod, "/bookstore.") {
+		return handler(ctx, req)
+	}
+	ctx, err := tenantContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func streamTenant(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if !strings.HasPrefix(info.FullMethod, "/bookstore.") {
+		return handler(srv, ss)
+	}
+	ctx, err := tenantContext(ss.Context())
+	if err != nil {
+		return err
+	}
+	return handler(srv, &tenantStream{ServerStream: ss, ctx: ctx})
+}
+
+type tenantStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *tenantStream) Context() context.Context {
+	return s.ctx
+}
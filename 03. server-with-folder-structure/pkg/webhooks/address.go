@@ -0,0 +1,73 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// Errors from CheckURL, worded for the client that registered the URL.
+var (
+	ErrBadURL     = errors.New("url must be an absolute http or https URL")
+	ErrPrivateURL = errors.New("url must not point at a private, loopback or link-local address")
+)
+
+// CheckURL makes sure raw is an absolute http or https URL whose host
+// resolves to public addresses only, so that webhooks can't be used to
+// reach the server's own network. The dispatcher checks again each time it
+// connects, since what a name resolves to can change after registration.
+func CheckURL(ctx context.Context, raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return ErrBadURL
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, u.Hostname())
+	if err != nil {
+		return fmt.Errorf("url host %s does not resolve", u.Hostname())
+	}
+	for _, addr := range addrs {
+		if !publicIP(addr.IP) {
+			return ErrPrivateURL
+		}
+	}
+	return nil
+}
+
+func publicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast())
+}
+
+// refusePrivate is a net.Dialer Control that fails connections to
+// addresses CheckURL would refuse. It runs after resolution, on the
+// address actually dialled, redirects included.
+func refusePrivate(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !publicIP(ip) {
+		return fmt.Errorf("webhooks: refusing to connect to %s: %w", host, ErrPrivateURL)
+	}
+	return nil
+}
+
+// newClient returns the dispatcher's HTTP client, which connects directly,
+// not through a proxy, and only to public addresses.
+func newClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   10 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   refusePrivate,
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{Timeout: 10 * time.Second, Transport: transport}
+}
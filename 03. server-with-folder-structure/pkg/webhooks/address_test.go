@@ -0,0 +1,45 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCheckURL(t *testing.T) {
+	for _, c := range []struct {
+		url  string
+		want error
+	}{
+		{"https://203.0.113.10/hook", nil},
+		{"http://[2001:db8::1]:8080/hook", nil},
+		{"ftp://203.0.113.10/hook", ErrBadURL},
+		{"/hook", ErrBadURL},
+		{"http://127.0.0.1/hook", ErrPrivateURL},
+		{"http://[::1]/hook", ErrPrivateURL},
+		{"http://localhost:8080/hook", ErrPrivateURL},
+		{"http://10.1.2.3/hook", ErrPrivateURL},
+		{"http://192.168.0.1/hook", ErrPrivateURL},
+		{"http://169.254.169.254/latest/meta-data", ErrPrivateURL},
+		{"http://[fe80::1]/hook", ErrPrivateURL},
+		{"http://0.0.0.0/hook", ErrPrivateURL},
+	} {
+		if err := CheckURL(context.Background(), c.url); !errors.Is(err, c.want) {
+			t.Errorf("CheckURL(%s) = %v, want %v", c.url, err, c.want)
+		}
+	}
+}
+
+// The dispatcher's own client refuses what CheckURL does when it dials, in
+// case a name resolves somewhere else by then.
+func TestClientRefusesPrivateAddresses(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	_, err := NewDispatcher().Client.Get(srv.URL)
+	if !errors.Is(err, ErrPrivateURL) {
+		t.Errorf("GET %s: %v, want it refused", srv.URL, err)
+	}
+}
@@ -0,0 +1,151 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go-bookstore/pkg/models"
+)
+
+// Signature headers sent with every delivery. The signature is the hex
+// HMAC-SHA256, keyed with the webhook's secret, of the timestamp header, a
+// ".", and the request body; receivers should recompute it and reject stale
+// timestamps.
+const (
+	SignatureHeader = "X-Bookstore-Signature"
+	TimestampHeader = "X-Bookstore-Timestamp"
+	EventHeader     = "X-Bookstore-Event"
+	DeliveryHeader  = "X-Bookstore-Delivery"
+)
+
+// Dispatcher moves events from the outbox to the subscribed webhooks. The
+// Client from NewDispatcher only connects to public addresses.
+type Dispatcher struct {
+	Client       *http.Client
+	PollInterval time.Duration
+	BatchSize    int
+
+	// A failed delivery is retried after BaseBackoff, then twice that, and
+	// so on up to MaxBackoff. After MaxAttempts it is dead-lettered.
+	MaxAttempts int
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+}
+
+func NewDispatcher() *Dispatcher {
+	return &Dispatcher{
+		Client:       newClient(),
+		PollInterval: time.Second,
+		BatchSize:    100,
+		MaxAttempts:  8,
+		BaseBackoff:  5 * time.Second,
+		MaxBackoff:   time.Hour,
+	}
+}
+
+// Run polls the outbox until ctx is cancelled.
+func (d *Dispatcher) Run(ctx context.Context) {
+	t := time.NewTicker(d.PollInterval)
+	defer t.Stop()
+
+	for {
+		d.Tick(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// Tick fans new outbox events out into deliveries and attempts every
+// delivery that is due, across all tenants.
+func (d *Dispatcher) Tick(ctx context.Context) {
+	ctx = models.AllTenants(ctx)
+	for _, e := range models.PendingOutboxEvents(ctx, d.BatchSize) {
+		if err := models.DispatchOutboxEvent(ctx, &e); err != nil {
+			log.Printf("webhooks: dispatching outbox event %d: %v", e.ID, err)
+			return
+		}
+	}
+
+	for _, delivery := range models.DueDeliveries(ctx, time.Now(), d.BatchSize) {
+		if ctx.Err() != nil {
+			return
+		}
+		d.attempt(ctx, &delivery)
+	}
+}
+
+func (d *Dispatcher) attempt(ctx context.Context, delivery *models.WebhookDelivery) {
+	err := d.send(ctx, delivery)
+	if err == nil {
+		delivery.MarkDelivered(ctx)
+		return
+	}
+
+	attempts := delivery.Attempts + 1
+	dead := attempts >= d.MaxAttempts
+	delivery.MarkFailed(ctx, err.Error(), time.Now().Add(d.backoff(attempts)), dead)
+	if dead {
+		log.Printf("webhooks: delivery %d to %s dead-lettered after %d attempts: %v",
+			delivery.ID, delivery.Webhook.URL, attempts, err)
+	}
+}
+
+func (d *Dispatcher) send(ctx context.Context, delivery *models.WebhookDelivery) error {
+	body := []byte(delivery.Event.Payload)
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.Webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, string(delivery.Event.Type))
+	req.Header.Set(DeliveryHeader, strconv.FormatUint(uint64(delivery.ID), 10))
+	req.Header.Set(TimestampHeader, ts)
+	req.Header.Set(SignatureHeader, "sha256="+Sign(delivery.Webhook.Secret, ts, body))
+
+	res, err := d.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(res.Body, 1<<16))
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", res.Status)
+	}
+	return nil
+}
+
+// backoff returns the wait before the attempt after the given one.
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	wait := d.BaseBackoff
+	for i := 1; i < attempts && wait < d.MaxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > d.MaxBackoff {
+		wait = d.MaxBackoff
+	}
+	return wait
+}
+
+// Sign computes the signature a receiver should expect for body.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,168 @@
+package webhooks
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"go-bookstore/pkg/config"
+	"go-bookstore/pkg/models"
+)
+
+func TestSign(t *testing.T) {
+	got := Sign("secret", "1700000000", []byte(`{"type":"book.created"}`))
+	if want := "cea64377d77cfb865366a43e64857c5c8031ccf5c74f41adc49a5ba1877c09fb"; got != want {
+		t.Errorf("Sign = %s, want %s", got, want)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	d := &Dispatcher{BaseBackoff: 5 * time.Second, MaxBackoff: time.Minute}
+	for _, c := range []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 5 * time.Second},
+		{2, 10 * time.Second},
+		{3, 20 * time.Second},
+		{4, 40 * time.Second},
+		{5, time.Minute},
+		{6, time.Minute},
+		{60, time.Minute},
+	} {
+		if got := d.backoff(c.attempts); got != c.want {
+			t.Errorf("backoff(%d) = %s, want %s", c.attempts, got, c.want)
+		}
+	}
+}
+
+// receiver is a webhook endpoint that answers with status and keeps what
+// it was sent.
+type receiver struct {
+	*httptest.Server
+	mu       sync.Mutex
+	status   int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func newReceiver(t *testing.T) *receiver {
+	rc := &receiver{status: http.StatusInternalServerError}
+	rc.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		rc.mu.Lock()
+		defer rc.mu.Unlock()
+		rc.requests = append(rc.requests, r)
+		rc.bodies = append(rc.bodies, body)
+		w.WriteHeader(rc.status)
+	}))
+	t.Cleanup(rc.Close)
+	return rc
+}
+
+func (rc *receiver) answer(status int) {
+	rc.mu.Lock()
+	rc.status = status
+	rc.mu.Unlock()
+}
+
+func (rc *receiver) count() int {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return len(rc.requests)
+}
+
+func TestDispatcher(t *testing.T) {
+	dsn := fmt.Sprintf("file:%s?mode=memory&cache=shared", url.PathEscape(t.Name()))
+	db, err := config.Open("sqlite", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		sqlDB, _ := db.DB()
+		sqlDB.Close()
+	})
+	models.Setup(db)
+
+	rc := newReceiver(t)
+	ctx := models.WithTenant(context.Background(), models.DefaultTenant)
+	hook, err := (&models.Webhook{URL: rc.URL, Secret: "secret"}).CreateWebhook(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (&models.Book{Name: "Go", Author: "Pike", Publication: "Pub"}).CreateBook(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// a backoff short enough for every tick to find the delivery due, and
+	// a client that may connect to the receiver on the loopback address
+	d := NewDispatcher()
+	d.Client = rc.Client()
+	d.MaxAttempts = 3
+	d.BaseBackoff = time.Nanosecond
+	d.MaxBackoff = time.Nanosecond
+
+	for attempt := 1; attempt <= d.MaxAttempts+1; attempt++ {
+		d.Tick(context.Background())
+		want := attempt
+		if want > d.MaxAttempts {
+			want = d.MaxAttempts // dead letters aren't tried
+		}
+		if n := rc.count(); n != want {
+			t.Fatalf("after tick %d the webhook got %d requests, want %d", attempt, n, want)
+		}
+	}
+	dead := models.DeadLetters(ctx)
+	if len(dead) != 1 || dead[0].Attempts != d.MaxAttempts || dead[0].WebhookID != hook.ID ||
+		dead[0].LastError != "unexpected status 500 Internal Server Error" {
+		t.Fatalf("dead letters = %+v", dead)
+	}
+
+	// retried, it is delivered once the receiver takes it
+	rc.answer(http.StatusNoContent)
+	models.RetryDeadLetter(ctx, int64(dead[0].ID))
+	d.Tick(context.Background())
+	d.Tick(context.Background())
+	if n := rc.count(); n != d.MaxAttempts+1 {
+		t.Fatalf("the webhook got %d requests, want %d", n, d.MaxAttempts+1)
+	}
+	r, body := rc.requests[d.MaxAttempts], rc.bodies[d.MaxAttempts]
+	ts := r.Header.Get(TimestampHeader)
+	if _, err := strconv.ParseInt(ts, 10, 64); err != nil {
+		t.Errorf("%s = %q", TimestampHeader, ts)
+	}
+	for header, want := range map[string]string{
+		SignatureHeader: "sha256=" + Sign("secret", ts, body),
+		EventHeader:     string(models.BookCreated),
+		DeliveryHeader:  strconv.FormatUint(uint64(dead[0].ID), 10),
+		"Content-Type":  "application/json",
+	} {
+		if got := r.Header.Get(header); got != want {
+			t.Errorf("%s = %q, want %q", header, got, want)
+		}
+	}
+	if len(models.DeadLetters(ctx)) != 0 {
+		t.Error("the delivered delivery is still a dead letter")
+	}
+
+	// a failure waits for the backoff before the next attempt
+	d.BaseBackoff, d.MaxBackoff = time.Hour, time.Hour
+	rc.answer(http.StatusBadGateway)
+	models.UpdateBook(ctx, 1, &models.Book{Name: "Go 2"})
+	d.Tick(context.Background())
+	d.Tick(context.Background())
+	if n := rc.count(); n != d.MaxAttempts+2 {
+		t.Errorf("the webhook got %d requests, want one more", n)
+	}
+	due := models.DueDeliveries(models.AllTenants(ctx), time.Now().Add(time.Hour+time.Minute), 10)
+	if len(due) != 1 || due[0].Attempts != 1 || due[0].NextAttemptAt.Before(time.Now().Add(59*time.Minute)) {
+		t.Errorf("failed delivery = %+v, want one attempt and the next in an hour", due)
+	}
+}